@@ -1,28 +1,56 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"charmtool/internal/handlers"
+	"charmtool/internal/models"
 	"charmtool/internal/storage"
 )
 
 const (
-	defaultPort    = "8080"
-	sessionMaxAge  = 10 * 24 * time.Hour // 10 days
-	cleanupInterval = 1 * time.Hour
+	defaultPort               = "8080"
+	defaultSessionMaxAgeDays  = 10
+	defaultCleanupIntervalMin = 60
+	defaultMaxSessions        = 10000
+	shutdownTimeout           = 15 * time.Second
 )
 
+// positiveIntEnv returns the positive integer value of an environment
+// variable, or def if it is unset. Invalid values are fatal.
+func positiveIntEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s %q", name, v)
+	}
+	return n
+}
+
 func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = defaultPort
 	}
 
+	// Session retention (SESSION_MAX_AGE_DAYS) and cleanup interval (CLEANUP_INTERVAL_MIN)
+	sessionMaxAge := time.Duration(positiveIntEnv("SESSION_MAX_AGE_DAYS", defaultSessionMaxAgeDays)) * 24 * time.Hour
+	cleanupInterval := time.Duration(positiveIntEnv("CLEANUP_INTERVAL_MIN", defaultCleanupIntervalMin)) * time.Minute
+
 	// Initialize file storage
 	dataDir := filepath.Join(".", "data", "sessions")
 	store, err := storage.NewFileStore(dataDir, sessionMaxAge)
@@ -30,19 +58,62 @@ func main() {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
+	// Cap on stored sessions; the least recently used are evicted (MAX_SESSIONS)
+	store.SetMaxSessions(positiveIntEnv("MAX_SESSIONS", defaultMaxSessions))
+
 	// Start cleanup goroutine
 	go func() {
 		ticker := time.NewTicker(cleanupInterval)
 		defer ticker.Stop()
 		for range ticker.C {
-			if err := store.Cleanup(); err != nil {
+			removed, err := store.Cleanup()
+			if err != nil {
 				log.Printf("Cleanup error: %v", err)
+			} else if removed > 0 {
+				log.Printf("Cleaned up %d expired sessions", removed)
 			}
 		}
 	}()
 
-	// Create handler with storage
-	h := handlers.New(store)
+	// Upload size limit in MB (default 10)
+	maxUploadMB := positiveIntEnv("MAX_UPLOAD_MB", handlers.DefaultMaxUploadMB)
+
+	// Cross-origin access: comma-separated origins, e.g. "https://a.example,https://b.example"
+	var allowedOrigins []string
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		allowedOrigins = strings.Split(v, ",")
+	}
+
+	// Station defaults: JSON overrides, e.g. '{"height":0.8,"nthreshold":120}'
+	if v := os.Getenv("STATION_DEFAULTS"); v != "" {
+		defaults := models.BuiltinStationDefaults
+		if err := json.Unmarshal([]byte(v), &defaults); err != nil {
+			log.Fatalf("Invalid STATION_DEFAULTS: %v", err)
+		}
+		if err := models.SetStationDefaults(defaults); err != nil {
+			log.Fatalf("Invalid STATION_DEFAULTS: %v", err)
+		}
+	}
+
+	// Rotation step (degrees) angles are snapped to on export, e.g. "1"; 0 disables
+	if v := os.Getenv("ANGLE_STEP"); v != "" {
+		step, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid ANGLE_STEP %q: %v", v, err)
+		}
+		if err := models.SetAngleStep(step); err != nil {
+			log.Fatalf("Invalid ANGLE_STEP: %v", err)
+		}
+	}
+
+	// Create handler with storage; admin endpoints stay disabled without ADMIN_TOKEN
+	h := handlers.New(store, maxUploadMB, os.Getenv("ADMIN_TOKEN"), allowedOrigins)
+
+	// Session cookies last as long as the server keeps the session
+	h.SetSessionMaxAge(sessionMaxAge)
+
+	// New sessions per client IP per minute (SESSION_RATE_PER_MIN)
+	h.SetSessionRateLimit(positiveIntEnv("SESSION_RATE_PER_MIN", handlers.DefaultSessionsPerMinute))
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -50,13 +121,59 @@ func main() {
 	// API routes (session middleware applied)
 	mux.Handle("/api/upload/pos", h.SessionMiddleware(http.HandlerFunc(h.UploadPOS)))
 	mux.Handle("/api/upload/stack", h.SessionMiddleware(http.HandlerFunc(h.UploadStack)))
-	mux.Handle("/api/xfile", h.SessionMiddleware(http.HandlerFunc(h.GetXFile)))
+	mux.Handle("/api/upload/dpv", h.SessionMiddleware(http.HandlerFunc(h.UploadDPV)))
+	mux.Handle("/api/upload/bom", h.SessionMiddleware(http.HandlerFunc(h.UploadBOM)))
+	mux.Handle("/api/components/dnp", h.SessionMiddleware(http.HandlerFunc(h.BulkDNP)))
+	mux.Handle("/api/xfile", h.SessionMiddleware(handlers.GzipMiddleware(http.HandlerFunc(h.GetXFile))))
 	mux.Handle("/api/xfile/update", h.SessionMiddleware(http.HandlerFunc(h.UpdateXFile)))
+	mux.Handle("/api/xfile/patch", h.SessionMiddleware(http.HandlerFunc(h.PatchXFile)))
+	mux.Handle("/api/reset", h.SessionMiddleware(http.HandlerFunc(h.Reset)))
+	mux.Handle("/api/undo", h.SessionMiddleware(http.HandlerFunc(h.Undo)))
+	mux.Handle("/api/renumber", h.SessionMiddleware(http.HandlerFunc(h.Renumber)))
+	mux.Handle("/api/panel", h.SessionMiddleware(http.HandlerFunc(h.UpdatePanel)))
+	mux.Handle("/api/stations/assign", h.SessionMiddleware(http.HandlerFunc(h.AssignStations)))
+	mux.Handle("/api/stations/merge", h.SessionMiddleware(http.HandlerFunc(h.MergeStations)))
+	mux.Handle("/api/stations/summary", h.SessionMiddleware(http.HandlerFunc(h.Stations)))
+	mux.Handle("/api/stations/reorder", h.SessionMiddleware(http.HandlerFunc(h.ReorderStations)))
+	mux.Handle("/api/stations/coord", h.SessionMiddleware(http.HandlerFunc(h.SetStationCoord)))
+	mux.Handle("/api/heads/assign", h.SessionMiddleware(http.HandlerFunc(h.AssignHeads)))
+	mux.Handle("/api/stations/feedrates", h.SessionMiddleware(http.HandlerFunc(h.SuggestFeedRates)))
+	mux.Handle("/api/stations/delays", h.SessionMiddleware(http.HandlerFunc(h.SuggestDelays)))
+	mux.Handle("/api/stations/vision", h.SessionMiddleware(http.HandlerFunc(h.TuneVision)))
+	mux.Handle("/api/stations/vision/off", h.SessionMiddleware(http.HandlerFunc(h.VisionOff)))
+	mux.Handle("/api/offset", h.SessionMiddleware(http.HandlerFunc(h.SetOffset)))
+	mux.Handle("/api/offset/auto", h.SessionMiddleware(http.HandlerFunc(h.AutoOffset)))
+	mux.Handle("/api/transform", h.SessionMiddleware(http.HandlerFunc(h.Transform)))
+	mux.Handle("/api/nudge", h.SessionMiddleware(http.HandlerFunc(h.Nudge)))
 	mux.Handle("/api/export", h.SessionMiddleware(http.HandlerFunc(h.Export)))
-	mux.Handle("/api/validate", h.SessionMiddleware(http.HandlerFunc(h.Validate)))
+	mux.Handle("/api/export/dpv", h.SessionMiddleware(http.HandlerFunc(h.ExportDPV)))
+	validate := h.SessionMiddleware(http.HandlerFunc(h.Validate))
+	mux.HandleFunc("/api/validate", func(w http.ResponseWriter, r *http.Request) {
+		// POST validates the body and needs no session
+		if r.Method == http.MethodPost || r.Method == http.MethodOptions {
+			h.ValidateBody(w, r)
+			return
+		}
+		validate.ServeHTTP(w, r)
+	})
+	mux.Handle("/api/heatmap", h.SessionMiddleware(http.HandlerFunc(h.Heatmap)))
+	mux.Handle("/api/preview.svg", h.SessionMiddleware(http.HandlerFunc(h.PlacementSVG)))
+	mux.Handle("/api/diff", h.SessionMiddleware(http.HandlerFunc(h.Diff)))
 	mux.Handle("/api/stacks/export", h.SessionMiddleware(http.HandlerFunc(h.StacksExport)))
 	mux.Handle("/api/stacks/import", h.SessionMiddleware(http.HandlerFunc(h.StacksImport)))
+	mux.Handle("/api/feeders/csv", h.SessionMiddleware(http.HandlerFunc(h.FeedersCSV)))
+	mux.Handle("/api/session/export", h.SessionMiddleware(http.HandlerFunc(h.ExportSession)))
+	mux.Handle("/api/session/import", h.SessionMiddleware(http.HandlerFunc(h.ImportSession)))
+	mux.HandleFunc("/api/session", h.DeleteSession) // No session middleware: must not recreate the session
+	mux.Handle("/api/projects", h.SessionMiddleware(http.HandlerFunc(h.Projects)))
 	mux.HandleFunc("/api/stats", h.GetStats) // No session middleware needed for stats
+	mux.HandleFunc("/api/admin/cleanup", h.AdminCleanup)
+	mux.HandleFunc("/api/defaults", h.Defaults)          // Server-wide, POST requires X-Admin-Token
+	mux.HandleFunc("/api/angle-offsets", h.AngleOffsets) // Server-wide, POST requires X-Admin-Token
+
+	// Load balancer probes
+	mux.HandleFunc("/healthz", h.Healthz)
+	mux.HandleFunc("/readyz", h.Readyz)
 
 	// Static files
 	staticDir := filepath.Join(".", "web", "static")
@@ -65,7 +182,35 @@ func main() {
 	log.Printf("CharmTool server starting on port %s", port)
 	log.Printf("Open http://localhost:%s in your browser", port)
 
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// Load stored sessions while serving; /readyz returns 503 until done
+	go func() {
+		if err := store.Load(); err != nil {
+			// Log but don't fail - start fresh
+			log.Printf("Warning: could not load existing sessions: %v", err)
+		}
+		log.Printf("Loaded %d sessions", store.SessionCount())
+	}()
+
+	// Stop on SIGINT/SIGTERM: finish in-flight requests, then flush storage
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("Received %v, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown error: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		log.Printf("Failed to flush sessions: %v", err)
 	}
+	log.Printf("Server stopped")
 }
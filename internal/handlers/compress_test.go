@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"charmtool/internal/models"
+)
+
+func TestGzipMiddlewareXFile(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := validBoard()
+	for i := 0; i < 100; i++ {
+		xf.Components = append(xf.Components, models.XComponent{ID: i + 10, STNo: 1, DeltX: float64(i), DeltY: 5, Note: fmt.Sprintf("R%d - R_0603", i+10)})
+	}
+	big := newTestSession(t, store, xf)
+	small := newTestSession(t, store, nil)
+	handler := GzipMiddleware(http.HandlerFunc(h.GetXFile))
+
+	get := func(id, acceptEncoding string) *httptest.ResponseRecorder {
+		r := withSession(httptest.NewRequest(http.MethodGet, "/api/xfile", nil), id)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	w := get(big, "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", w.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	var got models.XFile
+	if err := json.NewDecoder(gz).Decode(&got); err != nil {
+		t.Fatalf("decode gzipped xfile: %v", err)
+	}
+	if len(got.Components) != len(xf.Components) {
+		t.Errorf("got %d components, want %d", len(got.Components), len(xf.Components))
+	}
+
+	// No gzip without Accept-Encoding, or below the size threshold
+	for _, tt := range []struct{ name, id, accept string }{
+		{"not accepted", big, ""},
+		{"refused", big, "gzip;q=0"},
+		{"small", small, "gzip"},
+	} {
+		w := get(tt.id, tt.accept)
+		if ce := w.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: Content-Encoding %q, want none", tt.name, ce)
+		}
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Errorf("%s: decode plain xfile: %v", tt.name, err)
+		}
+	}
+}
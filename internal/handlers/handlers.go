@@ -2,31 +2,86 @@ package handlers
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"charmtool/internal/models"
 	"charmtool/internal/storage"
 )
 
+// DefaultMaxUploadMB is the default upload size limit in megabytes
+const DefaultMaxUploadMB = 10
+
 // Handler holds dependencies for HTTP handlers
 type Handler struct {
-	store *storage.FileStore
+	store          *storage.FileStore
+	maxUploadSize  int64           // Maximum upload size in bytes
+	adminToken     string          // Required X-Admin-Token for admin endpoints ("" disables them)
+	allowedOrigins map[string]bool // Origins allowed cross-origin access ("*" for any)
+	sessionLimiter *rateLimiter    // New sessions per client IP
+	sessionMaxAge  time.Duration   // Session cookie lifetime
+}
+
+// New creates a new Handler with the given upload limit in megabytes, admin
+// token (empty disables the admin endpoints) and CORS allowed origins
+// (empty allows same-origin requests only)
+func New(store *storage.FileStore, maxUploadMB int, adminToken string, allowedOrigins []string) *Handler {
+	if maxUploadMB <= 0 {
+		maxUploadMB = DefaultMaxUploadMB
+	}
+	origins := make(map[string]bool)
+	for _, o := range allowedOrigins {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins[o] = true
+		}
+	}
+	return &Handler{
+		store:          store,
+		maxUploadSize:  int64(maxUploadMB) << 20,
+		adminToken:     adminToken,
+		allowedOrigins: origins,
+		sessionLimiter: newRateLimiter(DefaultSessionsPerMinute, time.Minute),
+		sessionMaxAge:  DefaultSessionMaxAge,
+	}
 }
 
-// New creates a new Handler
-func New(store *storage.FileStore) *Handler {
-	return &Handler{store: store}
+// parseUploadForm parses a multipart upload limited to maxUploadSize bytes.
+// On failure it writes the error response and returns false.
+func (h *Handler) parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	if err := r.ParseMultipartForm(h.maxUploadSize); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			setJSONContentType(w)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Upload exceeds the %d MB size limit", h.maxUploadSize>>20),
+			})
+			return false
+		}
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return false
+	}
+	return true
 }
 
 // UploadPOS handles POST /api/upload/pos
 func (h *Handler) UploadPOS(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
@@ -43,9 +98,12 @@ func (h *Handler) UploadPOS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The current project, kept for GET /api/diff (nil if a named project
+	// does not exist yet)
+	current, _ := h.store.GetProject(sessionID, getProject(r))
+
 	// Parse multipart form
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB max
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	if !h.parseUploadForm(w, r) {
 		return
 	}
 
@@ -56,22 +114,63 @@ func (h *Handler) UploadPOS(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Parse POS file
-	posData, err := models.ParsePOS(file)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse POS file: %v", err), http.StatusBadRequest)
+	posData, ok := parsePOSUpload(w, r, file, header)
+	if !ok {
+		return
+	}
+
+	// KiCad top/bottom file pair: the bottom file is mirrored and merged in
+	var duplicates []string
+	if bottomFile, bottomHeader, err := r.FormFile("bottomFile"); err == nil {
+		defer bottomFile.Close()
+		bottomData, ok := parsePOSUpload(w, r, bottomFile, bottomHeader)
+		if !ok {
+			return
+		}
+		posData, duplicates = models.MergeBottomPOS(posData, bottomData)
+	}
+
+	// Files with a downward Y axis are detected, or forced with ?invertY=
+	switch v := r.URL.Query().Get("invertY"); v {
+	case "":
+		posData.InvertY = models.DetectInvertedY(posData)
+	case "true", "false":
+		posData.InvertY = v == "true"
+	default:
+		http.Error(w, fmt.Sprintf("Invalid invertY %q (use true or false)", v), http.StatusBadRequest)
 		return
 	}
 
-	// Convert to XFile
-	xf := models.ConvertPOSToXFile(posData, header.Filename)
+	// Record the uncompressed name (board.pos.gz -> board.pos)
+	filename := strings.TrimSuffix(header.Filename, ".gz")
+
+	// Convert to XFile, or merge into the current one with ?mode=append
+	var xf *models.XFile
+	if r.URL.Query().Get("mode") == "append" {
+		xf, err = h.store.GetProject(sessionID, getProject(r))
+		if err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err := models.AppendPOSToXFile(xf, posData, filename); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to append POS file: %v", err), http.StatusBadRequest)
+			return
+		}
+	} else {
+		xf = models.ConvertPOSToXFile(posData, filename)
+	}
 
 	// Save to session
-	if err := h.store.UpdateSession(sessionID, xf); err != nil {
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
+	// Remember what the upload replaced so the two can be compared
+	if current != nil && len(current.Components) > 0 {
+		h.store.SetPreviousUpload(sessionID, getProject(r), current)
+	}
+
 	// Increment POS uploads counter
 	h.store.IncrementPOSUploads()
 
@@ -81,12 +180,47 @@ func (h *Handler) UploadPOS(w http.ResponseWriter, r *http.Request) {
 		"filename":   header.Filename,
 		"components": len(xf.Components),
 		"stations":   len(xf.Stations),
+		"units":      posData.Units,
+		"duplicates": duplicates,
+		"invertY":    posData.InvertY,
 	})
 }
 
-// UploadStack handles POST /api/upload/stack
-func (h *Handler) UploadStack(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// parsePOSUpload parses an uploaded POS file, decompressing it if gzipped
+// and applying the ?units= override. On failure it writes the error
+// response and returns false.
+func parsePOSUpload(w http.ResponseWriter, r *http.Request, file io.Reader, header *multipart.FileHeader) (*models.POSData, bool) {
+	// Gzip-compressed POS files are decompressed transparently
+	posReader, err := gunzipIfCompressed(file, header.Header.Get("Content-Encoding"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decompress %s: %v", header.Filename, err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	posData, err := models.ParsePOS(posReader)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse POS file %s: %v", header.Filename, err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Optional units override (mm, mil, in) for files without unit markers
+	if units := r.URL.Query().Get("units"); units != "" {
+		if err := posData.ForceUnits(units); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	return posData, true
+}
+
+// UploadDPV handles POST /api/upload/dpv
+// Replaces the project with the contents of a previously generated DPV file.
+// The global offset baked into the positions is removed when given with
+// ?offsetx=&offsety=, or taken from the current project if it was made from
+// the same POS file.
+func (h *Handler) UploadDPV(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
@@ -103,16 +237,11 @@ func (h *Handler) UploadStack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get current XFile
-	xf, err := h.store.GetSession(sessionID)
-	if err != nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
-		return
-	}
+	// The current project (nil if a named project does not exist yet)
+	current, _ := h.store.GetProject(sessionID, getProject(r))
 
 	// Parse multipart form
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	if !h.parseUploadForm(w, r) {
 		return
 	}
 
@@ -123,40 +252,55 @@ func (h *Handler) UploadStack(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Parse Stack file
-	stations, err := models.ParseStack(file)
+	xf, err := models.ParseDPV(file)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse Stack file: %v", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Failed to parse DPV file: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Merge into XFile
-	merged := models.MergeStationsIntoXFile(xf, stations, header.Filename)
+	// Work out the global offset that was applied on export, if known
+	var offset models.GlobalOffset
+	query := r.URL.Query()
+	if query.Get("offsetx") != "" || query.Get("offsety") != "" {
+		for name, dst := range map[string]*float64{"offsetx": &offset.X, "offsety": &offset.Y} {
+			if v := query.Get(name); v != "" {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s %q", name, v), http.StatusBadRequest)
+					return
+				}
+				*dst = f
+			}
+		}
+	} else if current != nil && current.OriginalPOS != "" && current.OriginalPOS == xf.OriginalPOS {
+		offset = current.GlobalOffset
+	}
+	models.ReverseGlobalOffset(xf, offset)
 
-	// Save to session
-	if err := h.store.UpdateSession(sessionID, xf); err != nil {
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
 	setJSONContentType(w)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":  true,
-		"filename": header.Filename,
-		"merged":   merged,
-		"total":    len(xf.Stations),
+		"success":      true,
+		"filename":     header.Filename,
+		"components":   len(xf.Components),
+		"stations":     len(xf.Stations),
+		"globalOffset": xf.GlobalOffset,
 	})
 }
 
-// GetXFile handles GET /api/xfile
-func (h *Handler) GetXFile(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// UploadStack handles POST /api/upload/stack
+func (h *Handler) UploadStack(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -167,19 +311,56 @@ func (h *Handler) GetXFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	xf, err := h.store.GetSession(sessionID)
+	// Get current XFile
+	xf, err := h.store.GetProject(sessionID, getProject(r))
 	if err != nil {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
+	// Parse multipart form
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Parse Stack file
+	stackData, err := models.ParseStackFile(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse Stack file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Merge into our copy of the XFile, which must be saved back to persist
+	merged := models.MergeStationsIntoXFile(xf, stackData.Stations, header.Filename)
+	models.MergeICTrays(xf, stackData.ICTrays)
+
+	// Save to session
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
 	setJSONContentType(w)
-	json.NewEncoder(w).Encode(xf)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"filename":   header.Filename,
+		"merged":     merged,
+		"total":      len(xf.Stations),
+		"stackFiles": xf.StackFiles,
+	})
 }
 
-// UpdateXFile handles POST /api/xfile/update
-func (h *Handler) UpdateXFile(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// UploadBOM handles POST /api/upload/bom
+// Sets component DNP flags from a BOM with Ref and DNP/Fitted columns
+func (h *Handler) UploadBOM(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
@@ -196,26 +377,50 @@ func (h *Handler) UpdateXFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var xf models.XFile
-	if err := json.NewDecoder(r.Body).Decode(&xf); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
-	if err := h.store.UpdateSession(sessionID, &xf); err != nil {
+	// Parse multipart form
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	entries, err := models.ParseBOM(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse BOM file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	matched, unmatched := models.ApplyBOM(xf, entries)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
 	setJSONContentType(w)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
+		"success":       true,
+		"filename":      header.Filename,
+		"matched":       matched,
+		"unmatched":     len(unmatched),
+		"unmatchedRefs": unmatched,
 	})
 }
 
-// Validate handles GET /api/validate
-func (h *Handler) Validate(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// GetXFile handles GET /api/xfile
+func (h *Handler) GetXFile(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
@@ -232,38 +437,25 @@ func (h *Handler) Validate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	xf, err := h.store.GetSession(sessionID)
+	xf, err := h.store.GetProject(sessionID, getProject(r))
 	if err != nil {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
-	// Get filename from query param or use default
-	filename := r.URL.Query().Get("filename")
-	if filename == "" {
-		filename = "output.dpv"
-	}
-
-	result := models.ValidateDPV(xf, filename)
-
 	setJSONContentType(w)
-	json.NewEncoder(w).Encode(result)
-}
-
-// ExportRequest contains optional log data for export
-type ExportRequest struct {
-	Log string `json:"log"`
+	json.NewEncoder(w).Encode(xf)
 }
 
-// Export handles GET/POST /api/export
-func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// UpdateXFile handles POST /api/xfile/update
+func (h *Handler) UpdateXFile(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -274,143 +466,118 @@ func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	xf, err := h.store.GetSession(sessionID)
-	if err != nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+	var xf models.XFile
+	if err := json.NewDecoder(r.Body).Decode(&xf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Get base filename from query param or derive from original POS
-	baseName := r.URL.Query().Get("filename")
-	if baseName == "" {
-		baseName = xf.OriginalPOS
-		if baseName == "" {
-			baseName = "output"
-		}
-		// Remove extension
-		baseName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	if err := h.store.UpdateProject(sessionID, getProject(r), &xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
 	}
 
-	// Parse log content from POST body if present
-	var logContent string
-	if r.Method == http.MethodPost && r.Body != nil {
-		var req ExportRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err == nil {
-			logContent = req.Log
-		}
-	}
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
 
-	dpvFilename := baseName + ".dpv"
+// PatchEntry changes the given fields of one component or station
+type PatchEntry struct {
+	Index  int             `json:"index"`  // Row index in the components/stations list
+	Fields json.RawMessage `json:"fields"` // Fields to change, using the XFile JSON names
+}
 
-	// Validate before export
-	validation := models.ValidateDPV(xf, dpvFilename)
-	if !validation.Valid {
-		setJSONContentType(w)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    false,
-			"validation": validation,
-			"message":    "DPV validation failed. Please fix errors before exporting.",
-		})
+// PatchRequest is the body of PATCH /api/xfile/patch
+type PatchRequest struct {
+	Components []PatchEntry `json:"components"`
+	Stations   []PatchEntry `json:"stations"`
+}
+
+// PatchXFile handles PATCH /api/xfile/patch
+// Applies field changes to individual components and stations
+func (h *Handler) PatchXFile(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
 		return
 	}
 
-	// Generate DPV content
-	dpvContent, err := models.GenerateDPV(xf, dpvFilename)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to generate DPV: %v", err), http.StatusInternalServerError)
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Generate Stack content
-	stackContent := models.GenerateStack(xf)
-
-	// Create ZIP file
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
 
-	// Add DPV file
-	dpvWriter, err := zipWriter.Create(dpvFilename)
-	if err != nil {
-		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+	var req PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 		return
 	}
-	io.WriteString(dpvWriter, dpvContent)
 
-	// Add Stack file
-	stackFilename := baseName + ".stack"
-	stackWriter, err := zipWriter.Create(stackFilename)
+	xf, err := h.store.GetProject(sessionID, getProject(r))
 	if err != nil {
-		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
-	io.WriteString(stackWriter, stackContent)
 
-	// Add original POS file
-	if len(xf.POSRows) > 0 {
-		posFilename := baseName + ".pos"
-		posContent := models.GeneratePOS(xf)
-		posWriter, err := zipWriter.Create(posFilename)
-		if err != nil {
-			http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+	// Check every index before changing anything
+	for _, p := range req.Components {
+		if p.Index < 0 || p.Index >= len(xf.Components) {
+			http.Error(w, fmt.Sprintf("Component index %d out of range", p.Index), http.StatusBadRequest)
 			return
 		}
-		io.WriteString(posWriter, posContent)
 	}
-
-	// Add Log file if provided
-	if logContent != "" {
-		logFilename := baseName + ".log"
-		logWriter, err := zipWriter.Create(logFilename)
-		if err != nil {
-			http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+	for _, p := range req.Stations {
+		if p.Index < 0 || p.Index >= len(xf.Stations) {
+			http.Error(w, fmt.Sprintf("Station index %d out of range", p.Index), http.StatusBadRequest)
 			return
 		}
-		io.WriteString(logWriter, logContent)
 	}
 
-	// Add README.txt with setup instructions
-	readmeContent := models.GenerateReadme(xf, dpvFilename)
-	readmeWriter, err := zipWriter.Create("README.txt")
-	if err != nil {
-		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
-		return
+	// Unmarshalling onto the existing row only overwrites the fields present
+	for _, p := range req.Components {
+		if err := json.Unmarshal(p.Fields, &xf.Components[p.Index]); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fields for component %d: %v", p.Index, err), http.StatusBadRequest)
+			return
+		}
 	}
-	io.WriteString(readmeWriter, readmeContent)
-
-	// Add material.stacks file (calibrated feeder positions)
-	if len(xf.Stations) > 0 {
-		stacksContent := models.GenerateStacksFile(xf)
-		stacksWriter, err := zipWriter.Create("material.stacks")
-		if err != nil {
-			http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+	for _, p := range req.Stations {
+		if err := json.Unmarshal(p.Fields, &xf.Stations[p.Index]); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fields for station %d: %v", p.Index, err), http.StatusBadRequest)
 			return
 		}
-		io.WriteString(stacksWriter, stacksContent)
 	}
 
-	if err := zipWriter.Close(); err != nil {
-		http.Error(w, "Failed to finalize ZIP", http.StatusInternalServerError)
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
-	// Send ZIP file
-	zipFilename := baseName + ".zip"
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", zipFilename))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
-	w.Write(buf.Bytes())
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"components": len(req.Components),
+		"stations":   len(req.Stations),
+	})
 }
 
-// StacksExport handles GET /api/stacks/export
-func (h *Handler) StacksExport(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// Reset handles POST /api/reset
+// Replaces the session's XFile with an empty one, keeping the session ID
+func (h *Handler) Reset(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -421,23 +588,1713 @@ func (h *Handler) StacksExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	xf, err := h.store.GetSession(sessionID)
-	if err != nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+	if err := h.store.UpdateProject(sessionID, getProject(r), models.NewXFile()); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate .stacks content (same format as .stack but with .stacks extension)
-	stacksContent := models.GenerateStacksFile(xf)
-
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// Renumber handles POST /api/renumber
+// Makes the Station and Component No. values sequential, as on export
+func (h *Handler) Renumber(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	changed := models.Renumber(xf)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"changed": changed,
+	})
+}
+
+// Undo handles POST /api/undo
+// Restores the project to the version before its last change; up to
+// storage.MaxUndo changes can be undone.
+func (h *Handler) Undo(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.Undo(sessionID, getProject(r))
+	if errors.Is(err, storage.ErrNothingToUndo) {
+		http.Error(w, "Nothing to undo", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to undo", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"xfile":   xf,
+	})
+}
+
+// PanelRequest contains the step-and-repeat panel configuration
+type PanelRequest struct {
+	IntervalX float64 `json:"intervalx"`
+	IntervalY float64 `json:"intervaly"`
+	NumX      int     `json:"numx"`
+	NumY      int     `json:"numy"`
+	Skip      []int   `json:"skip"` // Board numbers (1 to N) to skip
+}
+
+// UpdatePanel handles POST /api/panel
+func (h *Handler) UpdatePanel(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req PanelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := models.BuildPanel(xf, req.IntervalX, req.IntervalY, req.NumX, req.NumY, req.Skip); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid panel: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"boards":     len(xf.PanelCoord),
+		"panelArray": xf.PanelArray,
+		"panelCoord": xf.PanelCoord,
+	})
+}
+
+// AssignStations handles POST /api/stations/assign
+// Spreads stations across the reel banks; ?balance=true also splits PHead
+// and ?startId=N keeps the slots below N free
+func (h *Handler) AssignStations(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	balance := r.URL.Query().Get("balance") == "true"
+
+	startID := 1
+	if v := r.URL.Query().Get("startId"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("Invalid startId %q", v), http.StatusBadRequest)
+			return
+		}
+		startID = n
+	}
+
+	if err := models.AssignFeederSlots(xf, balance, startID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to assign feeder slots: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"stations": len(xf.Stations),
+	})
+}
+
+// Stations handles GET /api/stations/summary
+// Lists each station with the refs of the components assigned to it
+func (h *Handler) Stations(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"stations": models.SummarizeStations(xf),
+	})
+}
+
+// MergeStationsRequest names the station to merge and the one to keep
+type MergeStationsRequest struct {
+	From string `json:"from"` // Note of the station to remove
+	To   string `json:"to"`   // Note of the station to keep
+}
+
+// MergeStations handles POST /api/stations/merge
+func (h *Handler) MergeStations(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req MergeStationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	moved, err := models.MergeStations(xf, req.From, req.To)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to merge stations: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"components": moved,
+		"stations":   len(xf.Stations),
+	})
+}
+
+// ReorderStationsRequest is the body of POST /api/stations/reorder
+type ReorderStationsRequest struct {
+	IDs []int `json:"ids"` // Every station ID, in the desired order
+}
+
+// ReorderStations handles POST /api/stations/reorder
+// Reorders the Station table to match the feeder layout
+func (h *Handler) ReorderStations(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req ReorderStationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := models.ReorderStations(xf, req.IDs); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reorder stations: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"stations": models.SummarizeStations(xf),
+	})
+}
+
+// StationCoordRequest is the body of POST /api/stations/coord
+type StationCoordRequest struct {
+	ID *int     `json:"id"`
+	X  *float64 `json:"x"`
+	Y  *float64 `json:"y"`
+}
+
+// SetStationCoord handles POST /api/stations/coord
+// Sets one station's feeder position without sending the whole XFile
+func (h *Handler) SetStationCoord(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	var req StationCoordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ID == nil || req.X == nil || req.Y == nil {
+		http.Error(w, "id, x and y are required", http.StatusBadRequest)
+		return
+	}
+	for _, v := range []float64{*req.X, *req.Y} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			http.Error(w, "Coordinates must be finite numbers", http.StatusBadRequest)
+			return
+		}
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := models.SetStationCoord(xf, *req.ID, *req.X, *req.Y); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set station position: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      *req.ID,
+		"deltx":   *req.X,
+		"delty":   *req.Y,
+	})
+}
+
+// AssignHeads handles POST /api/heads/assign
+// Accepts an optional JSON map of package fragment -> PHead rules
+func (h *Handler) AssignHeads(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rules := models.DefaultHeadRules
+	if r.ContentLength > 0 {
+		var custom map[string]int
+		if err := json.NewDecoder(r.Body).Decode(&custom); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		for pkg, head := range custom {
+			if head != 1 && head != 2 {
+				http.Error(w, fmt.Sprintf("Invalid PHead %d for %q (must be 1 or 2)", head, pkg), http.StatusBadRequest)
+				return
+			}
+		}
+		rules = custom
+	}
+
+	changed := models.AssignHeads(xf, rules)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"changed": changed,
+	})
+}
+
+// SuggestFeedRates handles POST /api/stations/feedrates
+// Accepts an optional JSON map of package fragment -> FeedRates rules
+func (h *Handler) SuggestFeedRates(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rules := models.DefaultFeedRateRules
+	if r.ContentLength > 0 {
+		var custom map[string]int
+		if err := json.NewDecoder(r.Body).Decode(&custom); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		for pkg, rate := range custom {
+			if rate != 2 && rate != 4 && rate != 8 {
+				http.Error(w, fmt.Sprintf("Invalid FeedRates %d for %q (must be 2, 4 or 8)", rate, pkg), http.StatusBadRequest)
+				return
+			}
+		}
+		rules = custom
+	}
+
+	warnings := models.SuggestFeedRates(xf, rules)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	if warnings == nil {
+		warnings = []string{}
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"changed":  len(warnings),
+		"warnings": warnings,
+	})
+}
+
+// BulkDNPRequest is the body of POST /api/components/dnp
+type BulkDNPRequest struct {
+	Match models.ComponentMatch `json:"match"`
+	DNP   bool                  `json:"dnp"`
+}
+
+// BulkDNP handles POST /api/components/dnp
+// Sets the DNP flag on all components matching a value, package or ref prefix
+func (h *Handler) BulkDNP(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	var req BulkDNPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	changed, err := models.SetDNPMatching(xf, req.Match, req.DNP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"changed": changed,
+	})
+}
+
+// SuggestDelays handles POST /api/stations/delays
+// Accepts an optional JSON map of package fragment -> delay (cs) rules
+func (h *Handler) SuggestDelays(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rules := models.DefaultDelayRules
+	if r.ContentLength > 0 {
+		var custom map[string]int
+		if err := json.NewDecoder(r.Body).Decode(&custom); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		for pkg, delay := range custom {
+			if delay < 0 {
+				http.Error(w, fmt.Sprintf("Invalid delay %d for %q (cannot be negative)", delay, pkg), http.StatusBadRequest)
+				return
+			}
+		}
+		rules = custom
+	}
+
+	changed := models.SuggestDelays(xf, rules)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"changed": changed,
+	})
+}
+
+// TuneVision handles POST /api/stations/vision
+// Accepts an optional JSON map of package fragment -> vision settings rules
+func (h *Handler) TuneVision(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rules := models.DefaultVisionRules
+	if r.ContentLength > 0 {
+		var custom map[string]models.VisionSettings
+		if err := json.NewDecoder(r.Body).Decode(&custom); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		for pkg, v := range custom {
+			if err := v.Validate(); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid vision settings for %q: %v", pkg, err), http.StatusBadRequest)
+				return
+			}
+		}
+		rules = custom
+	}
+
+	changed := models.TuneVision(xf, rules)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"changed": changed,
+	})
+}
+
+// VisionOffRequest is the body of POST /api/stations/vision/off
+type VisionOffRequest struct {
+	Packages []string `json:"packages"`
+}
+
+// VisionOff handles POST /api/stations/vision/off
+// Turns vision off for large parts (connectors by default) and their stations
+func (h *Handler) VisionOff(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	packages := models.DefaultNoVisionPackages
+	if r.ContentLength > 0 {
+		var req VisionOffRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(req.Packages) == 0 {
+			http.Error(w, "packages must not be empty", http.StatusBadRequest)
+			return
+		}
+		packages = req.Packages
+	}
+
+	components, stations := models.DisableVisionForPackages(xf, packages)
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"components": components,
+		"stations":   stations,
+	})
+}
+
+// OffsetRequest is the body of POST /api/offset
+type OffsetRequest struct {
+	X *float64 `json:"x"`
+	Y *float64 `json:"y"`
+}
+
+// SetOffset handles POST /api/offset
+// Sets GlobalOffset without sending the whole XFile
+func (h *Handler) SetOffset(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	var req OffsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.X == nil || req.Y == nil {
+		http.Error(w, "Both x and y are required", http.StatusBadRequest)
+		return
+	}
+	for _, v := range []float64{*req.X, *req.Y} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			http.Error(w, "Offset values must be finite numbers", http.StatusBadRequest)
+			return
+		}
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	xf.GlobalOffset = models.GlobalOffset{X: *req.X, Y: *req.Y}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"globalOffset": xf.GlobalOffset,
+	})
+}
+
+// AutoOffsetRequest contains the optional margin for automatic offsetting
+type AutoOffsetRequest struct {
+	Margin *float64 `json:"margin"`
+}
+
+// AutoOffset handles POST /api/offset/auto
+// Sets GlobalOffset so the board sits inside the machine's PCB area
+func (h *Handler) AutoOffset(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	margin := models.DefaultAutoOffsetMargin
+	if r.ContentLength > 0 {
+		var req AutoOffsetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Margin != nil {
+			margin = *req.Margin
+		}
+	}
+	if margin < 0 {
+		http.Error(w, fmt.Sprintf("Margin %.2f cannot be negative", margin), http.StatusBadRequest)
+		return
+	}
+
+	if err := models.AutoOffset(xf, margin); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to auto offset: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"globalOffset": xf.GlobalOffset,
+	})
+}
+
+// TransformRequest is the body of POST /api/transform
+type TransformRequest struct {
+	Axis string `json:"axis"` // "x", "y" or "swap"
+}
+
+// Transform handles POST /api/transform
+// Mirrors component coordinates for fixtures that hold the board flipped,
+// or swaps X and Y for POS files with swapped columns
+func (h *Handler) Transform(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	var req TransformRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	switch strings.ToLower(req.Axis) {
+	case "x":
+		models.MirrorX(xf)
+	case "y":
+		models.MirrorY(xf)
+	case "swap":
+		models.SwapXY(xf)
+	default:
+		http.Error(w, fmt.Sprintf("Invalid axis %q (must be \"x\", \"y\" or \"swap\")", req.Axis), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"components": len(xf.Components),
+	})
+}
+
+// NudgeRequest is the body of POST /api/nudge
+type NudgeRequest struct {
+	Target string   `json:"target"` // "components", "stations" or "both"
+	DX     *float64 `json:"dx"`
+	DY     *float64 `json:"dy"`
+}
+
+// Nudge handles POST /api/nudge
+// Shifts all non-DNP component and/or station positions by the same delta
+func (h *Handler) Nudge(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	var req NudgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.DX == nil || req.DY == nil {
+		http.Error(w, "Both dx and dy are required", http.StatusBadRequest)
+		return
+	}
+	for _, v := range []float64{*req.DX, *req.DY} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			http.Error(w, "Nudge values must be finite numbers", http.StatusBadRequest)
+			return
+		}
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	moved, err := models.Nudge(xf, strings.ToLower(req.Target), *req.DX, *req.DY)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"moved":   moved,
+	})
+}
+
+// Diff handles GET /api/diff
+// Compares the components with those before the last POS upload, by Ref;
+// ?threshold= sets the distance (mm) reported as moved
+func (h *Handler) Diff(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	threshold := models.DefaultMoveThreshold
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			http.Error(w, fmt.Sprintf("Invalid threshold %q", v), http.StatusBadRequest)
+			return
+		}
+		threshold = f
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	previous, err := h.store.PreviousUpload(sessionID, getProject(r))
+	if errors.Is(err, storage.ErrNoPreviousUpload) {
+		http.Error(w, "No previous upload to compare with", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"previous": previous.OriginalPOS,
+		"current":  xf.OriginalPOS,
+		"diff":     models.DiffComponents(previous, xf, threshold),
+	})
+}
+
+// Validate handles GET /api/validate
+func (h *Handler) Validate(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// Get filename from query param or use default
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		filename = "output.dpv"
+	}
+
+	result := models.ValidateDPV(xf, filename)
+	if !result.Valid {
+		h.store.IncrementValidationFailures()
+	}
+
+	// ?severity=errors omits warnings (WarningCount still reports the total)
+	if r.URL.Query().Get("severity") == "errors" {
+		result.Warnings = []models.DPVValidationError{}
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(result)
+}
+
+// ValidateBody handles POST /api/validate
+// Validates an XFile posted as the JSON body, without a session, for use
+// from CI. Accepts the same ?filename= and ?severity= as GET.
+func (h *Handler) ValidateBody(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var xf models.XFile
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	if err := json.NewDecoder(r.Body).Decode(&xf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := models.MigrateXFile(&xf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid XFile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		filename = "output.dpv"
+	}
+
+	result := models.ValidateDPV(&xf, filename)
+	if !result.Valid {
+		h.store.IncrementValidationFailures()
+	}
+
+	// ?severity=errors omits warnings (WarningCount still reports the total)
+	if r.URL.Query().Get("severity") == "errors" {
+		result.Warnings = []models.DPVValidationError{}
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(result)
+}
+
+// PlacementSVG handles GET /api/preview.svg
+// Draws the component placement as an SVG image for a quick visual check
+func (h *Handler) PlacementSVG(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	io.WriteString(w, models.GeneratePlacementSVG(xf))
+}
+
+// DefaultHeatmapBins is the heatmap grid size used when ?bins= is not given
+const DefaultHeatmapBins = 20
+
+// maxHeatmapBins limits the heatmap grid size
+const maxHeatmapBins = 200
+
+// Heatmap handles GET /api/heatmap
+// Returns placement counts per cell of a ?bins=N square grid (default 20)
+// over the active components' bounding box
+func (h *Handler) Heatmap(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	bins := DefaultHeatmapBins
+	if v := r.URL.Query().Get("bins"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHeatmapBins {
+			http.Error(w, fmt.Sprintf("Invalid bins %q (must be 1-%d)", v, maxHeatmapBins), http.StatusBadRequest)
+			return
+		}
+		bins = n
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	heatmap, err := models.BuildHeatmap(xf, bins)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"heatmap": heatmap,
+	})
+}
+
+// ExportRequest contains optional log data for export
+type ExportRequest struct {
+	Log string `json:"log"`
+}
+
+// exportOptions are the export query parameters shared by the ZIP and
+// single DPV downloads
+type exportOptions struct {
+	baseName        string // Output file name without extension
+	calibFromBounds bool   // ?calib=bounds
+	precision       int    // ?precision=N
+}
+
+// parseExportOptions reads the export query parameters and returns the
+// XFile to export, reordered (?optimize=true) or flattened (?flatten=true)
+// as requested; the session copy is unchanged. On failure it writes the
+// error response and returns false.
+func parseExportOptions(w http.ResponseWriter, r *http.Request, xf *models.XFile) (*models.XFile, exportOptions, bool) {
+	var opts exportOptions
+
+	// Get base filename from query param or derive from original POS
+	opts.baseName = r.URL.Query().Get("filename")
+	if opts.baseName == "" {
+		opts.baseName = xf.OriginalPOS
+		if opts.baseName == "" {
+			opts.baseName = "output"
+		}
+		// Remove extension
+		opts.baseName = strings.TrimSuffix(opts.baseName, filepath.Ext(opts.baseName))
+	}
+
+	// Optionally reorder placements to reduce head travel (session is unchanged)
+	if r.URL.Query().Get("optimize") == "true" {
+		optimized := *xf
+		optimized.Components = append([]models.XComponent(nil), xf.Components...)
+		models.OptimizePlacementOrder(&optimized)
+		xf = &optimized
+	}
+
+	// Optionally repeat the components for every panel board (session is unchanged)
+	if r.URL.Query().Get("flatten") == "true" {
+		flattened := *xf
+		flattened.Components = append([]models.XComponent(nil), xf.Components...)
+		models.FlattenPanel(&flattened)
+		xf = &flattened
+	}
+
+	// ?calib=bounds seeds the CalibPoint table from the component bounding
+	// box corners when the board has no fiducials
+	switch calib := r.URL.Query().Get("calib"); calib {
+	case "":
+	case "bounds":
+		opts.calibFromBounds = true
+	default:
+		http.Error(w, fmt.Sprintf("Invalid calib %q (use bounds)", calib), http.StatusBadRequest)
+		return nil, opts, false
+	}
+
+	// ?precision=N sets the decimals written for coordinates (0 for integers)
+	opts.precision = models.DefaultPrecision
+	if v := r.URL.Query().Get("precision"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > models.MaxPrecision {
+			http.Error(w, fmt.Sprintf("Invalid precision %q (use 0 to %d)", v, models.MaxPrecision), http.StatusBadRequest)
+			return nil, opts, false
+		}
+		opts.precision = n
+	}
+
+	return xf, opts, true
+}
+
+// validateForExport runs ValidateDPV and, when the XFile has errors, writes
+// the validation result with 400 and returns false
+func validateForExport(w http.ResponseWriter, xf *models.XFile, dpvFilename string) (*models.DPVValidationResult, bool) {
+	validation := models.ValidateDPV(xf, dpvFilename)
+	if !validation.Valid {
+		setJSONContentType(w)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    false,
+			"validation": validation,
+			"message":    "DPV validation failed. Please fix errors before exporting.",
+		})
+		return nil, false
+	}
+	return validation, true
+}
+
+// Export handles GET/POST /api/export
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse log content from POST body if present
+	var logContent string
+	if r.Method == http.MethodPost && r.Body != nil {
+		var req ExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err == nil {
+			logContent = req.Log
+		}
+	}
+
+	xf, opts, ok := parseExportOptions(w, r, xf)
+	if !ok {
+		return
+	}
+	baseName := opts.baseName
+	dpvFilename := baseName + ".dpv"
+	job := models.NewExportInfo(baseName)
+
+	// ?layout=usb nests the DPV in a folder named after the job, the layout
+	// the machine expects when the ZIP is copied onto a USB stick as is
+	dpvPath := dpvFilename
+	switch layout := r.URL.Query().Get("layout"); layout {
+	case "":
+	case "usb":
+		dpvPath = baseName + "/" + dpvFilename
+	default:
+		http.Error(w, fmt.Sprintf("Invalid layout %q (use usb)", layout), http.StatusBadRequest)
+		return
+	}
+
+	// Validate before export
+	validation, ok := validateForExport(w, xf, dpvFilename)
+	if !ok {
+		return
+	}
+
+	// Generate DPV content
+	dpvContent, err := models.GenerateDPV(xf, dpvFilename, opts.calibFromBounds, opts.precision)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate DPV: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Generate Stack content
+	stackContent := models.GenerateStack(xf, opts.precision)
+
+	// Generate machine-readable job summary
+	manifestContent, err := models.GenerateManifest(xf, job)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate manifest: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Dry run: return the generated files as JSON instead of a ZIP
+	if r.URL.Query().Get("preview") == "true" {
+		setJSONContentType(w)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dpv":        dpvContent,
+			"stack":      stackContent,
+			"pos":        models.GeneratePOS(xf),
+			"readme":     models.GenerateReadme(xf, dpvFilename, job),
+			"manifest":   manifestContent,
+			"validation": models.GenerateValidationReport(validation, dpvFilename),
+			"heads":      models.GenerateHeadSummary(xf),
+		})
+		return
+	}
+
+	// Create ZIP file
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+
+	// Add DPV file
+	dpvWriter, err := zipWriter.Create(dpvPath)
+	if err != nil {
+		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(dpvWriter, dpvContent)
+
+	// Add Stack file
+	stackFilename := baseName + ".stack"
+	stackWriter, err := zipWriter.Create(stackFilename)
+	if err != nil {
+		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(stackWriter, stackContent)
+
+	// Add original POS file
+	if len(xf.POSRows) > 0 {
+		posFilename := baseName + ".pos"
+		posContent := models.GeneratePOS(xf)
+		posWriter, err := zipWriter.Create(posFilename)
+		if err != nil {
+			http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(posWriter, posContent)
+	}
+
+	// Add Log file if provided
+	if logContent != "" {
+		logFilename := baseName + ".log"
+		logWriter, err := zipWriter.Create(logFilename)
+		if err != nil {
+			http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(logWriter, logContent)
+	}
+
+	// Add README.txt with setup instructions
+	readmeContent := models.GenerateReadme(xf, dpvFilename, job)
+	readmeWriter, err := zipWriter.Create("README.txt")
+	if err != nil {
+		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(readmeWriter, readmeContent)
+
+	// Add manifest.json describing the job
+	manifestWriter, err := zipWriter.Create("manifest.json")
+	if err != nil {
+		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(manifestWriter, manifestContent)
+
+	// Add validation.txt listing the warnings accepted with this export
+	validationWriter, err := zipWriter.Create("validation.txt")
+	if err != nil {
+		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(validationWriter, models.GenerateValidationReport(validation, dpvFilename))
+
+	// Add heads.txt showing how the work is split between the nozzles
+	headsWriter, err := zipWriter.Create("heads.txt")
+	if err != nil {
+		http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(headsWriter, models.GenerateHeadSummary(xf))
+
+	// Add material.stacks file (calibrated feeder positions)
+	if len(xf.Stations) > 0 {
+		stacksContent := models.GenerateStacksFile(xf)
+		stacksWriter, err := zipWriter.Create("material.stacks")
+		if err != nil {
+			http.Error(w, "Failed to create ZIP", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(stacksWriter, stacksContent)
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		http.Error(w, "Failed to finalize ZIP", http.StatusInternalServerError)
+		return
+	}
+
+	// Record the export so the ZIP can be traced back to the session
+	if err := h.store.SetLastExport(sessionID, getProject(r), &job); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	h.store.IncrementExports()
+
+	// Send ZIP file
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", job.Filename))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// ExportDPV handles GET /api/export/dpv
+// Downloads just the .dpv file; accepts the same options as /api/export
+// except layout and preview
+func (h *Handler) ExportDPV(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	xf, opts, ok := parseExportOptions(w, r, xf)
+	if !ok {
+		return
+	}
+	dpvFilename := opts.baseName + ".dpv"
+
+	if _, ok := validateForExport(w, xf, dpvFilename); !ok {
+		return
+	}
+
+	dpvContent, err := models.GenerateDPV(xf, dpvFilename, opts.calibFromBounds, opts.precision)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate DPV: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.store.IncrementExports()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", dpvFilename))
+	io.WriteString(w, dpvContent)
+}
+
+// StacksExport handles GET /api/stacks/export
+func (h *Handler) StacksExport(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// Generate .stacks content (same format as .stack but with .stacks extension)
+	stacksContent := models.GenerateStacksFile(xf)
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Content-Disposition", "attachment; filename=\"material.stacks\"")
 	w.Write([]byte(stacksContent))
 }
 
-// StacksImport handles POST /api/stacks/import
-func (h *Handler) StacksImport(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+// StacksImport handles POST /api/stacks/import
+// ?matchBy=id applies coordinates by feeder slot instead of by part Note
+func (h *Handler) StacksImport(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse multipart form
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Read file content
+	content, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	// Match stations by part value (default) or by feeder slot ID
+	matchBy := r.URL.Query().Get("matchBy")
+	if matchBy == "" {
+		matchBy = models.MatchByNote
+	}
+	if matchBy != models.MatchByNote && matchBy != models.MatchByID {
+		http.Error(w, fmt.Sprintf("Invalid matchBy %q (use note or id)", matchBy), http.StatusBadRequest)
+		return
+	}
+
+	// Parse and merge the stacks file
+	merged, added, conflicts, err := models.MergeStacksFile(xf, string(content), matchBy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse stacks file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Track imported stacks file
+	filename := header.Filename
+	if !containsString(xf.StackFiles, filename) {
+		xf.StackFiles = append(xf.StackFiles, filename)
+	}
+
+	// Save updated xfile
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"filename":  filename,
+		"merged":    merged,
+		"added":     added,
+		"conflicts": conflicts,
+	})
+}
+
+// FeedersCSV handles POST /api/feeders/csv
+// Merges feeder positions from a "Note,X,Y" CSV into the stations by Note
+func (h *Handler) FeedersCSV(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
@@ -454,15 +2311,14 @@ func (h *Handler) StacksImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	xf, err := h.store.GetSession(sessionID)
+	xf, err := h.store.GetProject(sessionID, getProject(r))
 	if err != nil {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
 	// Parse multipart form
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	if !h.parseUploadForm(w, r) {
 		return
 	}
 
@@ -473,41 +2329,41 @@ func (h *Handler) StacksImport(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Read file content
-	content, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
-		return
-	}
-
-	// Parse and merge the stacks file
-	merged, added, err := models.MergeStacksFile(xf, string(content))
+	positions, err := models.ParseFeederCSV(file)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse stacks file: %v", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Failed to parse feeder CSV: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Track imported stacks file
-	filename := header.Filename
-	if !containsString(xf.StackFiles, filename) {
-		xf.StackFiles = append(xf.StackFiles, filename)
-	}
+	merged, unmatched, conflicts := models.MergeFeederPositions(xf, positions)
 
-	// Save updated xfile
-	if err := h.store.UpdateSession(sessionID, xf); err != nil {
+	if err := h.store.UpdateProject(sessionID, getProject(r), xf); err != nil {
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
 	setJSONContentType(w)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":  true,
-		"filename": filename,
-		"merged":   merged,
-		"added":    added,
+		"success":   true,
+		"filename":  header.Filename,
+		"merged":    merged,
+		"unmatched": unmatched,
+		"conflicts": conflicts,
 	})
 }
 
+// gunzipIfCompressed wraps r in a gzip reader when it starts with the gzip
+// magic bytes or the part declares Content-Encoding: gzip
+func gunzipIfCompressed(r io.Reader, contentEncoding string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	isGzip := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	if !isGzip && !strings.EqualFold(contentEncoding, "gzip") {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -519,7 +2375,7 @@ func containsString(slice []string, s string) bool {
 
 // GetStats handles GET /api/stats
 func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
+	h.setCORSHeaders(w, r)
 
 	if r.Method == http.MethodOptions {
 		return
@@ -535,3 +2391,309 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 	setJSONContentType(w)
 	json.NewEncoder(w).Encode(stats)
 }
+
+// AdminCleanup handles POST /api/admin/cleanup
+// Removes expired sessions on demand; requires the X-Admin-Token header
+func (h *Handler) AdminCleanup(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.checkAdminToken(w, r) {
+		return
+	}
+
+	removed, err := h.store.Cleanup()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cleanup failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"removed": removed,
+	})
+}
+
+// checkAdminToken verifies the X-Admin-Token header of an admin request.
+// On failure it writes the error response and returns false.
+func (h *Handler) checkAdminToken(w http.ResponseWriter, r *http.Request) bool {
+	if h.adminToken == "" {
+		http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+	token := r.Header.Get("X-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// Defaults handles GET/POST /api/defaults
+// GET returns the station defaults applied to new stations. POST changes
+// them for the whole server, so it requires the X-Admin-Token header;
+// fields left out of the JSON body keep their current value.
+func (h *Handler) Defaults(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defaults := models.CurrentStationDefaults()
+	if r.Method == http.MethodPost {
+		if !h.checkAdminToken(w, r) {
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&defaults); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := models.SetStationDefaults(defaults); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid station defaults: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"defaults": defaults,
+	})
+}
+
+// AngleOffsets handles GET/POST /api/angle-offsets
+// GET returns the per-package angle corrections applied when a POS file is
+// converted. POST replaces them for the whole server with a JSON map of
+// package fragment to degrees, e.g. {"SOD-123": 180}, so it requires the
+// X-Admin-Token header. Projects already loaded are not changed.
+func (h *Handler) AngleOffsets(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !h.checkAdminToken(w, r) {
+			return
+		}
+		var offsets map[string]float64
+		if err := json.NewDecoder(r.Body).Decode(&offsets); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := models.SetPackageAngleOffsets(offsets); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid angle offsets: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"offsets": models.PackageAngleOffsets(),
+	})
+}
+
+// Healthz handles GET /healthz (liveness probe)
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "ok",
+		"sessions": h.store.SessionCount(),
+	})
+}
+
+// Readyz handles GET /readyz (readiness probe)
+// Returns 503 until the stored sessions have been loaded
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	setJSONContentType(w)
+	if !h.store.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "loading",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+// ProjectRequest contains the name of a project to create
+type ProjectRequest struct {
+	Name string `json:"name"`
+}
+
+// Projects handles GET/POST /api/projects
+// GET lists the session's projects; POST creates a new named project
+func (h *Handler) Projects(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req ProjectRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := h.store.CreateProject(sessionID, req.Name); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create project: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	projects, err := h.store.ListProjects(sessionID)
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"projects": projects,
+	})
+}
+
+// ExportSession handles GET /api/session/export
+// Returns the XFile as a downloadable .charmtool backup
+func (h *Handler) ExportSession(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	xf, err := h.store.GetProject(sessionID, getProject(r))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := json.MarshalIndent(xf, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to encode session", http.StatusInternalServerError)
+		return
+	}
+
+	baseName := strings.TrimSuffix(xf.OriginalPOS, filepath.Ext(xf.OriginalPOS))
+	if baseName == "" {
+		baseName = "session"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.charmtool\"", baseName))
+	w.Write(data)
+}
+
+// ImportSession handles POST /api/session/import
+// Replaces the XFile with an uploaded .charmtool backup
+func (h *Handler) ImportSession(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := getSessionID(r)
+	if sessionID == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse multipart form
+	if !h.parseUploadForm(w, r) {
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var xf models.XFile
+	if err := json.NewDecoder(file).Decode(&xf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid session file: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := models.MigrateXFile(&xf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid session file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdateProject(sessionID, getProject(r), &xf); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"filename":   header.Filename,
+		"components": len(xf.Components),
+		"stations":   len(xf.Stations),
+	})
+}
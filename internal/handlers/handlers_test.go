@@ -0,0 +1,1311 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"charmtool/internal/models"
+	"charmtool/internal/storage"
+)
+
+// newTestStore returns a loaded FileStore in dir
+func newTestStore(t *testing.T, dir string) *storage.FileStore {
+	t.Helper()
+	store, err := storage.NewFileStore(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return store
+}
+
+// newTestHandler returns a Handler backed by a FileStore in a temp directory
+func newTestHandler(t *testing.T) (*Handler, *storage.FileStore) {
+	t.Helper()
+	store := newTestStore(t, t.TempDir())
+	return New(store, DefaultMaxUploadMB, "", nil), store
+}
+
+// newTestSession creates a session holding xf (if not nil) and returns its ID
+func newTestSession(t *testing.T, store *storage.FileStore, xf *models.XFile) string {
+	t.Helper()
+	id, err := store.CreateSession()
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if xf != nil {
+		if err := store.UpdateSession(id, xf); err != nil {
+			t.Fatalf("UpdateSession: %v", err)
+		}
+	}
+	return id
+}
+
+// withSession returns r as SessionMiddleware passes it on for sessionID
+func withSession(r *http.Request, sessionID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionIDKey, sessionID))
+}
+
+// sessionCookie returns the cookie SessionMiddleware reads for sessionID
+func sessionCookie(sessionID string) *http.Cookie {
+	return &http.Cookie{Name: sessionCookieName, Value: sessionID}
+}
+
+// decodeJSON decodes a JSON response body into a map
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+// validBoard returns a small job that passes validation: two calibrated
+// stations and three components placed on them
+func validBoard() *models.XFile {
+	xf := models.NewXFile()
+	xf.OriginalPOS = "board.pos"
+	xf.Stations = []models.XStation{
+		{No: 0, ID: 1, DeltX: 100, DeltY: 50, FeedRates: 4, Note: "10k", Height: 0.5, Speed: 100, Status: 6, PHead: 1, NThreshold: 60, NVisualRadio: 100},
+		{No: 1, ID: 2, DeltX: 120, DeltY: 50, FeedRates: 4, Note: "100nF", Height: 0.5, Speed: 100, Status: 6, PHead: 1, NThreshold: 60, NVisualRadio: 100},
+	}
+	xf.Components = []models.XComponent{
+		{No: 0, ID: 1, PHead: 1, STNo: 1, DeltX: 10, DeltY: 10, Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R1 - R_0603", Side: "top"},
+		{No: 1, ID: 2, PHead: 1, STNo: 1, DeltX: 20, DeltY: 10, Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R2 - R_0603", Side: "top"},
+		{No: 2, ID: 3, PHead: 1, STNo: 2, DeltX: 15, DeltY: 30, Angle: 90, Height: 0.5, Skip: 6, Speed: 100, Explain: "100nF", Note: "C1 - C_0603", Side: "top"},
+	}
+	xf.POSRows = []models.POSRow{
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 10, PosY: 10, Side: "top"},
+		{Ref: "R2", Val: "10k", Package: "R_0603", PosX: 20, PosY: 10, Side: "top"},
+		{Ref: "C1", Val: "100nF", Package: "C_0603", PosX: 15, PosY: 30, Rot: 90, Side: "top"},
+	}
+	return xf
+}
+
+// testPOS is a small KiCad placement file used by the upload tests
+const testPOS = `### Footprint positions - created on 2026-10-15
+## Unit = mm, Angle = deg.
+## Side : top
+# Ref     Val       Package                PosX       PosY       Rot  Side
+C1        100nF     C_0603_1608Metric      10.0000    5.0000     90.0000  top
+C2        100nF     C_0603_1608Metric      15.0000    5.0000     90.0000  top
+R1        10k       R_0603_1608Metric      20.0000    8.0000      0.0000  top
+U1        LM358     SOIC-8_3.9x4.9mm       30.0000   15.0000    270.0000  top
+## End
+`
+
+// formFile is one file part of a multipart upload
+type formFile struct {
+	field    string // Form field name, e.g. "file"
+	filename string
+	data     []byte
+	encoding string // Content-Encoding of the part, if any
+}
+
+// uploadRequest builds a multipart POST request carrying files
+func uploadRequest(t *testing.T, target string, files ...formFile) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range files {
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, f.field, f.filename))
+		hdr.Set("Content-Type", "application/octet-stream")
+		if f.encoding != "" {
+			hdr.Set("Content-Encoding", f.encoding)
+		}
+		part, err := mw.CreatePart(hdr)
+		if err != nil {
+			t.Fatalf("CreatePart: %v", err)
+		}
+		part.Write(f.data)
+	}
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, target, &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestReset(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := models.NewXFile()
+	xf.OriginalPOS = "board.pos"
+	xf.Components = []models.XComponent{{ID: 1, Note: "R1 - R_0603"}}
+	id := newTestSession(t, store, xf)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/reset", nil)
+	r.AddCookie(sessionCookie(id))
+	w := httptest.NewRecorder()
+	h.SessionMiddleware(http.HandlerFunc(h.Reset)).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if body := decodeJSON(t, w); body["success"] != true {
+		t.Errorf("response %v, want success", body)
+	}
+	got, err := store.GetSession(id)
+	if err != nil {
+		t.Fatalf("session %s is gone after reset: %v", id, err)
+	}
+	if len(got.Components) != 0 || got.OriginalPOS != "" {
+		t.Errorf("XFile not cleared: %d components, OriginalPOS %q", len(got.Components), got.OriginalPOS)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName && c.Value != id {
+			t.Errorf("session cookie changed to %q, want %q", c.Value, id)
+		}
+	}
+}
+
+func TestResetWithoutSession(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.Reset(w, httptest.NewRequest(http.MethodPost, "/api/reset", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", w.Code)
+	}
+}
+
+func TestUploadPOSCountsUploads(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, nil)
+	before := store.GetStats().TotalPOSUploads
+
+	r := withSession(uploadRequest(t, "/api/upload/pos", formFile{field: "file", filename: "board.pos", data: []byte(testPOS)}), id)
+	w := httptest.NewRecorder()
+	h.UploadPOS(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.GetStats(w, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	var stats storage.Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if stats.TotalPOSUploads != before+1 {
+		t.Errorf("totalPosUploads = %d, want %d", stats.TotalPOSUploads, before+1)
+	}
+}
+
+// gzipBytes returns data gzip-compressed
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadPOSGzip(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	upload := func(f formFile) map[string]interface{} {
+		t.Helper()
+		id := newTestSession(t, store, nil)
+		w := httptest.NewRecorder()
+		h.UploadPOS(w, withSession(uploadRequest(t, "/api/upload/pos", f), id))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", f.filename, w.Code, w.Body.String())
+		}
+		return decodeJSON(t, w)
+	}
+
+	plain := upload(formFile{field: "file", filename: "board.pos", data: []byte(testPOS)})
+	if plain["components"] != float64(4) {
+		t.Fatalf("plain upload: %v components, want 4", plain["components"])
+	}
+
+	compressed := gzipBytes(t, []byte(testPOS))
+	tests := []struct {
+		name string
+		file formFile
+	}{
+		{"magic bytes", formFile{field: "file", filename: "board.pos.gz", data: compressed}},
+		{"content encoding", formFile{field: "file", filename: "board.pos", data: compressed, encoding: "gzip"}},
+	}
+	for _, tt := range tests {
+		got := upload(tt.file)
+		if got["components"] != plain["components"] || got["stations"] != plain["stations"] {
+			t.Errorf("%s: %v components / %v stations, want %v / %v", tt.name,
+				got["components"], got["stations"], plain["components"], plain["stations"])
+		}
+	}
+}
+
+func TestSessionExportImportRoundTrip(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := models.NewXFile()
+	xf.OriginalPOS = "board.pos"
+	xf.GlobalOffset = models.GlobalOffset{X: 5, Y: 7.5}
+	xf.Stations = []models.XStation{{ID: 1, Note: "10k", DeltX: 100, DeltY: 50}}
+	xf.Components = []models.XComponent{{ID: 1, STNo: 1, DeltX: 10, DeltY: 20, Angle: 90, Note: "R1 - R_0603"}}
+	from := newTestSession(t, store, xf)
+
+	w := httptest.NewRecorder()
+	h.ExportSession(w, withSession(httptest.NewRequest(http.MethodGet, "/api/session/export", nil), from))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status %d: %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="board.charmtool"` {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	backup := w.Body.Bytes()
+
+	to := newTestSession(t, store, nil)
+	w = httptest.NewRecorder()
+	h.ImportSession(w, withSession(uploadRequest(t, "/api/session/import", formFile{field: "file", filename: "board.charmtool", data: backup}), to))
+	if w.Code != http.StatusOK {
+		t.Fatalf("import status %d: %s", w.Code, w.Body.String())
+	}
+
+	got, err := store.GetSession(to)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.OriginalPOS != "board.pos" || got.GlobalOffset != xf.GlobalOffset {
+		t.Errorf("imported OriginalPOS %q offset %+v", got.OriginalPOS, got.GlobalOffset)
+	}
+	if len(got.Stations) != 1 || got.Stations[0] != xf.Stations[0] {
+		t.Errorf("imported stations %+v", got.Stations)
+	}
+	if len(got.Components) != 1 || got.Components[0] != xf.Components[0] {
+		t.Errorf("imported components %+v", got.Components)
+	}
+}
+
+func TestSessionImportRejectsInvalidJSON(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, nil)
+
+	for _, data := range []string{"not json", `{"components": "nope"}`} {
+		w := httptest.NewRecorder()
+		h.ImportSession(w, withSession(uploadRequest(t, "/api/session/import", formFile{field: "file", filename: "bad.charmtool", data: []byte(data)}), id))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("import of %q: status %d, want 400", data, w.Code)
+		}
+	}
+}
+
+func TestValidateSeverityFilter(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := models.NewXFile()
+	xf.Stations = []models.XStation{{ID: 1, Note: "10k", FeedRates: 4, Height: 0.5, Speed: 100, Status: 6, PHead: 1}}
+	xf.Components = []models.XComponent{
+		{ID: 1, STNo: 1, DeltX: 10, DeltY: 10, Note: "R1 - R_0603", Skip: 6, Height: 0.5, Speed: 100, PHead: 1},
+		{ID: 2, STNo: 9, DeltX: 10, DeltY: 20, Note: "R2 - R_0603", Skip: 6, Height: 0.5, Speed: 100, PHead: 1},
+	}
+	id := newTestSession(t, store, xf)
+
+	validate := func(target string) models.DPVValidationResult {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.Validate(w, withSession(httptest.NewRequest(http.MethodGet, target, nil), id))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, w.Code, w.Body.String())
+		}
+		var result models.DPVValidationResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("decode %s: %v", target, err)
+		}
+		return result
+	}
+
+	full := validate("/api/validate")
+	if full.Valid || full.ErrorCount != 1 || len(full.Errors) != 1 {
+		t.Errorf("full: valid %v with %d errors (count %d), want one error", full.Valid, len(full.Errors), full.ErrorCount)
+	}
+	if full.WarningCount == 0 || full.WarningCount != len(full.Warnings) {
+		t.Errorf("full: warningCount %d for %d warnings", full.WarningCount, len(full.Warnings))
+	}
+
+	errorsOnly := validate("/api/validate?severity=errors")
+	if len(errorsOnly.Warnings) != 0 {
+		t.Errorf("severity=errors returned %d warnings", len(errorsOnly.Warnings))
+	}
+	if errorsOnly.ErrorCount != full.ErrorCount || len(errorsOnly.Errors) != len(full.Errors) {
+		t.Errorf("severity=errors: %d errors (count %d), want %d", len(errorsOnly.Errors), errorsOnly.ErrorCount, full.ErrorCount)
+	}
+	if errorsOnly.WarningCount != full.WarningCount {
+		t.Errorf("severity=errors: warningCount %d, want the total %d", errorsOnly.WarningCount, full.WarningCount)
+	}
+}
+
+func TestExportPreview(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	w := httptest.NewRecorder()
+	h.Export(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export?preview=true", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type %q, want JSON", ct)
+	}
+
+	var files map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	for _, key := range []string{"dpv", "stack", "pos", "readme"} {
+		if files[key] == "" {
+			t.Errorf("preview has no %q file", key)
+		}
+	}
+	if !strings.HasPrefix(files["dpv"], "separated") {
+		t.Errorf("dpv starts with %q, want \"separated\"", strings.SplitN(files["dpv"], "\n", 2)[0])
+	}
+	if store.GetStats().TotalExports != 0 {
+		t.Error("preview counted as an export")
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	_, store := newTestHandler(t)
+	h := New(store, 1, "", nil)
+	id := newTestSession(t, store, nil)
+	big := []byte(strings.Repeat("# padding\n", 200_000) + testPOS) // 2 MB
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"pos", h.UploadPOS, "/api/upload/pos"},
+		{"stack", h.UploadStack, "/api/upload/stack"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, withSession(uploadRequest(t, tt.target, formFile{field: "file", filename: "big.pos", data: big}), id))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status %d, want 413", tt.name, w.Code)
+			continue
+		}
+		body := decodeJSON(t, w)
+		if body["success"] != false || body["message"] != "Upload exceeds the 1 MB size limit" {
+			t.Errorf("%s: response %v", tt.name, body)
+		}
+	}
+
+	// A file under the limit is still accepted
+	w := httptest.NewRecorder()
+	h.UploadPOS(w, withSession(uploadRequest(t, "/api/upload/pos", formFile{field: "file", filename: "board.pos", data: []byte(testPOS)}), id))
+	if w.Code != http.StatusOK {
+		t.Errorf("small upload: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatchXFile(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.PatchXFile(w, withSession(httptest.NewRequest(http.MethodPatch, "/api/xfile/patch", strings.NewReader(body)), id))
+		return w
+	}
+
+	w := patch(`{"components":[{"index":1,"fields":{"angle":45,"dnp":true}}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	got, err := store.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	want := validBoard().Components
+	want[1].Angle, want[1].DNP = 45, true
+	for i, c := range got.Components {
+		if c != want[i] {
+			t.Errorf("component %d = %+v, want %+v", i, c, want[i])
+		}
+	}
+
+	// Out-of-range indexes are rejected without changing anything
+	w = patch(`{"components":[{"index":0,"fields":{"angle":180}},{"index":3,"fields":{"dnp":true}}]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("out-of-range patch: status %d, want 400", w.Code)
+	}
+	if got, _ := store.GetSession(id); got.Components[0].Angle != 0 {
+		t.Errorf("rejected patch changed component 0 angle to %v", got.Components[0].Angle)
+	}
+}
+
+func TestUploadStackRecordsStackFile(t *testing.T) {
+	h, store := newTestHandler(t)
+	board := validBoard()
+	id := newTestSession(t, store, board)
+
+	calibrated := validBoard()
+	calibrated.Stations[0].DeltX, calibrated.Stations[0].DeltY = 101.5, 52.25
+	stack := models.GenerateStack(calibrated, models.DefaultPrecision)
+
+	w := httptest.NewRecorder()
+	h.UploadStack(w, withSession(uploadRequest(t, "/api/upload/stack", formFile{field: "file", filename: "feeders.stack", data: []byte(stack)}), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.GetXFile(w, withSession(httptest.NewRequest(http.MethodGet, "/api/xfile", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("get status %d: %s", w.Code, w.Body.String())
+	}
+	var got models.XFile
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode xfile: %v", err)
+	}
+	if len(got.StackFiles) != 1 || got.StackFiles[0] != "feeders.stack" {
+		t.Errorf("StackFiles %q, want [feeders.stack]", got.StackFiles)
+	}
+	if s := got.Stations[0]; s.DeltX != 101.5 || s.DeltY != 52.25 {
+		t.Errorf("station 1 at (%v, %v), want the merged (101.5, 52.25)", s.DeltX, s.DeltY)
+	}
+}
+
+func TestBulkDNP(t *testing.T) {
+	h, store := newTestHandler(t)
+	tests := []struct {
+		name    string
+		body    string
+		changed float64
+		dnp     []bool // Per validBoard component: R1, R2, C1
+	}{
+		{"ref prefix", `{"match":{"refPrefix":"r"},"dnp":true}`, 2, []bool{true, true, false}},
+		{"value", `{"match":{"val":"100NF"},"dnp":true}`, 1, []bool{false, false, true}},
+		{"value and package", `{"match":{"val":"10k","package":"R_0805"},"dnp":true}`, 0, []bool{false, false, false}},
+		{"no match", `{"match":{"refPrefix":"TP"},"dnp":true}`, 0, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		id := newTestSession(t, store, validBoard())
+		w := httptest.NewRecorder()
+		h.BulkDNP(w, withSession(httptest.NewRequest(http.MethodPost, "/api/components/dnp", strings.NewReader(tt.body)), id))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		if body := decodeJSON(t, w); body["changed"] != tt.changed {
+			t.Errorf("%s: changed %v, want %v", tt.name, body["changed"], tt.changed)
+		}
+		got, err := store.GetSession(id)
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		for i, c := range got.Components {
+			if c.DNP != tt.dnp[i] {
+				t.Errorf("%s: %s DNP %v, want %v", tt.name, c.Note, c.DNP, tt.dnp[i])
+			}
+		}
+	}
+
+	id := newTestSession(t, store, validBoard())
+	w := httptest.NewRecorder()
+	h.BulkDNP(w, withSession(httptest.NewRequest(http.MethodPost, "/api/components/dnp", strings.NewReader(`{"match":{},"dnp":true}`)), id))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("empty match: status %d, want 400", w.Code)
+	}
+}
+
+// exportZip calls Export for a session and returns the ZIP entries by name
+func exportZip(t *testing.T, h *Handler, sessionID, query string) map[string]string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.Export(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export"+query, nil), sessionID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status %d: %s", w.Code, w.Body.String())
+	}
+	return readZip(t, w.Body.Bytes())
+}
+
+// readZip returns the entries of a ZIP archive by name
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open export ZIP: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestExportManifest(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := validBoard()
+	xf.GlobalOffset = models.GlobalOffset{X: 5, Y: 7}
+	xf.Components = append(xf.Components, models.XComponent{No: 3, ID: 4, PHead: 1, STNo: 1, DeltX: 90, DeltY: 90,
+		Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R3 - R_0603", Side: "top", DNP: true})
+	id := newTestSession(t, store, xf)
+
+	files := exportZip(t, h, id, "")
+	data, ok := files["manifest.json"]
+	if !ok {
+		t.Fatal("export ZIP has no manifest.json")
+	}
+	var m models.Manifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatalf("parse manifest.json: %v", err)
+	}
+	if m.Components != 3 || m.Stations != 2 || m.DNP != 1 {
+		t.Errorf("manifest counts: %d components, %d stations, %d DNP, want 3, 2, 1", m.Components, m.Stations, m.DNP)
+	}
+	if want := (models.BoundingBox{MinX: 10, MinY: 10, MaxX: 20, MaxY: 30}); m.BoundingBox != want {
+		t.Errorf("bounding box %+v, want %+v", m.BoundingBox, want)
+	}
+	if m.GlobalOffset != xf.GlobalOffset || m.OriginalPOS != "board.pos" || m.JobID == "" || m.Generated.IsZero() {
+		t.Errorf("manifest %+v", m)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	h, store := newTestHandler(t)
+	newTestSession(t, store, nil)
+	newTestSession(t, store, nil)
+
+	w := httptest.NewRecorder()
+	h.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if body := decodeJSON(t, w); body["status"] != "ok" || body["sessions"] != float64(2) {
+		t.Errorf("response %v, want status ok with 2 sessions", body)
+	}
+}
+
+func TestReadyzWaitsForLoad(t *testing.T) {
+	store, err := storage.NewFileStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	h := New(store, DefaultMaxUploadMB, "", nil)
+	api := h.SessionMiddleware(http.HandlerFunc(h.GetXFile))
+
+	w := httptest.NewRecorder()
+	h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before Load: status %d, want 503", w.Code)
+	}
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/xfile", nil))
+	if w.Code != http.StatusServiceUnavailable || store.SessionCount() != 0 {
+		t.Errorf("API before Load: status %d with %d sessions, want 503 and none created", w.Code, store.SessionCount())
+	}
+
+	if err := store.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	w = httptest.NewRecorder()
+	h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("readyz after Load: status %d, want 200", w.Code)
+	}
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/xfile", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("API after Load: status %d, want 200", w.Code)
+	}
+}
+
+func TestStationsSummary(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := validBoard()
+	xf.Stations = append(xf.Stations, models.XStation{No: 2, ID: 3, Note: "1uF", DNP: true})
+	id := newTestSession(t, store, xf)
+
+	w := httptest.NewRecorder()
+	h.Stations(w, withSession(httptest.NewRequest(http.MethodGet, "/api/stations/summary", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Stations []models.StationSummary `json:"stations"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	want := []models.StationSummary{
+		{ID: 1, Note: "10k", DeltX: 100, DeltY: 50, Refs: []string{"R1", "R2"}},
+		{ID: 2, Note: "100nF", DeltX: 120, DeltY: 50, Refs: []string{"C1"}},
+		{ID: 3, Note: "1uF", DNP: true, Refs: []string{}},
+	}
+	if !reflect.DeepEqual(body.Stations, want) {
+		t.Errorf("stations %+v, want %+v", body.Stations, want)
+	}
+}
+
+func TestSetOffset(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	setOffset := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.SetOffset(w, withSession(httptest.NewRequest(http.MethodPost, "/api/offset", strings.NewReader(body)), id))
+		return w
+	}
+
+	w := setOffset(`{"x":5.5,"y":-10}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if got := decodeJSON(t, w)["globalOffset"]; !reflect.DeepEqual(got, map[string]interface{}{"x": 5.5, "y": -10.0}) {
+		t.Errorf("response offset %v", got)
+	}
+
+	// Read it back through GET /api/xfile
+	w = httptest.NewRecorder()
+	h.GetXFile(w, withSession(httptest.NewRequest(http.MethodGet, "/api/xfile", nil), id))
+	var xf models.XFile
+	if err := json.NewDecoder(w.Body).Decode(&xf); err != nil {
+		t.Fatalf("decode X file: %v", err)
+	}
+	if xf.GlobalOffset != (models.GlobalOffset{X: 5.5, Y: -10}) {
+		t.Errorf("stored offset %+v, want {5.5 -10}", xf.GlobalOffset)
+	}
+
+	// Missing and non-numeric values are rejected without changing the offset
+	for _, body := range []string{`{"x":1}`, `{"x":"1e999","y":0}`, `{"x":1e999,"y":0}`} {
+		if w := setOffset(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if got, _ := store.GetSession(id); got.GlobalOffset != (models.GlobalOffset{X: 5.5, Y: -10}) {
+		t.Errorf("rejected requests changed the offset to %+v", got.GlobalOffset)
+	}
+}
+
+func TestExportValidationReport(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	// A clean job says so
+	files := exportZip(t, h, newTestSession(t, store, validBoard()), "")
+	if report := files["validation.txt"]; !strings.Contains(report, "No warnings") {
+		t.Errorf("validation.txt for a clean job:\n%s", report)
+	}
+
+	// A station used only by a DNP part is a warning the operator checks off
+	xf := validBoard()
+	xf.Stations = append(xf.Stations, models.XStation{No: 2, ID: 3, DeltX: 140, DeltY: 50, FeedRates: 4, Note: "1uF",
+		Height: 0.5, Speed: 100, Status: 6, NThreshold: 60, NVisualRadio: 100, PHead: 1})
+	xf.Components = append(xf.Components, models.XComponent{No: 3, ID: 4, PHead: 1, STNo: 3, DeltX: 40, DeltY: 10,
+		Height: 0.5, Skip: 6, Speed: 100, Explain: "1uF", Note: "C2 - C_0603", Side: "top", DNP: true})
+	files = exportZip(t, h, newTestSession(t, store, xf), "")
+	report, ok := files["validation.txt"]
+	if !ok {
+		t.Fatal("export ZIP has no validation.txt")
+	}
+	if !strings.Contains(report, "WARNINGS (1)") || !strings.Contains(report, "[ ] dnp_only_station:") {
+		t.Errorf("validation.txt does not list the DNP-only station warning:\n%s", report)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	undo := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Undo(w, withSession(httptest.NewRequest(http.MethodPost, "/api/undo", nil), id))
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	h.BulkDNP(w, withSession(httptest.NewRequest(http.MethodPost, "/api/components/dnp",
+		strings.NewReader(`{"match":{"refPrefix":"R"},"dnp":true}`)), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk DNP status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := undo(); w.Code != http.StatusOK {
+		t.Fatalf("undo status %d: %s", w.Code, w.Body.String())
+	}
+	got, err := store.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if want := validBoard().Components; !reflect.DeepEqual(got.Components, want) {
+		t.Errorf("components after undo %+v, want %+v", got.Components, want)
+	}
+
+	// Undoing the board upload leaves the empty X file of a new session,
+	// which has nothing older
+	if w := undo(); w.Code != http.StatusOK {
+		t.Fatalf("second undo status %d: %s", w.Code, w.Body.String())
+	}
+	if got, _ := store.GetSession(id); len(got.Components) != 0 {
+		t.Errorf("got %d components after undoing the upload, want 0", len(got.Components))
+	}
+	if w := undo(); w.Code != http.StatusConflict {
+		t.Errorf("third undo status %d, want 409", w.Code)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := validBoard()
+	xf.GlobalOffset = models.GlobalOffset{X: 100, Y: 50}
+	xf.Components = append(xf.Components, models.XComponent{No: 3, ID: 4, PHead: 1, STNo: 1, DeltX: 90, DeltY: 90,
+		Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R3 - R_0603", Side: "top", DNP: true})
+	id := newTestSession(t, store, xf)
+
+	w := httptest.NewRecorder()
+	h.Heatmap(w, withSession(httptest.NewRequest(http.MethodGet, "/api/heatmap?bins=4", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Heatmap models.Heatmap `json:"heatmap"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	hm := body.Heatmap
+
+	// Every active component lands in exactly one cell; the DNP one is left out
+	sum := 0
+	for _, row := range hm.Counts {
+		for _, n := range row {
+			sum += n
+		}
+	}
+	if len(hm.Counts) != 4 || sum != 3 || hm.Total != 3 {
+		t.Errorf("%d rows, cells sum to %d, total %d; want 4 rows, 3, 3", len(hm.Counts), sum, hm.Total)
+	}
+	if want := (models.BoundingBox{MinX: 110, MinY: 60, MaxX: 120, MaxY: 80}); hm.BoundingBox != want {
+		t.Errorf("bounding box %+v, want %+v with the offset applied", hm.BoundingBox, want)
+	}
+
+	w = httptest.NewRecorder()
+	h.Heatmap(w, withSession(httptest.NewRequest(http.MethodGet, "/api/heatmap?bins=0", nil), id))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bins=0: status %d, want 400", w.Code)
+	}
+}
+
+func TestExportUSBLayout(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	// Default layout: everything at the root
+	files := exportZip(t, h, id, "")
+	if _, ok := files["board.dpv"]; !ok {
+		t.Errorf("default layout has no board.dpv at the root: %v", zipNames(files))
+	}
+
+	// USB layout: the DPV in a folder named after the job, the rest at the root
+	files = exportZip(t, h, id, "?layout=usb&filename=job42")
+	dpv, ok := files["job42/job42.dpv"]
+	if !ok {
+		t.Fatalf("usb layout has no job42/job42.dpv: %v", zipNames(files))
+	}
+	if !strings.Contains(dpv, "FILE,job42.dpv") {
+		t.Errorf("DPV FILE header does not name job42.dpv:\n%s", dpv)
+	}
+	if _, ok := files["job42.dpv"]; ok {
+		t.Error("usb layout also has the DPV at the root")
+	}
+	for _, name := range []string{"material.stacks", "manifest.json", "validation.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("usb layout has no %s at the root: %v", name, zipNames(files))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.Export(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export?layout=sd", nil), id))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("layout=sd: status %d, want 400", w.Code)
+	}
+}
+
+// zipNames returns the entry names of an exported ZIP, for error messages
+func zipNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDiffAfterReupload(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, nil)
+
+	upload := func(filename, data string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.UploadPOS(w, withSession(uploadRequest(t, "/api/upload/pos", formFile{field: "file", filename: filename, data: []byte(data)}), id))
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload %s: status %d: %s", filename, w.Code, w.Body.String())
+		}
+	}
+	diff := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Diff(w, withSession(httptest.NewRequest(http.MethodGet, "/api/diff", nil), id))
+		return w
+	}
+
+	upload("board.pos", testPOS)
+	if w := diff(); w.Code != http.StatusNotFound {
+		t.Errorf("diff after the first upload: status %d, want 404", w.Code)
+	}
+
+	// Revision B: C2 removed, R1 moved 2mm, D1 added
+	revB := strings.Replace(testPOS, "C2        100nF     C_0603_1608Metric      15.0000    5.0000     90.0000  top\n", "", 1)
+	revB = strings.Replace(revB, "20.0000    8.0000", "22.0000    8.0000", 1)
+	revB = strings.Replace(revB, "## End", "D1        LED       LED_0805_2012Metric    40.0000   10.0000      0.0000  top\n## End", 1)
+	upload("board-b.pos", revB)
+
+	w := diff()
+	if w.Code != http.StatusOK {
+		t.Fatalf("diff status %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Previous string               `json:"previous"`
+		Current  string               `json:"current"`
+		Diff     models.ComponentDiff `json:"diff"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Previous != "board.pos" || body.Current != "board-b.pos" {
+		t.Errorf("compared %q with %q", body.Previous, body.Current)
+	}
+	d := body.Diff
+	if !reflect.DeepEqual(d.Added, []string{"D1"}) || !reflect.DeepEqual(d.Removed, []string{"C2"}) {
+		t.Errorf("added %v, removed %v; want [D1], [C2]", d.Added, d.Removed)
+	}
+	if len(d.Moved) != 1 || d.Moved[0].Ref != "R1" || d.Moved[0].Distance != 2 {
+		t.Errorf("moved %+v, want R1 by 2mm", d.Moved)
+	}
+}
+
+func TestUploadPOSTopBottomPair(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, nil)
+
+	// The bottom file of the pair has no Side column; R1 is also in the top file
+	const bottomPOS = `## Unit = mm, Angle = deg.
+# Ref     Val       Package                PosX       PosY       Rot
+D1        LED       LED_0805_2012Metric    25.0000   12.0000     0.0000
+R1        10k       R_0603_1608Metric      20.0000    8.0000     0.0000
+`
+	r := withSession(uploadRequest(t, "/api/upload/pos",
+		formFile{field: "file", filename: "board-top.pos", data: []byte(testPOS)},
+		formFile{field: "bottomFile", filename: "board-bottom.pos", data: []byte(bottomPOS)}), id)
+	w := httptest.NewRecorder()
+	h.UploadPOS(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	body := decodeJSON(t, w)
+	if body["components"] != 5.0 {
+		t.Errorf("components %v, want 4 top + 1 bottom", body["components"])
+	}
+	if dups, _ := body["duplicates"].([]interface{}); len(dups) != 1 || dups[0] != "R1" {
+		t.Errorf("duplicates %v, want [R1]", body["duplicates"])
+	}
+
+	xf, err := store.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	var d1 *models.XComponent
+	for i, c := range xf.Components {
+		if strings.HasPrefix(c.Note, "D1 ") {
+			d1 = &xf.Components[i]
+		}
+	}
+	if d1 == nil {
+		t.Fatalf("no D1 in %+v", xf.Components)
+	}
+	// Mirrored about the board width (the largest X, U1 at 30mm)
+	if d1.Side != "bottom" || d1.DeltX != 5 || d1.DeltY != 12 {
+		t.Errorf("D1 = side %q at (%v, %v), want bottom at (5, 12)", d1.Side, d1.DeltX, d1.DeltY)
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	validate := func(xf *models.XFile) (*httptest.ResponseRecorder, models.DPVValidationResult) {
+		t.Helper()
+		body, err := json.Marshal(xf)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		// No session cookie or context: the endpoint is for CI use
+		w := httptest.NewRecorder()
+		h.ValidateBody(w, httptest.NewRequest(http.MethodPost, "/api/validate?filename=board.dpv", bytes.NewReader(body)))
+		var result models.DPVValidationResult
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return w, result
+	}
+
+	w, result := validate(validBoard())
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if !result.Valid || result.ErrorCount != 0 || len(result.Errors) != 0 {
+		t.Errorf("valid board: %+v", result)
+	}
+
+	xf := validBoard()
+	xf.Components[0].STNo = 9
+	_, result = validate(xf)
+	if result.Valid || result.ErrorCount == 0 {
+		t.Fatalf("component on a missing station is valid: %+v", result)
+	}
+	if result.Errors[0].Type != "orphan_component" || result.Errors[0].Row != 0 {
+		t.Errorf("first error %+v, want orphan_component on row 0", result.Errors[0])
+	}
+
+	if n := store.SessionCount(); n != 0 {
+		t.Errorf("validating created %d sessions", n)
+	}
+
+	w = httptest.NewRecorder()
+	h.ValidateBody(w, httptest.NewRequest(http.MethodPost, "/api/validate", strings.NewReader("{")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: status %d, want 400", w.Code)
+	}
+}
+
+func TestExportPrecision(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	files := exportZip(t, h, id, "?precision=3")
+	if !strings.Contains(files["board.dpv"], "\r\nStation,0,1,100.000,50.000,") {
+		t.Errorf("DPV not written with 3 decimals:\n%s", files["board.dpv"])
+	}
+	for _, v := range []string{"-1", "5", "two"} {
+		w := httptest.NewRecorder()
+		h.Export(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export?precision="+v, nil), id))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("precision=%s: status %d, want 400", v, w.Code)
+		}
+	}
+}
+
+func TestUploadPOSInvertY(t *testing.T) {
+	h, store := newTestHandler(t)
+	const downwardPOS = `# Ref     Val       Package        PosX       PosY       Rot  Side
+R1        10k       R_0603         10.0000    -5.0000    0.0000  top
+R2        10k       R_0603         20.0000    -8.0000    0.0000  top
+`
+	for _, tt := range []struct {
+		query  string
+		invert bool
+		wantY  float64
+	}{
+		{query: "", invert: true, wantY: 5},
+		{query: "?invertY=false", invert: false, wantY: -5},
+	} {
+		id := newTestSession(t, store, nil)
+		w := httptest.NewRecorder()
+		h.UploadPOS(w, withSession(uploadRequest(t, "/api/upload/pos"+tt.query, formFile{field: "file", filename: "board.pos", data: []byte(downwardPOS)}), id))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: status %d: %s", tt.query, w.Code, w.Body.String())
+		}
+		if got := decodeJSON(t, w)["invertY"]; got != tt.invert {
+			t.Errorf("%q: invertY %v, want %v", tt.query, got, tt.invert)
+		}
+		if xf, _ := store.GetSession(id); xf.Components[0].DeltY != tt.wantY {
+			t.Errorf("%q: R1 DeltY %v, want %v", tt.query, xf.Components[0].DeltY, tt.wantY)
+		}
+	}
+}
+
+func TestExportDPV(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+	before := store.GetStats().TotalExports
+
+	w := httptest.NewRecorder()
+	h.ExportDPV(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export/dpv?filename=job7", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q, want text/plain", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="job7.dpv"` {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	dpv := w.Body.String()
+	if !strings.HasPrefix(dpv, "separated\r\nFILE,job7.dpv\r\n") || strings.Count(dpv, "\r\nEComponent,") != 3 {
+		t.Errorf("DPV body:\n%s", dpv)
+	}
+	if got := store.GetStats().TotalExports; got != before+1 {
+		t.Errorf("exports %d, want %d", got, before+1)
+	}
+
+	// Validation errors come back as JSON with 400, like the ZIP export
+	xf := validBoard()
+	xf.Components[0].STNo = 9
+	id = newTestSession(t, store, xf)
+	w = httptest.NewRecorder()
+	h.ExportDPV(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export/dpv", nil), id))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid job: status %d, want 400", w.Code)
+	}
+	var body struct {
+		Success    bool                       `json:"success"`
+		Validation models.DPVValidationResult `json:"validation"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Success || body.Validation.Valid || body.Validation.ErrorCount == 0 {
+		t.Errorf("invalid job response %+v", body)
+	}
+}
+
+func TestExportJobID(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	w := httptest.NewRecorder()
+	h.Export(w, withSession(httptest.NewRequest(http.MethodGet, "/api/export", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status %d: %s", w.Code, w.Body.String())
+	}
+	disposition := w.Header().Get("Content-Disposition")
+	files := readZip(t, w.Body.Bytes())
+
+	var m models.Manifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &m); err != nil {
+		t.Fatalf("parse manifest.json: %v", err)
+	}
+	if m.JobID == "" {
+		t.Fatal("manifest has no job ID")
+	}
+
+	// The same job ID in README.txt, the ZIP name and the session metadata
+	if !strings.Contains(files["README.txt"], "Job ID: "+m.JobID+"\r\n") {
+		t.Errorf("README.txt does not give job ID %s:\n%s", m.JobID, files["README.txt"])
+	}
+	if !strings.HasSuffix(disposition, "_"+m.JobID+`.zip"`) {
+		t.Errorf("Content-Disposition %q does not end with job ID %s", disposition, m.JobID)
+	}
+	xf, err := store.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if last := xf.Metadata.LastExport; last == nil || last.JobID != m.JobID || !strings.Contains(disposition, last.Filename) {
+		t.Errorf("last export %+v, want job %s", last, m.JobID)
+	}
+
+	// Recording the export is not an edit: there is still one undo step,
+	// back to the empty X file the session was created with
+	w = httptest.NewRecorder()
+	h.Undo(w, withSession(httptest.NewRequest(http.MethodPost, "/api/undo", nil), id))
+	if got, _ := store.GetSession(id); w.Code != http.StatusOK || len(got.Components) != 0 {
+		t.Errorf("undo after export: status %d, %d components; want the empty X file", w.Code, len(got.Components))
+	}
+}
+
+func TestPlacementSVG(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := validBoard()
+	xf.Components = append(xf.Components,
+		models.XComponent{No: 3, ID: 4, PHead: 1, STNo: 1, DeltX: 30, DeltY: 20, Angle: 180, Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R3 - R_0603", Side: "bottom"},
+		models.XComponent{No: 4, ID: 5, PHead: 1, STNo: 1, DeltX: 40, DeltY: 20, Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R4 - R_0603", Side: "top", DNP: true},
+	)
+	id := newTestSession(t, store, xf)
+
+	w := httptest.NewRecorder()
+	h.PlacementSVG(w, withSession(httptest.NewRequest(http.MethodGet, "/api/preview.svg", nil), id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	var svg struct {
+		Groups []struct {
+			Class string `xml:"class,attr"`
+			Title string `xml:"title"`
+		} `xml:"g"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &svg); err != nil {
+		t.Fatalf("SVG is not well-formed: %v", err)
+	}
+	classes := make(map[string][]string)
+	for _, g := range svg.Groups {
+		classes[g.Class] = append(classes[g.Class], g.Title)
+	}
+	// One element per active component, colored by side; DNP parts are
+	// drawn apart as outlines
+	if active := len(classes["top"]) + len(classes["bottom"]); active != 4 {
+		t.Errorf("%d active component elements, want 4: %v", active, classes)
+	}
+	if !reflect.DeepEqual(classes["bottom"], []string{"R3 - R_0603"}) || !reflect.DeepEqual(classes["dnp"], []string{"R4 - R_0603"}) {
+		t.Errorf("bottom %q, dnp %q", classes["bottom"], classes["dnp"])
+	}
+
+	w = httptest.NewRecorder()
+	h.PlacementSVG(w, withSession(httptest.NewRequest(http.MethodPost, "/api/preview.svg", nil), id))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", w.Code)
+	}
+}
+
+func TestSetStationCoord(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	setCoord := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.SetStationCoord(w, withSession(httptest.NewRequest(http.MethodPost, "/api/stations/coord", strings.NewReader(body)), id))
+		return w
+	}
+
+	if w := setCoord(`{"id":2,"x":131.25,"y":-4}`); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	// Read it back through GET /api/xfile
+	w := httptest.NewRecorder()
+	h.GetXFile(w, withSession(httptest.NewRequest(http.MethodGet, "/api/xfile", nil), id))
+	var xf models.XFile
+	if err := json.NewDecoder(w.Body).Decode(&xf); err != nil {
+		t.Fatalf("decode X file: %v", err)
+	}
+	if s := xf.Stations[1]; s.ID != 2 || s.DeltX != 131.25 || s.DeltY != -4 {
+		t.Errorf("station 2 = %+v, want at (131.25, -4)", s)
+	}
+	if s := xf.Stations[0]; s.DeltX != 100 || s.DeltY != 50 {
+		t.Errorf("station 1 moved to (%v, %v)", s.DeltX, s.DeltY)
+	}
+
+	// Unknown IDs and incomplete or non-finite bodies are rejected
+	for _, body := range []string{`{"id":9,"x":1,"y":1}`, `{"id":2,"x":1}`, `{"id":2,"x":1e999,"y":0}`} {
+		if w := setCoord(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if got, _ := store.GetSession(id); got.Stations[1].DeltX != 131.25 {
+		t.Errorf("rejected requests changed station 2 to %+v", got.Stations[1])
+	}
+}
+
+func TestNudge(t *testing.T) {
+	h, store := newTestHandler(t)
+	xf := validBoard()
+	xf.Components[1].DNP = true
+	id := newTestSession(t, store, xf)
+
+	nudge := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.Nudge(w, withSession(httptest.NewRequest(http.MethodPost, "/api/nudge", strings.NewReader(body)), id))
+		return w
+	}
+
+	w := nudge(`{"target":"Both","dx":2,"dy":-1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if moved := decodeJSON(t, w)["moved"]; moved != 4.0 {
+		t.Errorf("moved %v, want 4 (2 components, 2 stations)", moved)
+	}
+
+	got, err := store.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if c := got.Components[0]; c.DeltX != 12 || c.DeltY != 9 {
+		t.Errorf("R1 at (%v, %v), want (12, 9)", c.DeltX, c.DeltY)
+	}
+	if c := got.Components[1]; c.DeltX != 20 || c.DeltY != 10 {
+		t.Errorf("DNP R2 moved to (%v, %v)", c.DeltX, c.DeltY)
+	}
+	if s := got.Stations[0]; s.DeltX != 102 || s.DeltY != 49 {
+		t.Errorf("station 1 at (%v, %v), want (102, 49)", s.DeltX, s.DeltY)
+	}
+	if row := got.POSRows[0]; row.PosX != 10 || row.PosY != 10 {
+		t.Errorf("POS row changed to (%v, %v)", row.PosX, row.PosY)
+	}
+
+	for _, body := range []string{`{"target":"feeders","dx":1,"dy":1}`, `{"target":"both","dx":1}`} {
+		if w := nudge(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestAutoOffset(t *testing.T) {
+	h, store := newTestHandler(t)
+	id := newTestSession(t, store, validBoard())
+
+	autoOffset := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.AutoOffset(w, withSession(httptest.NewRequest(http.MethodPost, "/api/offset/auto", strings.NewReader(body)), id))
+		return w
+	}
+
+	// Parts span (10, 10)-(20, 30); the lowest corner moves to the margin
+	if w := autoOffset(`{"margin":2}`); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if got, _ := store.GetSession(id); got.GlobalOffset != (models.GlobalOffset{X: -8, Y: -8}) {
+		t.Errorf("offset %+v, want {-8 -8}", got.GlobalOffset)
+	}
+
+	// A negative margin would put the board below the machine origin
+	if w := autoOffset(`{"margin":-3}`); w.Code != http.StatusBadRequest {
+		t.Errorf("negative margin: status %d, want 400", w.Code)
+	}
+	if got, _ := store.GetSession(id); got.GlobalOffset != (models.GlobalOffset{X: -8, Y: -8}) {
+		t.Errorf("rejected margin changed the offset to %+v", got.GlobalOffset)
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSessionsPerMinute is how many new sessions one client IP may create
+// per minute before SessionMiddleware answers 429
+const DefaultSessionsPerMinute = 30
+
+// rateLimiter counts events per key in fixed time windows
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string]*rateWindow
+	lastPrune time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string]*rateWindow),
+	}
+}
+
+// allow records an event for key and reports whether it is within the limit
+func (rl *rateLimiter) allow(key string, now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Drop expired windows so one-off clients don't accumulate
+	if now.Sub(rl.lastPrune) > rl.window {
+		for k, w := range rl.hits {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.hits, k)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	w, ok := rl.hits[key]
+	if !ok || now.Sub(w.start) >= rl.window {
+		w = &rateWindow{start: now}
+		rl.hits[key] = w
+	}
+	if w.count >= rl.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// SetSessionRateLimit sets how many new sessions one client IP may create
+// per minute
+func (h *Handler) SetSessionRateLimit(perMinute int) {
+	h.sessionLimiter = newRateLimiter(perMinute, time.Minute)
+}
+
+// clientIP returns the IP address of the connection. X-Forwarded-For is not
+// trusted since any client can set it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionRateLimit(t *testing.T) {
+	h, store := newTestHandler(t)
+	h.SetSessionRateLimit(3)
+	handler := h.SessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(ip string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/xfile", nil)
+		r.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Rapid cookieless requests from one IP create at most 3 sessions
+	limited := 0
+	for i := 0; i < 10; i++ {
+		if request("203.0.113.7") == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	if limited != 7 {
+		t.Errorf("%d of 10 requests limited, want 7", limited)
+	}
+	if n := store.SessionCount(); n != 3 {
+		t.Errorf("%d sessions created, want 3", n)
+	}
+
+	// Other clients are not affected
+	if code := request("198.51.100.1"); code != http.StatusOK {
+		t.Errorf("request from another IP: status %d, want 200", code)
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	rl := newRateLimiter(2, time.Minute)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if !rl.allow("a", start) || !rl.allow("a", start.Add(time.Second)) {
+		t.Fatal("first two events refused")
+	}
+	if rl.allow("a", start.Add(30*time.Second)) {
+		t.Error("third event in the window allowed")
+	}
+	if !rl.allow("a", start.Add(time.Minute)) {
+		t.Error("event in the next window refused")
+	}
+}
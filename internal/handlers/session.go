@@ -2,15 +2,26 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"time"
-)
 
-const (
-	sessionCookieName = "charmtool_session"
-	sessionMaxAge     = 10 * 24 * 60 * 60 // 10 days in seconds
+	"charmtool/internal/storage"
 )
 
+const sessionCookieName = "charmtool_session"
+
+// DefaultSessionMaxAge is how long a session cookie lasts without activity
+// unless SetSessionMaxAge changes it
+const DefaultSessionMaxAge = 10 * 24 * time.Hour
+
+// SetSessionMaxAge sets the session cookie lifetime; it should match the
+// retention the store was created with so the browser keeps the cookie as
+// long as the server keeps the session
+func (h *Handler) SetSessionMaxAge(d time.Duration) {
+	h.sessionMaxAge = d
+}
+
 // contextKey is a custom type for context keys
 type contextKey string
 
@@ -19,6 +30,14 @@ const sessionIDKey contextKey = "sessionID"
 // SessionMiddleware handles session creation and validation
 func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Until stored sessions are loaded, an existing cookie would be
+		// taken as unknown and replaced with a new session
+		if !h.store.Ready() {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Sessions are still loading, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+
 		var sessionID string
 
 		// Check for existing session cookie
@@ -33,6 +52,11 @@ func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
 
 		// Create new session if needed
 		if sessionID == "" {
+			if !h.sessionLimiter.allow(clientIP(r), time.Now()) {
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "Too many new sessions, try again later", http.StatusTooManyRequests)
+				return
+			}
 			newID, err := h.store.CreateSession()
 			if err != nil {
 				http.Error(w, "Failed to create session", http.StatusInternalServerError)
@@ -45,7 +69,7 @@ func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
 				Name:     sessionCookieName,
 				Value:    sessionID,
 				Path:     "/",
-				MaxAge:   sessionMaxAge,
+				MaxAge:   int(h.sessionMaxAge.Seconds()),
 				HttpOnly: true,
 				SameSite: http.SameSiteLaxMode,
 			})
@@ -55,11 +79,11 @@ func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
 				Name:     sessionCookieName,
 				Value:    sessionID,
 				Path:     "/",
-				MaxAge:   sessionMaxAge,
+				MaxAge:   int(h.sessionMaxAge.Seconds()),
 				HttpOnly: true,
 				SameSite: http.SameSiteLaxMode,
 			})
-			// Touch session to restart 10-day server-side expiry
+			// Touch session to restart the server-side expiry
 			h.store.TouchSession(sessionID)
 		}
 
@@ -69,6 +93,48 @@ func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// DeleteSession handles DELETE /api/session
+// Registered without SessionMiddleware so a new session is not created
+// for the request that deletes the old one
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "No session", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.store.DeleteSession(cookie.Value); err != nil {
+		http.Error(w, "Failed to delete session", http.StatusInternalServerError)
+		return
+	}
+
+	// Expire the session cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
 // getSessionID retrieves the session ID from the request context
 func getSessionID(r *http.Request) string {
 	if id, ok := r.Context().Value(sessionIDKey).(string); ok {
@@ -77,10 +143,35 @@ func getSessionID(r *http.Request) string {
 	return ""
 }
 
-// setCORSHeaders sets CORS headers for API responses
-func setCORSHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+// getProject returns the project selected by the ?project= query param,
+// defaulting to the session's unnamed project
+func getProject(r *http.Request) string {
+	if project := r.URL.Query().Get("project"); project != "" {
+		return project
+	}
+	return storage.DefaultProject
+}
+
+// setCORSHeaders sets CORS headers for API responses. The request Origin is
+// echoed back, with credentials allowed, only if it is in allowedOrigins;
+// an allowedOrigins entry of "*" allows any origin without credentials.
+func (h *Handler) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+	switch {
+	case h.allowedOrigins[origin]:
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	case h.allowedOrigins["*"]:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	default:
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 }
 
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteSession(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	h := New(store, DefaultMaxUploadMB, "", nil)
+	id := newTestSession(t, store, nil)
+	path := filepath.Join(dir, id+".json")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("session file missing before delete: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/session", nil)
+	r.AddCookie(sessionCookie(id))
+	w := httptest.NewRecorder()
+	h.DeleteSession(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("session file still exists: %v", err)
+	}
+	if store.SessionExists(id) || store.SessionCount() != 0 {
+		t.Errorf("session still stored (%d sessions)", store.SessionCount())
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || cookies[0].MaxAge >= 0 || cookies[0].Value != "" {
+		t.Errorf("cookies %+v, want the session cookie expired", cookies)
+	}
+}
+
+func TestDeleteSessionWithoutCookie(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.DeleteSession(w, httptest.NewRequest(http.MethodDelete, "/api/session", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", w.Code)
+	}
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	_, store := newTestHandler(t)
+	tests := []struct {
+		name        string
+		allowed     []string
+		origin      string
+		wantOrigin  string
+		credentials bool
+	}{
+		{"listed", []string{"https://a.example", " https://b.example/ "}, "https://b.example", "https://b.example", true},
+		{"not listed", []string{"https://a.example"}, "https://evil.example", "", false},
+		{"none configured", nil, "https://a.example", "", false},
+		{"wildcard", []string{"*"}, "https://any.example", "*", false},
+	}
+	for _, tt := range tests {
+		h := New(store, DefaultMaxUploadMB, "", tt.allowed)
+		r := httptest.NewRequest(http.MethodOptions, "/api/validate", nil)
+		r.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		h.Validate(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: preflight status %d", tt.name, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s: Allow-Origin %q, want %q", tt.name, got, tt.wantOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+			t.Errorf("%s: Allow-Credentials %v, want %v", tt.name, got, tt.credentials)
+		}
+		if methods := w.Header().Get("Access-Control-Allow-Methods"); (methods != "") != (tt.wantOrigin != "") {
+			t.Errorf("%s: Allow-Methods %q", tt.name, methods)
+		}
+	}
+}
+
+func TestSessionCookieMaxAge(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	h := New(store, DefaultMaxUploadMB, "", nil)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// sessionCookieFrom runs a request through SessionMiddleware and returns
+	// the session cookie it sets
+	sessionCookieFrom := func(r *http.Request) *http.Cookie {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.SessionMiddleware(next).ServeHTTP(w, r)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookieName {
+				return c
+			}
+		}
+		t.Fatalf("no session cookie set (status %d)", w.Code)
+		return nil
+	}
+
+	if c := sessionCookieFrom(httptest.NewRequest(http.MethodGet, "/", nil)); c.MaxAge != 10*24*60*60 {
+		t.Errorf("default Max-Age %d, want 10 days", c.MaxAge)
+	}
+
+	h.SetSessionMaxAge(30 * 24 * time.Hour)
+	created := sessionCookieFrom(httptest.NewRequest(http.MethodGet, "/", nil))
+	if created.MaxAge != 30*24*60*60 {
+		t.Errorf("new session Max-Age %d, want 30 days", created.MaxAge)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(sessionCookie(created.Value))
+	if c := sessionCookieFrom(r); c.Value != created.Value || c.MaxAge != 30*24*60*60 {
+		t.Errorf("refreshed cookie %q Max-Age %d, want session %q for 30 days", c.Value, c.MaxAge, created.Value)
+	}
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BOMEntry holds the placement state of a single reference from a BOM
+type BOMEntry struct {
+	Ref string `json:"ref"`
+	DNP bool   `json:"dnp"`
+}
+
+// ParseBOM parses a CSV BOM with a Ref column and a DNP or Fitted column.
+// Ref cells may list several references, including ranges ("R1-R4,R7").
+func ParseBOM(r io.Reader) ([]BOMEntry, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read BOM file: %w", err)
+	}
+	text := strings.TrimPrefix(string(content), "\xef\xbb\xbf")
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse BOM CSV: %w", err)
+	}
+
+	// Find header row
+	refCol, dnpCol, fittedCol := -1, -1, -1
+	headerIdx := -1
+	for i, row := range rows {
+		refCol, dnpCol, fittedCol = -1, -1, -1
+		for j, cell := range row {
+			switch strings.ToLower(strings.TrimSpace(cell)) {
+			case "ref", "refs", "reference", "references", "designator", "designators":
+				refCol = j
+			case "dnp", "dnf", "do not place", "do not populate":
+				dnpCol = j
+			case "fitted", "populate", "place":
+				fittedCol = j
+			}
+		}
+		if refCol >= 0 && (dnpCol >= 0 || fittedCol >= 0) {
+			headerIdx = i
+			break
+		}
+	}
+
+	if headerIdx == -1 {
+		return nil, fmt.Errorf("could not find BOM header row (need Ref and DNP or Fitted columns)")
+	}
+
+	entries := []BOMEntry{}
+	for _, row := range rows[headerIdx+1:] {
+		if refCol >= len(row) {
+			continue
+		}
+
+		dnp := false
+		if dnpCol >= 0 && dnpCol < len(row) {
+			dnp = isBOMTrue(row[dnpCol])
+		} else if fittedCol >= 0 && fittedCol < len(row) {
+			dnp = isBOMFalse(row[fittedCol])
+		}
+
+		for _, ref := range expandRefs(row[refCol]) {
+			entries = append(entries, BOMEntry{Ref: ref, DNP: dnp})
+		}
+	}
+
+	return entries, nil
+}
+
+// isBOMTrue reports whether a DNP column value marks the part as not placed
+func isBOMTrue(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "dnp", "dnf", "yes", "y", "true", "1", "x":
+		return true
+	}
+	return false
+}
+
+// isBOMFalse reports whether a Fitted column value marks the part as not placed
+func isBOMFalse(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "no", "n", "false", "0", "not fitted", "dnp", "dnf":
+		return true
+	}
+	return false
+}
+
+// refPattern splits a reference into its letter prefix and number
+var refPattern = regexp.MustCompile(`^([A-Za-z_]+)(\d+)$`)
+
+// expandRefs splits a comma/space/semicolon separated list of references
+// and expands ranges such as "R1-R4" into R1, R2, R3, R4
+func expandRefs(cell string) []string {
+	var refs []string
+	parts := strings.FieldsFunc(cell, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	})
+	for _, part := range parts {
+		from, to, isRange := strings.Cut(part, "-")
+		if isRange {
+			fm := refPattern.FindStringSubmatch(from)
+			tm := refPattern.FindStringSubmatch(to)
+			if fm != nil && tm != nil && fm[1] == tm[1] {
+				start, _ := strconv.Atoi(fm[2])
+				end, _ := strconv.Atoi(tm[2])
+				if start <= end {
+					for n := start; n <= end; n++ {
+						refs = append(refs, fmt.Sprintf("%s%d", fm[1], n))
+					}
+					continue
+				}
+			}
+		}
+		refs = append(refs, part)
+	}
+	return refs
+}
+
+// ApplyBOM sets the DNP flag on components whose Ref appears in the BOM.
+// Returns the number of matched references and the BOM references that
+// have no matching component.
+func ApplyBOM(xf *XFile, entries []BOMEntry) (int, []string) {
+	refToIdx := make(map[string][]int)
+	for i, c := range xf.Components {
+		ref := componentRef(c)
+		refToIdx[ref] = append(refToIdx[ref], i)
+	}
+
+	matched := 0
+	unmatched := []string{}
+	for _, e := range entries {
+		indices, ok := refToIdx[e.Ref]
+		if !ok {
+			unmatched = append(unmatched, e.Ref)
+			continue
+		}
+		for _, idx := range indices {
+			xf.Components[idx].DNP = e.DNP
+		}
+		matched++
+	}
+
+	return matched, unmatched
+}
+
+// ComponentMatch selects components by value, package and/or reference
+// prefix. Empty fields are ignored; set fields must all match.
+type ComponentMatch struct {
+	Val       string `json:"val,omitempty"`
+	Package   string `json:"package,omitempty"`
+	RefPrefix string `json:"refPrefix,omitempty"`
+}
+
+// IsEmpty reports whether the match has no criteria
+func (m ComponentMatch) IsEmpty() bool {
+	return m.Val == "" && m.Package == "" && m.RefPrefix == ""
+}
+
+// Matches reports whether c satisfies every criterion (case-insensitive)
+func (m ComponentMatch) Matches(c XComponent) bool {
+	if m.Val != "" && !strings.EqualFold(c.Explain, m.Val) {
+		return false
+	}
+	if m.Package != "" && !strings.EqualFold(componentPackage(c), m.Package) {
+		return false
+	}
+	if m.RefPrefix != "" && !strings.HasPrefix(strings.ToUpper(componentRef(c)), strings.ToUpper(m.RefPrefix)) {
+		return false
+	}
+	return true
+}
+
+// SetDNPMatching sets the DNP flag on every component selected by match.
+// Returns the number of components whose flag changed.
+func SetDNPMatching(xf *XFile, match ComponentMatch, dnp bool) (int, error) {
+	if match.IsEmpty() {
+		return 0, fmt.Errorf("match needs at least one of val, package or refPrefix")
+	}
+
+	changed := 0
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		if match.Matches(*c) && c.DNP != dnp {
+			c.DNP = dnp
+			changed++
+		}
+	}
+	return changed, nil
+}
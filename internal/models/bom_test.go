@@ -0,0 +1,70 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandRefs(t *testing.T) {
+	tests := []struct {
+		cell string
+		want []string
+	}{
+		{"R1-R4,R7", []string{"R1", "R2", "R3", "R4", "R7"}},
+		{"C1, C2 C5", []string{"C1", "C2", "C5"}},
+		{"U1;U3", []string{"U1", "U3"}},
+		{"R4-R2", []string{"R4-R2"}}, // backwards range kept as written
+		{"R1-C3", []string{"R1-C3"}}, // mixed prefixes are not a range
+	}
+	for _, tt := range tests {
+		if got := expandRefs(tt.cell); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandRefs(%q) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+func TestApplyBOM(t *testing.T) {
+	const bom = `Reference,Value,Qty,DNP
+"R1,R2",10k,2,
+C1 C2,100nF,2,DNP
+R9,1k,1,DNP
+`
+	entries, err := ParseBOM(strings.NewReader(bom))
+	if err != nil {
+		t.Fatalf("ParseBOM: %v", err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("got %d entries, want 5: %+v", len(entries), entries)
+	}
+
+	xf := NewXFile()
+	for _, ref := range []string{"R1", "R2", "C1", "C2"} {
+		xf.Components = append(xf.Components, XComponent{Note: ref + " - R_0603"})
+	}
+	xf.Components[0].DNP = true // cleared again by the BOM
+
+	matched, unmatched := ApplyBOM(xf, entries)
+	if matched != 4 {
+		t.Errorf("matched %d refs, want 4", matched)
+	}
+	if !reflect.DeepEqual(unmatched, []string{"R9"}) {
+		t.Errorf("unmatched = %q, want [R9]", unmatched)
+	}
+	for i, want := range []bool{false, false, true, true} {
+		if xf.Components[i].DNP != want {
+			t.Errorf("%s DNP = %v, want %v", xf.Components[i].Note, xf.Components[i].DNP, want)
+		}
+	}
+}
+
+func TestParseBOMFittedColumn(t *testing.T) {
+	entries, err := ParseBOM(strings.NewReader("Designator,Fitted\nR1-R2,Fitted\nR3,Not Fitted\n"))
+	if err != nil {
+		t.Fatalf("ParseBOM: %v", err)
+	}
+	want := []BOMEntry{{"R1", false}, {"R2", false}, {"R3", true}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v, want %+v", entries, want)
+	}
+}
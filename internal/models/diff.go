@@ -0,0 +1,77 @@
+package models
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultMoveThreshold is the distance (mm) a component must shift between
+// two uploads to be reported as moved by DiffComponents
+const DefaultMoveThreshold = 0.05
+
+// ComponentDiff lists the components that differ between two XFiles,
+// matched by Ref
+type ComponentDiff struct {
+	Added   []string         `json:"added"`   // Refs only in the current XFile
+	Removed []string         `json:"removed"` // Refs only in the previous XFile
+	Moved   []MovedComponent `json:"moved"`
+}
+
+// MovedComponent is a component whose position changed between uploads
+type MovedComponent struct {
+	Ref      string  `json:"ref"`
+	FromX    float64 `json:"fromX"`
+	FromY    float64 `json:"fromY"`
+	ToX      float64 `json:"toX"`
+	ToY      float64 `json:"toY"`
+	Distance float64 `json:"distance"` // mm
+}
+
+// DiffComponents compares the components of prev and cur by Ref, reporting
+// refs added and removed and those that moved by more than threshold mm.
+// Positions are compared in board coordinates (without GlobalOffset).
+// Each list is sorted by Ref.
+func DiffComponents(prev, cur *XFile, threshold float64) ComponentDiff {
+	diff := ComponentDiff{
+		Added:   []string{},
+		Removed: []string{},
+		Moved:   []MovedComponent{},
+	}
+
+	before := make(map[string]XComponent)
+	for _, c := range prev.Components {
+		before[componentRef(c)] = c
+	}
+	after := make(map[string]XComponent)
+	for _, c := range cur.Components {
+		after[componentRef(c)] = c
+	}
+
+	for ref, c := range after {
+		old, ok := before[ref]
+		if !ok {
+			diff.Added = append(diff.Added, ref)
+			continue
+		}
+		if d := math.Hypot(c.DeltX-old.DeltX, c.DeltY-old.DeltY); d > threshold {
+			diff.Moved = append(diff.Moved, MovedComponent{
+				Ref:      ref,
+				FromX:    old.DeltX,
+				FromY:    old.DeltY,
+				ToX:      c.DeltX,
+				ToY:      c.DeltY,
+				Distance: d,
+			})
+		}
+	}
+	for ref := range before {
+		if _, ok := after[ref]; !ok {
+			diff.Removed = append(diff.Removed, ref)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Moved, func(i, j int) bool { return diff.Moved[i].Ref < diff.Moved[j].Ref })
+	return diff
+}
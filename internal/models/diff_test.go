@@ -0,0 +1,40 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDiffComponents(t *testing.T) {
+	prev := testBoard()
+	cur := testBoard()
+	cur.Components[0].DeltX += 0.02 // R1 nudged within the threshold
+	cur.Components[1].DeltX += 3    // R2 moved
+	cur.Components[1].DeltY += 4
+	cur.Components = cur.Components[:2] // C1 removed
+	cur.Components = append(cur.Components, XComponent{STNo: 2, DeltX: 50, DeltY: 50, Explain: "100nF", Note: "C2 - C_0603"})
+	cur.GlobalOffset = GlobalOffset{X: 10, Y: 10} // not a component move
+
+	diff := DiffComponents(prev, cur, 0.05)
+
+	if want := []string{"C2"}; !reflect.DeepEqual(diff.Added, want) {
+		t.Errorf("added %v, want %v", diff.Added, want)
+	}
+	if want := []string{"C1"}; !reflect.DeepEqual(diff.Removed, want) {
+		t.Errorf("removed %v, want %v", diff.Removed, want)
+	}
+	if len(diff.Moved) != 1 {
+		t.Fatalf("moved %+v, want only R2", diff.Moved)
+	}
+	m := diff.Moved[0]
+	if m.Ref != "R2" || m.FromX != 20 || m.FromY != 10 || m.ToX != 23 || m.ToY != 14 || math.Abs(m.Distance-5) > 1e-9 {
+		t.Errorf("moved %+v, want R2 from (20, 10) to (23, 14), 5mm", m)
+	}
+
+	// Identical files differ in nothing, with empty (not nil) lists
+	diff = DiffComponents(prev, testBoard(), 0.05)
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Moved) != 0 || diff.Added == nil {
+		t.Errorf("diff of identical files %+v", diff)
+	}
+}
@@ -1,11 +1,98 @@
 package models
 
 import (
+	"encoding/csv"
 	"fmt"
+	"io"
+	"math"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
+// Machine specs: PCB max size 345mm(L) x 355mm(W), XY travel 510mm x 460mm
+const (
+	maxPCBX    = 345.0
+	maxPCBY    = 355.0
+	maxTravelX = 510.0
+	maxTravelY = 460.0
+
+	// travelMargin is the clearance (mm) kept inside the XY travel for the
+	// head's approach to the feeder banks
+	travelMargin = 20.0
+)
+
+// MaxComponentHeight is the component height (mm) above which ValidateDPV
+// warns; the nozzle clears about 5mm above a standard 1.6mm board
+var MaxComponentHeight = 5.0
+
+// OverlapDistance is the distance (mm) below which two active components
+// are reported as overlapping by ValidateDPV
+var OverlapDistance = 0.3
+
+// DefaultAngleStep is the rotation resolution (degrees) component angles
+// are snapped to on export unless SetAngleStep changes it; the machine
+// rounds finer angles unpredictably
+const DefaultAngleStep = 0.5
+
+var (
+	angleStepMu sync.RWMutex
+	angleStep   = DefaultAngleStep
+)
+
+// AngleStep returns the rotation step (degrees) angles are snapped to on
+// export; 0 means angles are exported unchanged
+func AngleStep() float64 {
+	angleStepMu.RLock()
+	defer angleStepMu.RUnlock()
+	return angleStep
+}
+
+// SetAngleStep sets the rotation step used by GenerateDPV and ValidateDPV.
+// A step of 0 turns snapping off.
+func SetAngleStep(step float64) error {
+	if math.IsNaN(step) || step < 0 || step > 90 {
+		return fmt.Errorf("angle step %v must be between 0 and 90 degrees", step)
+	}
+	angleStepMu.Lock()
+	defer angleStepMu.Unlock()
+	angleStep = step
+	return nil
+}
+
+// DefaultPrecision is the number of decimals written for coordinates in
+// generated DPV and STACK files
+const DefaultPrecision = 2
+
+// MaxPrecision is the largest coordinate precision GenerateDPV and
+// GenerateStack accept; the machine works in 0.01mm steps anyway
+const MaxPrecision = 4
+
+// TapeWidthRules maps package name fragments to the carrier tape width
+// (mm) the part usually comes on, longest match wins. ValidateDPV uses it to
+// check station FeedRates against the tape's smallest pocket pitch.
+var TapeWidthRules = map[string]int{
+	"0201":    8,
+	"0402":    8,
+	"0603":    8,
+	"0805":    8,
+	"1206":    8,
+	"SOT-23":  8,
+	"SOD-123": 8,
+	"SOIC":    12,
+	"TSSOP":   12,
+	"QFN":     12,
+	"SOT-223": 12,
+	"DPAK":    16,
+	"TO-252":  16,
+	"QFP":     24,
+}
+
+// tapeMinPitch is the smallest pocket pitch (mm) of each tape width
+var tapeMinPitch = map[int]int{8: 2, 12: 4, 16: 4, 24: 8}
+
 // DPVValidationError represents a validation error
 type DPVValidationError struct {
 	Type    string `json:"type"`
@@ -16,9 +103,11 @@ type DPVValidationError struct {
 
 // DPVValidationResult contains validation results
 type DPVValidationResult struct {
-	Valid    bool                 `json:"valid"`
-	Errors   []DPVValidationError `json:"errors"`
-	Warnings []DPVValidationError `json:"warnings"`
+	Valid        bool                 `json:"valid"`
+	Errors       []DPVValidationError `json:"errors"`
+	Warnings     []DPVValidationError `json:"warnings"`
+	ErrorCount   int                  `json:"errorCount"`
+	WarningCount int                  `json:"warningCount"`
 }
 
 // ValidateDPV performs comprehensive validation per DPVFileFormat.txt specification
@@ -44,6 +133,10 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
+	// Position checks use the coordinates GenerateDPV writes, with
+	// BoardRotation and GlobalOffset applied
+	placed := machinePositions(xf, activeComponents, activeComponents)
+
 	// === STATION TABLE VALIDATION ===
 
 	// Check Station IDs are unique and within valid range
@@ -96,12 +189,12 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 
 	// Check Station Status flags
 	for i, s := range activeStations {
-		if s.Status < 0 || s.Status > 15 {
+		if msg := flagBitsProblem(s.Status); msg != "" {
 			result.Errors = append(result.Errors, DPVValidationError{
 				Type:    "invalid_station_status",
 				Field:   "Station.Status",
 				Row:     i,
-				Message: fmt.Sprintf("Station Status %d is invalid (must be 0-15)", s.Status),
+				Message: fmt.Sprintf("Station Status %d is invalid: %s", s.Status, msg),
 			})
 			result.Valid = false
 		}
@@ -119,6 +212,29 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
+	// Check Station FeedRates against the tape width of its parts; a feed
+	// shorter than the pocket pitch misfeeds
+	stationPackage := make(map[int]string)
+	for _, c := range activeComponents {
+		if _, ok := stationPackage[c.STNo]; !ok {
+			stationPackage[c.STNo] = componentPackage(c)
+		}
+	}
+	for i, s := range activeStations {
+		width, ok := lookupPackageRule(stationPackage[s.ID], TapeWidthRules)
+		if !ok {
+			continue
+		}
+		if pitch, ok := tapeMinPitch[width]; ok && s.FeedRates < pitch {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "feedrate_tape_mismatch",
+				Field:   "Station.FeedRates",
+				Row:     i,
+				Message: fmt.Sprintf("Station %d (%s) feeds %dmm but %s parts come on %dmm tape with at least %dmm pitch", s.ID, s.Note, s.FeedRates, stationPackage[s.ID], width, pitch),
+			})
+		}
+	}
+
 	// Check Station Speed (must be 0 or >= 50, where 0 means 100%)
 	for i, s := range activeStations {
 		if s.Speed != 0 && s.Speed < 50 {
@@ -180,6 +296,28 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
+	// Check Station numbers are finite - NaN/Inf would be written into the DPV
+	for i, s := range activeStations {
+		for _, f := range []struct {
+			name  string
+			value float64
+		}{
+			{"Station.DeltX", s.DeltX},
+			{"Station.DeltY", s.DeltY},
+			{"Station.Height", s.Height},
+		} {
+			if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+				result.Errors = append(result.Errors, DPVValidationError{
+					Type:    "non_finite_value",
+					Field:   f.name,
+					Row:     i,
+					Message: fmt.Sprintf("Station %d %s is %v", s.ID, f.name, f.value),
+				})
+				result.Valid = false
+			}
+		}
+	}
+
 	// Check if all Station coordinates are zero (need calibration)
 	allStationCoordsZero := true
 	for _, s := range activeStations {
@@ -198,6 +336,45 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 
 	// === COMPONENT TABLE VALIDATION ===
 
+	// Check Component numbers are finite - NaN/Inf would be written into the DPV
+	for i, c := range activeComponents {
+		for _, f := range []struct {
+			name  string
+			value float64
+		}{
+			{"EComponent.DeltX", c.DeltX},
+			{"EComponent.DeltY", c.DeltY},
+			{"EComponent.Angle", c.Angle},
+			{"EComponent.Height", c.Height},
+		} {
+			if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+				result.Errors = append(result.Errors, DPVValidationError{
+					Type:    "non_finite_value",
+					Field:   f.name,
+					Row:     i,
+					Message: fmt.Sprintf("Component %s %s is %v", c.Note, f.name, f.value),
+				})
+				result.Valid = false
+			}
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"GlobalOffset.X", xf.GlobalOffset.X},
+		{"GlobalOffset.Y", xf.GlobalOffset.Y},
+	} {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "non_finite_value",
+				Field:   f.name,
+				Message: fmt.Sprintf("%s is %v", f.name, f.value),
+			})
+			result.Valid = false
+		}
+	}
+
 	// Check Component No. is sequential (0 to N-1)
 	for i, c := range activeComponents {
 		if c.No != i {
@@ -210,6 +387,52 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
+	// Check Component IDs are unique (error) and Refs are unique (warning)
+	// Duplicates typically come from merging two POS files
+	componentIDRows := make(map[int]int)
+	componentRefRows := make(map[string]int)
+	for i, c := range activeComponents {
+		if first, ok := componentIDRows[c.ID]; ok {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "duplicate_component_id",
+				Field:   "EComponent.ID",
+				Row:     i,
+				Message: fmt.Sprintf("Duplicate Component ID %d at rows %d and %d", c.ID, first, i),
+			})
+			result.Valid = false
+		} else {
+			componentIDRows[c.ID] = i
+		}
+
+		ref := componentRef(c)
+		if ref == "" {
+			continue
+		}
+		if first, ok := componentRefRows[ref]; ok {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "duplicate_component_ref",
+				Field:   "EComponent.Note",
+				Row:     i,
+				Message: fmt.Sprintf("Duplicate Component Ref %s at rows %d and %d", ref, first, i),
+			})
+		} else {
+			componentRefRows[ref] = i
+		}
+	}
+
+	// Check Component Skip flags
+	for i, c := range activeComponents {
+		if msg := flagBitsProblem(c.Skip); msg != "" {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "invalid_component_skip",
+				Field:   "EComponent.Skip",
+				Row:     i,
+				Message: fmt.Sprintf("Component Skip %d is invalid: %s", c.Skip, msg),
+			})
+			result.Valid = false
+		}
+	}
+
 	// Check Component PHead (must be 1 or 2)
 	for i, c := range activeComponents {
 		if c.PHead != 1 && c.PHead != 2 {
@@ -236,6 +459,98 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
+	// Check every active Station is used - unused ones are dropped on export
+	usedStationIDs := make(map[int]bool)
+	for _, c := range activeComponents {
+		usedStationIDs[c.STNo] = true
+	}
+	dnpOnly := dnpOnlyStations(xf)
+	for i, s := range activeStations {
+		if dnpOnly[s.ID] {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "dnp_only_station",
+				Field:   "Station.ID",
+				Row:     i,
+				Message: fmt.Sprintf("Station %d (%s) is only used by DNP components and will not be exported", s.ID, s.Note),
+			})
+			continue
+		}
+		if !usedStationIDs[s.ID] {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "unused_station",
+				Field:   "Station.ID",
+				Row:     i,
+				Message: fmt.Sprintf("Station %d (%s) is not used by any component and will not be exported", s.ID, s.Note),
+			})
+		}
+	}
+
+	// Check Station Notes are unique - STACK merges and STNo. re-derivation
+	// match stations by Note, so a shared Note moves components between
+	// feeders. It is an error once components of that value use both.
+	noteStations := make(map[string][]XStation)
+	var notes []string
+	for _, s := range activeStations {
+		if s.Note == "" {
+			continue
+		}
+		if _, ok := noteStations[s.Note]; !ok {
+			notes = append(notes, s.Note)
+		}
+		noteStations[s.Note] = append(noteStations[s.Note], s)
+	}
+	for _, note := range notes {
+		stations := noteStations[note]
+		if len(stations) < 2 {
+			continue
+		}
+		ids := make([]string, len(stations))
+		used := make(map[int]bool)
+		for i, s := range stations {
+			ids[i] = strconv.Itoa(s.ID)
+			used[s.ID] = false
+		}
+		for _, c := range activeComponents {
+			if _, ok := used[c.STNo]; ok && c.Explain == note {
+				used[c.STNo] = true
+			}
+		}
+		inUse := 0
+		for _, u := range used {
+			if u {
+				inUse++
+			}
+		}
+		if inUse > 1 {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "ambiguous_station_note",
+				Field:   "Station.Note",
+				Message: fmt.Sprintf("Stations %s share Note %q and all hold %s components; a STACK import would move them onto one feeder. Give each station a distinct Note.", strings.Join(ids, ", "), note, note),
+			})
+			result.Valid = false
+		} else {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "duplicate_station_note",
+				Field:   "Station.Note",
+				Message: fmt.Sprintf("Stations %s share Note %q; STACK imports match stations by Note", strings.Join(ids, ", "), note),
+			})
+		}
+	}
+
+	// Check Station vision settings are only set with the Status vision flag
+	// (bit 4). Zero means the machine default, so a vision station without
+	// settings is fine.
+	for i, s := range activeStations {
+		if (s.Status&4) == 0 && (s.NThreshold != 0 || s.NVisualRadio != 0) {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "vision_settings_unused",
+				Field:   "Station.Status",
+				Row:     i,
+				Message: fmt.Sprintf("Station %d (%s) has vision disabled (Status=%d) but sets nThreshold=%d/nVisualRadio=%d", s.ID, s.Note, s.Status, s.NThreshold, s.NVisualRadio),
+			})
+		}
+	}
+
 	// Check Component Skip matches Station Status for vision flag
 	// Skip/Status mismatches will be auto-resolved on export, just warn here
 	stationStatusMap := make(map[int]int)
@@ -263,14 +578,14 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
-	// Check Component coordinates are positive
-	for i, c := range activeComponents {
+	// Check Component coordinates are positive where the machine places them
+	for i, c := range placed {
 		if c.DeltX < 0 || c.DeltY < 0 {
 			result.Warnings = append(result.Warnings, DPVValidationError{
 				Type:    "negative_coordinates",
 				Field:   "EComponent.DeltX/DeltY",
 				Row:     i,
-				Message: fmt.Sprintf("Component has negative coordinates (%.2f, %.2f) - all positions should be positive", c.DeltX, c.DeltY),
+				Message: fmt.Sprintf("Component %s has negative coordinates (%.2f, %.2f) - all positions should be positive", c.Note, c.DeltX, c.DeltY),
 			})
 		}
 	}
@@ -287,6 +602,22 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
+	// Check Component Angle is on the machine's rotation grid
+	step := AngleStep()
+	for i, c := range activeComponents {
+		if step <= 0 {
+			break
+		}
+		if q := quantizeAngle(c.Angle, step); math.Abs(q-c.Angle) > 1e-6 {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "angle_off_grid",
+				Field:   "EComponent.Angle",
+				Row:     i,
+				Message: fmt.Sprintf("Component Angle %.2f is not a multiple of %.2f degrees (will be exported as %.2f)", c.Angle, step, q),
+			})
+		}
+	}
+
 	// Check Component Speed (must be 0 or >= 50, where 0 means 100%)
 	for i, c := range activeComponents {
 		if c.Speed != 0 && c.Speed < 50 {
@@ -309,6 +640,26 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		})
 	}
 
+	// Check Component Height (used for pick Z) is within nozzle limits
+	for i, c := range activeComponents {
+		if c.Height < 0 {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "component_height_negative",
+				Field:   "EComponent.Height",
+				Row:     i,
+				Message: fmt.Sprintf("Component Height %.2f cannot be negative", c.Height),
+			})
+			result.Valid = false
+		} else if c.Height > MaxComponentHeight {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "component_height_exceeded",
+				Field:   "EComponent.Height",
+				Row:     i,
+				Message: fmt.Sprintf("Component Height %.2f exceeds maximum %.2fmm", c.Height, MaxComponentHeight),
+			})
+		}
+	}
+
 	// Check Component Height matches Station Height
 	for i, c := range activeComponents {
 		for _, s := range activeStations {
@@ -324,24 +675,79 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		}
 	}
 
-	// === PCB SIZE VALIDATION (CHM-T48VB specs) ===
-	// Machine specs: PCB max size 345mm(L) x 355mm(W), XY travel 510mm x 460mm
-	const maxPCBX = 345.0
-	const maxPCBY = 355.0
+	// Check for components placed on top of each other (usually a duplicate
+	// row), unless the pair is listed in AllowedOverlaps
+	allowedOverlap := make(map[[2]string]bool)
+	for _, pair := range xf.AllowedOverlaps {
+		allowedOverlap[pair] = true
+		allowedOverlap[[2]string{pair[1], pair[0]}] = true
+	}
+	for i := range activeComponents {
+		for j := i + 1; j < len(activeComponents); j++ {
+			a, b := activeComponents[i], activeComponents[j]
+			if math.Hypot(a.DeltX-b.DeltX, a.DeltY-b.DeltY) >= OverlapDistance {
+				continue
+			}
+			refA, refB := componentRef(a), componentRef(b)
+			if allowedOverlap[[2]string{refA, refB}] {
+				continue
+			}
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "overlapping_components",
+				Field:   "EComponent.DeltX/DeltY",
+				Row:     j,
+				Message: fmt.Sprintf("Components %s (row %d) and %s (row %d) are less than %.2fmm apart", refA, i, refB, j, OverlapDistance),
+			})
+		}
+	}
 
-	var maxX, maxY float64
-	for _, c := range activeComponents {
-		// Apply global offset to get actual placement position
-		x := c.DeltX + xf.GlobalOffset.X
-		y := c.DeltY + xf.GlobalOffset.Y
-		if x > maxX {
-			maxX = x
+	// Without a side column every part is treated as top side, so warn when
+	// the POS data looks like it covers both sides of the board
+	if hint := twoSidedHint(xf); hint != "" {
+		result.Warnings = append(result.Warnings, DPVValidationError{
+			Type:    "missing_side_column",
+			Field:   "POS.Side",
+			Message: fmt.Sprintf("POS file has no Side/Layer column but looks two-sided (%s); all parts are placed as top side. Re-export with side data or upload each side separately.", hint),
+		})
+	}
+
+	// Check text fields for line breaks - the machine reads one row per line
+	// and does not honor quoted multi-line CSV fields
+	for i, s := range activeStations {
+		if strings.ContainsAny(s.Note, "\r\n") {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "line_break_in_field",
+				Field:   "Station.Note",
+				Row:     i,
+				Message: fmt.Sprintf("Station %d Note %q contains a line break", s.ID, s.Note),
+			})
+			result.Valid = false
 		}
-		if y > maxY {
-			maxY = y
+	}
+	for i, c := range activeComponents {
+		for _, f := range []struct{ name, value string }{
+			{"EComponent.Explain", c.Explain},
+			{"EComponent.Note", c.Note},
+		} {
+			if strings.ContainsAny(f.value, "\r\n") {
+				result.Errors = append(result.Errors, DPVValidationError{
+					Type:    "line_break_in_field",
+					Field:   f.name,
+					Row:     i,
+					Message: fmt.Sprintf("Component %s %q contains a line break", f.name, f.value),
+				})
+				result.Valid = false
+			}
 		}
 	}
 
+	// === PCB SIZE VALIDATION (CHM-T48VB specs) ===
+	var maxX, maxY float64
+	for _, c := range placed {
+		maxX = math.Max(maxX, c.DeltX)
+		maxY = math.Max(maxY, c.DeltY)
+	}
+
 	if maxX > maxPCBX {
 		result.Warnings = append(result.Warnings, DPVValidationError{
 			Type:    "pcb_size_x",
@@ -357,6 +763,70 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		})
 	}
 
+	// Swapped X/Y columns turn the board 90 degrees, which can push it off
+	// the PCB area; suggest swapping when that brings more parts inside
+	swapped := append([]XComponent(nil), activeComponents...)
+	for i := range swapped {
+		swapped[i].DeltX, swapped[i].DeltY = swapped[i].DeltY, swapped[i].DeltX
+	}
+	outside, outsideSwapped := 0, 0
+	for _, c := range placed {
+		if c.DeltX > maxPCBX || c.DeltY > maxPCBY {
+			outside++
+		}
+	}
+	for _, c := range machinePositions(xf, swapped, swapped) {
+		if c.DeltX > maxPCBX || c.DeltY > maxPCBY {
+			outsideSwapped++
+		}
+	}
+	if outsideSwapped < outside {
+		result.Warnings = append(result.Warnings, DPVValidationError{
+			Type:    "xy_possibly_swapped",
+			Field:   "EComponent.DeltX/DeltY",
+			Message: fmt.Sprintf("%d components are outside the %.0fx%.0fmm PCB area but %d would be with X and Y swapped; the POS file may have its X and Y columns swapped", outside, maxPCBX, maxPCBY, outsideSwapped),
+		})
+	}
+
+	// === XY TRAVEL VALIDATION ===
+	// The head must reach every placement and every feeder pocket, so check
+	// both against the gantry travel rather than just the PCB area
+	for i, c := range placed {
+		x, y := c.DeltX, c.DeltY
+		if x > maxTravelX-travelMargin || y > maxTravelY-travelMargin || x < 0 || y < 0 {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "xy_travel_exceeded",
+				Field:   "EComponent.DeltX/DeltY",
+				Row:     i,
+				Message: fmt.Sprintf("Component %s at (%.2f, %.2f)mm is outside the usable %.0fx%.0fmm XY travel", c.Note, x, y, maxTravelX-travelMargin, maxTravelY-travelMargin),
+			})
+		}
+	}
+	if len(placed) > 0 {
+		spanMinX, spanMaxX := placed[0].DeltX, placed[0].DeltX
+		spanMinY, spanMaxY := placed[0].DeltY, placed[0].DeltY
+		extend := func(x, y float64) {
+			spanMinX, spanMaxX = math.Min(spanMinX, x), math.Max(spanMaxX, x)
+			spanMinY, spanMaxY = math.Min(spanMinY, y), math.Max(spanMaxY, y)
+		}
+		for _, c := range placed {
+			extend(c.DeltX, c.DeltY)
+		}
+		// Uncalibrated stations (0,0) say nothing about the feeder bank
+		for _, st := range activeStations {
+			if st.DeltX != 0 || st.DeltY != 0 {
+				extend(st.DeltX, st.DeltY)
+			}
+		}
+		if spanMaxX-spanMinX > maxTravelX || spanMaxY-spanMinY > maxTravelY {
+			result.Warnings = append(result.Warnings, DPVValidationError{
+				Type:    "xy_travel_span",
+				Field:   "Station/EComponent",
+				Message: fmt.Sprintf("Feeders and placements span %.2fx%.2fmm, more than the %.0fx%.0fmm XY travel", spanMaxX-spanMinX, spanMaxY-spanMinY, maxTravelX, maxTravelY),
+			})
+		}
+	}
+
 	// === PANEL_ARRAY VALIDATION ===
 	// Panel_Array is REQUIRED - machine won't allow PCB calibration without it
 	if len(xf.PanelArray) == 0 {
@@ -377,6 +847,49 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 			})
 			result.Valid = false
 		}
+		if (pa.NumX > 1 && pa.IntervalX == 0) || (pa.NumY > 1 && pa.IntervalY == 0) {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "invalid_panel_interval",
+				Field:   "Panel_Array.IntervalX/IntervalY",
+				Row:     0,
+				Message: fmt.Sprintf("Panel_Array IntervalX (%.2f) and IntervalY (%.2f) must be nonzero for a %dx%d panel", pa.IntervalX, pa.IntervalY, pa.NumX, pa.NumY),
+			})
+			result.Valid = false
+		}
+
+		// Panel_Coord holds one origin per board, skipped boards included
+		boards := pa.NumX * pa.NumY
+		if pa.NumX >= 1 && pa.NumY >= 1 && len(xf.PanelCoord) != boards {
+			result.Errors = append(result.Errors, DPVValidationError{
+				Type:    "panel_coord_count",
+				Field:   "Panel_Coord",
+				Message: fmt.Sprintf("Panel_Coord has %d entries but the %dx%d panel needs %d", len(xf.PanelCoord), pa.NumX, pa.NumY, boards),
+			})
+			result.Valid = false
+		}
+
+		// Additional rows mark boards to skip (1 to NumX*NumY)
+		for i, skip := range xf.PanelArray[1:] {
+			if skip.ID < 1 || skip.ID > boards {
+				result.Errors = append(result.Errors, DPVValidationError{
+					Type:    "invalid_panel_skip",
+					Field:   "Panel_Array.ID",
+					Row:     i + 1,
+					Message: fmt.Sprintf("Panel_Array skip board %d is out of range (1-%d)", skip.ID, boards),
+				})
+				result.Valid = false
+			}
+		}
+	}
+
+	// === BOARD ROTATION VALIDATION ===
+	if !validBoardRotation(xf.BoardRotation) {
+		result.Errors = append(result.Errors, DPVValidationError{
+			Type:    "invalid_board_rotation",
+			Field:   "BoardRotation",
+			Message: fmt.Sprintf("Board rotation %d is invalid (must be 0, 90, 180 or 270)", xf.BoardRotation),
+		})
+		result.Valid = false
 	}
 
 	// === FILE HEADER VALIDATION ===
@@ -395,12 +908,18 @@ func ValidateDPV(xf *XFile, filename string) *DPVValidationResult {
 		})
 	}
 
+	result.ErrorCount = len(result.Errors)
+	result.WarningCount = len(result.Warnings)
+
 	return result
 }
 
 // GenerateDPV generates DPV file content from XFile
-// This excludes DNP rows and applies global offset
-func GenerateDPV(xf *XFile, filename string) (string, error) {
+// This excludes DNP rows and applies global offset. When the board has no
+// fiducials and calibFromBounds is set, the CalibPoint table is seeded from
+// the corners of the placed components' bounding box instead of zeros.
+// Coordinates are written with precision decimals (0 for integers).
+func GenerateDPV(xf *XFile, filename string, calibFromBounds bool, precision int) (string, error) {
 	var sb strings.Builder
 
 	// Validate first
@@ -413,6 +932,13 @@ func GenerateDPV(xf *XFile, filename string) (string, error) {
 		return "", fmt.Errorf("DPV validation failed:\n%s", strings.Join(errMsgs, "\n"))
 	}
 
+	// Snap angles to the machine's rotation step, on a copy so the caller's
+	// XFile keeps its angles
+	snapped := *xf
+	snapped.Components = append([]XComponent(nil), xf.Components...)
+	QuantizeAngles(&snapped, AngleStep())
+	xf = &snapped
+
 	// Filter out DNP items
 	activeComponents := []XComponent{}
 	activeStations := []XStation{}
@@ -443,8 +969,8 @@ func GenerateDPV(xf *XFile, filename string) (string, error) {
 	sb.WriteString("\r\n")
 	sb.WriteString("Table,No.,ID,DeltX,DeltY,FeedRates,Note,Height,Speed,Status,nPixSizeX,nPixSizeY,HeightTake,DelayTake,nPullStripSpeed,nThreshold,nVisualRadio\r\n")
 	for i, s := range activeStations {
-		sb.WriteString(fmt.Sprintf("Station,%d,%d,%.2f,%.2f,%d,%s,%.2f,%d,%d,%d,%d,%.2f,%d,%d,%d,%d\r\n",
-			i, s.ID, s.DeltX, s.DeltY, s.FeedRates, csvEscape(s.Note),
+		sb.WriteString(fmt.Sprintf("Station,%d,%d,%.*f,%.*f,%d,%s,%.2f,%d,%d,%d,%d,%.2f,%d,%d,%d,%d\r\n",
+			i, s.ID, precision, s.DeltX, precision, s.DeltY, s.FeedRates, csvEscape(s.Note),
 			s.Height, s.Speed, s.Status, s.NPixSizeX, s.NPixSizeY,
 			s.HeightTake, s.DelayTake, s.NPullStripSpeed, s.NThreshold, s.NVisualRadio))
 	}
@@ -453,8 +979,17 @@ func GenerateDPV(xf *XFile, filename string) (string, error) {
 	sb.WriteString("\r\n")
 	sb.WriteString("Table,No.,ID,IntervalX,IntervalY,NumX,NumY\r\n")
 	for i, pa := range xf.PanelArray {
-		sb.WriteString(fmt.Sprintf("Panel_Array,%d,%d,%.2f,%.2f,%d,%d\r\n",
-			i, pa.ID, pa.IntervalX, pa.IntervalY, pa.NumX, pa.NumY))
+		sb.WriteString(fmt.Sprintf("Panel_Array,%d,%d,%.*f,%.*f,%d,%d\r\n",
+			i, pa.ID, precision, pa.IntervalX, precision, pa.IntervalY, pa.NumX, pa.NumY))
+	}
+
+	// Rotate the board as loaded on the machine and apply the global offset.
+	// Fiducials (often DNP) get the same transform for the CalibPoint table.
+	fiducials := machinePositions(xf, fiducialComponents(xf), activeComponents)
+	activeComponents = machinePositions(xf, activeComponents, activeComponents)
+	calibPoints := calibCorners(fiducials)
+	if calibPoints == nil && calibFromBounds {
+		calibPoints = boundsCorners(activeComponents)
 	}
 
 	// Build Station Status map for auto-fixing Skip values
@@ -467,10 +1002,6 @@ func GenerateDPV(xf *XFile, filename string) (string, error) {
 	sb.WriteString("\r\n")
 	sb.WriteString("Table,No.,ID,PHead,STNo.,DeltX,DeltY,Angle,Height,Skip,Speed,Explain,Note,Delay\r\n")
 	for i, c := range activeComponents {
-		// Apply global offset
-		deltX := c.DeltX + xf.GlobalOffset.X
-		deltY := c.DeltY + xf.GlobalOffset.Y
-
 		// Auto-fix Skip to match Station Status flags (vision, vacuum, etc.)
 		skip := c.Skip
 		if stationStatus, ok := stationStatusMap[c.STNo]; ok {
@@ -484,14 +1015,18 @@ func GenerateDPV(xf *XFile, filename string) (string, error) {
 			}
 		}
 
-		sb.WriteString(fmt.Sprintf("EComponent,%d,%d,%d,%d,%.2f,%.2f,%.2f,%.2f,%d,%d,%s,%s,%d\r\n",
-			i, c.ID, c.PHead, c.STNo, deltX, deltY, c.Angle,
+		sb.WriteString(fmt.Sprintf("EComponent,%d,%d,%d,%d,%.*f,%.*f,%.2f,%.2f,%d,%d,%s,%s,%d\r\n",
+			i, c.ID, c.PHead, c.STNo, precision, c.DeltX, precision, c.DeltY, c.Angle,
 			c.Height, skip, c.Speed, csvEscape(c.Explain), csvEscape(c.Note), c.Delay))
 	}
 
-	// ICTray table (empty, header only)
+	// ICTray table (header only when no trays are defined)
 	sb.WriteString("\r\n")
 	sb.WriteString("Table,No.,ID,CenterX,CenterY,IntervalX,IntervalY,NumX,NumY,Start\r\n")
+	for i, t := range xf.ICTrays {
+		sb.WriteString(fmt.Sprintf("ICTray,%d,%d,%.*f,%.*f,%.*f,%.*f,%d,%d,%d\r\n",
+			i, t.ID, precision, t.CenterX, precision, t.CenterY, precision, t.IntervalX, precision, t.IntervalY, t.NumX, t.NumY, t.Start))
+	}
 
 	// PcbCalib table
 	sb.WriteString("\r\n")
@@ -499,20 +1034,208 @@ func GenerateDPV(xf *XFile, filename string) (string, error) {
 	sb.WriteString("PcbCalib,0,0,0,0\r\n")
 
 	// CalibPoint table (3 calibration points: UL, LR, LL)
+	// Pre-populated from fiducials when the POS file contains them
 	sb.WriteString("\r\n")
 	sb.WriteString("Table,No.,ID,offsetX,offsetY,Note,Model,Type,DevX,DevY\r\n")
-	sb.WriteString("CalibPoint,0,1,0,0,,0,0,0,0\r\n")
-	sb.WriteString("CalibPoint,1,2,0,0,,0,0,0,0\r\n")
-	sb.WriteString("CalibPoint,2,3,0,0,,0,0,0,0\r\n")
+	if calibPoints != nil {
+		for i, p := range calibPoints {
+			sb.WriteString(fmt.Sprintf("CalibPoint,%d,%d,%.*f,%.*f,%s,0,0,0,0\r\n", i, i+1, precision, p.X, precision, p.Y, p.Note))
+		}
+	} else {
+		sb.WriteString("CalibPoint,0,1,0,0,,0,0,0,0\r\n")
+		sb.WriteString("CalibPoint,1,2,0,0,,0,0,0,0\r\n")
+		sb.WriteString("CalibPoint,2,3,0,0,,0,0,0,0\r\n")
+	}
 
 	// CalibFator table
+	// PCB X/Y pairs are the CalibPoint positions truncated to 0.01mm and
+	// multiplied by 100; the SMT pairs are filled in by the machine
 	sb.WriteString("\r\n")
 	sb.WriteString("Table,No.,PCBX1,PCBY1,PCBX2,PCBY2,PCBX3,PCBY3,SMTX1,SMTY1,SMTX2,SMTY2,SMTX3,SMTY3,DeltaAngle\r\n")
-	sb.WriteString("CalibFator,0,0,0,0,0,0,0,0,0,0,0,0,0,0\r\n")
+	if calibPoints != nil {
+		sb.WriteString("CalibFator,0")
+		for _, p := range calibPoints {
+			sb.WriteString(fmt.Sprintf(",%d,%d", int(p.X*100), int(p.Y*100)))
+		}
+		sb.WriteString(",0,0,0,0,0,0,0\r\n")
+	} else {
+		sb.WriteString("CalibFator,0,0,0,0,0,0,0,0,0,0,0,0,0,0\r\n")
+	}
+
+	if err := checkDPVLines(sb.String()); err != nil {
+		return "", err
+	}
+	if err := checkDPVRoundTrip(sb.String(), len(activeStations), len(activeComponents)); err != nil {
+		return "", err
+	}
 
 	return sb.String(), nil
 }
 
+// checkDPVRoundTrip parses generated DPV content with ParseDPV and verifies
+// it reads back the same number of stations and components, so a quoting
+// or format regression cannot produce a file that will not re-import
+func checkDPVRoundTrip(content string, stations, components int) error {
+	if stations == 0 && components == 0 {
+		return nil // ParseDPV rejects files with no data rows
+	}
+	parsed, err := ParseDPV(strings.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("generated DPV does not parse: %w", err)
+	}
+	if len(parsed.Stations) != stations || len(parsed.Components) != components {
+		return fmt.Errorf("generated DPV reads back %d stations and %d components, expected %d and %d",
+			len(parsed.Stations), len(parsed.Components), stations, components)
+	}
+	return nil
+}
+
+// checkDPVLines verifies generated DPV content is valid UTF-8 and that every
+// line ends in \r\n with no stray \r or \n inside a row
+func checkDPVLines(content string) error {
+	if !utf8.ValidString(content) {
+		return fmt.Errorf("generated DPV is not valid UTF-8")
+	}
+	lines := strings.Split(content, "\r\n")
+	if lines[len(lines)-1] != "" {
+		return fmt.Errorf("generated DPV does not end with \\r\\n")
+	}
+	for i, line := range lines[:len(lines)-1] {
+		if strings.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("generated DPV line %d contains a bare line break: %q", i+1, line)
+		}
+	}
+	return nil
+}
+
+// QuantizeAngles snaps every component Angle to the nearest multiple of
+// step (degrees); a step <= 0 leaves the angles unchanged
+func QuantizeAngles(xf *XFile, step float64) {
+	for i := range xf.Components {
+		xf.Components[i].Angle = quantizeAngle(xf.Components[i].Angle, step)
+	}
+}
+
+// quantizeAngle snaps an angle to the nearest multiple of step, keeping it
+// in (-180, 180]; a step <= 0 leaves the angle unchanged
+func quantizeAngle(angle, step float64) float64 {
+	if step <= 0 {
+		return angle
+	}
+	return NormalizeAngle(math.Round(angle/step) * step)
+}
+
+// validBoardRotation reports whether deg is a supported board rotation
+func validBoardRotation(deg int) bool {
+	return deg == 0 || deg == 90 || deg == 180 || deg == 270
+}
+
+// definedFlagBits are the Skip/Status bits the machine understands
+// (1=skip, 2=vacuum, 4=vision, 8=pause)
+const definedFlagBits = 1 | 2 | 4 | 8
+
+// flagBitsProblem describes why a Skip/Status value is not a legal flag
+// combination, or returns "" if it is
+func flagBitsProblem(v int) string {
+	if v < 0 {
+		return "flags cannot be negative"
+	}
+	extra := v &^ definedFlagBits
+	if extra == 0 {
+		return ""
+	}
+	var bits []string
+	for bit := 16; bit <= extra; bit <<= 1 {
+		if extra&bit != 0 {
+			bits = append(bits, strconv.Itoa(bit))
+		}
+	}
+	if len(bits) == 1 {
+		return fmt.Sprintf("bit %s is undefined (valid bits: 1=skip, 2=vacuum, 4=vision, 8=pause)", bits[0])
+	}
+	return fmt.Sprintf("bits %s are undefined (valid bits: 1=skip, 2=vacuum, 4=vision, 8=pause)", strings.Join(bits, ", "))
+}
+
+// ApplyBoardRotation rotates all component positions counter-clockwise by
+// deg (0, 90, 180 or 270) about the center of the board and offsets each
+// component Angle to match. It turns the board the same way GenerateDPV
+// does for BoardRotation.
+func ApplyBoardRotation(xf *XFile, deg int) error {
+	if !validBoardRotation(deg) {
+		return fmt.Errorf("invalid board rotation %d (must be 0, 90, 180 or 270)", deg)
+	}
+	var active []XComponent
+	for _, c := range xf.Components {
+		if !c.DNP {
+			active = append(active, c)
+		}
+	}
+	rotateBoard(xf.Components, active, deg)
+	return nil
+}
+
+// rotateBoard rotates comps counter-clockwise by deg about the board
+// center: the center of the bounding box of active, the board's non-DNP
+// components
+func rotateBoard(comps, active []XComponent, deg int) {
+	cx, cy := componentsCenter(active)
+	rotateComponentsAbout(comps, deg, cx, cy)
+}
+
+// machinePositions returns copies of comps at the coordinates GenerateDPV
+// writes: rotated by BoardRotation about the center of active, the board's
+// non-DNP components, then moved by GlobalOffset
+func machinePositions(xf *XFile, comps, active []XComponent) []XComponent {
+	placed := append([]XComponent(nil), comps...)
+	rotateBoard(placed, active, xf.BoardRotation)
+	for i := range placed {
+		placed[i].DeltX += xf.GlobalOffset.X
+		placed[i].DeltY += xf.GlobalOffset.Y
+	}
+	return placed
+}
+
+// componentsCenter returns the center of the components' bounding box
+func componentsCenter(comps []XComponent) (float64, float64) {
+	if len(comps) == 0 {
+		return 0, 0
+	}
+
+	minX, minY := comps[0].DeltX, comps[0].DeltY
+	maxX, maxY := minX, minY
+	for _, c := range comps {
+		minX = math.Min(minX, c.DeltX)
+		maxX = math.Max(maxX, c.DeltX)
+		minY = math.Min(minY, c.DeltY)
+		maxY = math.Max(maxY, c.DeltY)
+	}
+	return (minX + maxX) / 2, (minY + maxY) / 2
+}
+
+// rotateComponentsAbout rotates components counter-clockwise by deg about
+// (cx, cy)
+func rotateComponentsAbout(comps []XComponent, deg int, cx, cy float64) {
+	if deg == 0 {
+		return
+	}
+
+	for i := range comps {
+		dx := comps[i].DeltX - cx
+		dy := comps[i].DeltY - cy
+		switch deg {
+		case 90:
+			dx, dy = -dy, dx
+		case 180:
+			dx, dy = -dx, -dy
+		case 270:
+			dx, dy = dy, -dx
+		}
+		comps[i].DeltX = cx + dx
+		comps[i].DeltY = cy + dy
+		comps[i].Angle = NormalizeAngle(comps[i].Angle + float64(deg))
+	}
+}
+
 // csvEscape escapes a string for CSV output
 func csvEscape(s string) string {
 	if strings.ContainsAny(s, ",\"\r\n") {
@@ -522,13 +1245,14 @@ func csvEscape(s string) string {
 }
 
 // GenerateReadme creates a README.txt with setup instructions for the export package
-func GenerateReadme(xf *XFile, filename string) string {
+func GenerateReadme(xf *XFile, filename string, job ExportInfo) string {
 	var sb strings.Builder
 
 	sb.WriteString("CharmTool Export Package - Setup Checklist\r\n")
 	sb.WriteString("==========================================\r\n")
 	sb.WriteString(fmt.Sprintf("File: %s\r\n", filename))
-	sb.WriteString(fmt.Sprintf("Generated: %s\r\n", time.Now().Format("2006-01-02 15:04:05")))
+	sb.WriteString(fmt.Sprintf("Job ID: %s\r\n", job.JobID))
+	sb.WriteString(fmt.Sprintf("Generated: %s\r\n", job.Time.Format("2006-01-02 15:04:05")))
 	sb.WriteString("\r\n")
 
 	sb.WriteString("BEFORE RUNNING THIS JOB ON THE MACHINE:\r\n")
@@ -597,6 +1321,9 @@ func GenerateReadme(xf *XFile, filename string) string {
 	sb.WriteString(fmt.Sprintf("- %s.log      : Session log\r\n", baseName))
 	sb.WriteString("- material.stacks : Calibrated feeder positions (reusable)\r\n")
 	sb.WriteString("- README.txt      : This file\r\n")
+	sb.WriteString("- manifest.json   : Job summary (counts, bounding box, offset)\r\n")
+	sb.WriteString("- validation.txt  : Validation warnings to check before running\r\n")
+	sb.WriteString("- heads.txt       : Placements, travel and part sizes per nozzle\r\n")
 	sb.WriteString("\r\n")
 	sb.WriteString("TIP: Import material.stacks into future projects to reuse\r\n")
 	sb.WriteString("     your calibrated feeder positions.\r\n")
@@ -624,3 +1351,184 @@ func GenerateReadme(xf *XFile, filename string) string {
 
 	return sb.String()
 }
+
+// GenerateValidationReport creates a validation.txt listing the warnings
+// ValidateDPV reported for an export, as a checklist for the operator.
+// Errors block export, so only warnings are listed.
+func GenerateValidationReport(result *DPVValidationResult, filename string) string {
+	var sb strings.Builder
+
+	sb.WriteString("CharmTool Validation Report\r\n")
+	sb.WriteString("===========================\r\n")
+	sb.WriteString(fmt.Sprintf("File: %s\r\n", filename))
+	sb.WriteString(fmt.Sprintf("Generated: %s\r\n", time.Now().Format("2006-01-02 15:04:05")))
+	sb.WriteString("\r\n")
+
+	if len(result.Warnings) == 0 {
+		sb.WriteString("No warnings - all validation checks passed.\r\n")
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("WARNINGS (%d) - check each before running the job:\r\n", len(result.Warnings)))
+	sb.WriteString("\r\n")
+	for _, warn := range result.Warnings {
+		sb.WriteString(fmt.Sprintf("[ ] %s: %s\r\n", warn.Type, warn.Message))
+	}
+
+	return sb.String()
+}
+
+// dpvRow gives access to the cells of a DPV table row by header name
+type dpvRow struct {
+	colMap map[string]int
+	row    []string
+}
+
+func newDPVRow(header, row []string) dpvRow {
+	colMap := make(map[string]int)
+	for i, h := range header {
+		colMap[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	return dpvRow{colMap: colMap, row: row}
+}
+
+func (d dpvRow) getValue(name string) string {
+	if idx, ok := d.colMap[name]; ok && idx < len(d.row) {
+		return strings.TrimSpace(d.row[idx])
+	}
+	return ""
+}
+
+func (d dpvRow) getInt(name string, def int) int {
+	if i, err := strconv.Atoi(d.getValue(name)); err == nil {
+		return i
+	}
+	return def
+}
+
+func (d dpvRow) getFloat(name string, def float64) float64 {
+	if f, err := strconv.ParseFloat(d.getValue(name), 64); err == nil {
+		return f
+	}
+	return def
+}
+
+// ParseDPV parses a DPV file (as written by GenerateDPV or the machine)
+// back into an XFile. Component positions are taken as-is, so any global
+// offset baked in on export is still included; see ReverseGlobalOffset.
+// Panel_Coord is rebuilt from Panel_Array when the file has no such table.
+func ParseDPV(r io.Reader) (*XFile, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DPV file: %w", err)
+	}
+
+	xf := NewXFile()
+	xf.PanelArray = []PanelArrayRow{}
+	xf.PanelCoord = []PanelCoordRow{}
+
+	var header []string
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r", ""), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.FieldsPerRecord = -1
+		row, err := reader.Read()
+		if err != nil || len(row) == 0 {
+			continue
+		}
+
+		switch strings.TrimSpace(row[0]) {
+		case "PCBFILE":
+			if len(row) > 1 {
+				xf.OriginalPOS = strings.TrimSpace(row[1])
+			}
+		case "Table":
+			header = row
+		case "Station":
+			xf.Stations = append(xf.Stations, parseStationRow(header, row))
+		case "EComponent":
+			d := newDPVRow(header, row)
+			xf.Components = append(xf.Components, XComponent{
+				No:      d.getInt("no.", len(xf.Components)),
+				ID:      d.getInt("id", len(xf.Components)+1),
+				PHead:   d.getInt("phead", 1),
+				STNo:    d.getInt("stno.", 0),
+				DeltX:   d.getFloat("deltx", 0),
+				DeltY:   d.getFloat("delty", 0),
+				Angle:   d.getFloat("angle", 0),
+				Height:  d.getFloat("height", 0),
+				Skip:    d.getInt("skip", 0),
+				Speed:   d.getInt("speed", 0),
+				Explain: d.getValue("explain"),
+				Note:    d.getValue("note"),
+				Delay:   d.getInt("delay", 0),
+			})
+		case "Panel_Array":
+			d := newDPVRow(header, row)
+			xf.PanelArray = append(xf.PanelArray, PanelArrayRow{
+				No:        d.getInt("no.", len(xf.PanelArray)),
+				ID:        d.getInt("id", 1),
+				IntervalX: d.getFloat("intervalx", 0),
+				IntervalY: d.getFloat("intervaly", 0),
+				NumX:      d.getInt("numx", 1),
+				NumY:      d.getInt("numy", 1),
+			})
+		case "Panel_Coord":
+			d := newDPVRow(header, row)
+			xf.PanelCoord = append(xf.PanelCoord, PanelCoordRow{
+				No:    d.getInt("no.", len(xf.PanelCoord)),
+				ID:    d.getInt("id", 1),
+				DeltX: d.getFloat("deltx", 0),
+				DeltY: d.getFloat("delty", 0),
+			})
+		case "ICTray":
+			xf.ICTrays = append(xf.ICTrays, parseICTrayRow(header, row))
+		}
+	}
+
+	if len(xf.Components) == 0 && len(xf.Stations) == 0 {
+		return nil, fmt.Errorf("no Station or EComponent data found in DPV file")
+	}
+
+	// The Station table has no PHead column; take it from the components
+	stationHead := make(map[int]int)
+	for _, c := range xf.Components {
+		if _, ok := stationHead[c.STNo]; !ok {
+			stationHead[c.STNo] = c.PHead
+		}
+	}
+	for i := range xf.Stations {
+		if head, ok := stationHead[xf.Stations[i].ID]; ok {
+			xf.Stations[i].PHead = head
+		}
+	}
+
+	if len(xf.PanelArray) == 0 {
+		xf.PanelArray = NewXFile().PanelArray
+	}
+	// GenerateDPV writes no Panel_Coord table, so rebuild the board origins
+	// from the Panel_Array specification
+	if pa := xf.PanelArray[0]; len(xf.PanelCoord) == 0 && pa.NumX >= 1 && pa.NumY >= 1 {
+		xf.PanelCoord = panelCoords(pa.IntervalX, pa.IntervalY, pa.NumX, pa.NumY)
+	}
+	if len(xf.PanelCoord) == 0 {
+		xf.PanelCoord = NewXFile().PanelCoord
+	}
+
+	return xf, nil
+}
+
+// ReverseGlobalOffset removes a global offset that was baked into component
+// positions on export and records it as the XFile's GlobalOffset again
+func ReverseGlobalOffset(xf *XFile, offset GlobalOffset) {
+	for i := range xf.Components {
+		xf.Components[i].DeltX -= offset.X
+		xf.Components[i].DeltY -= offset.Y
+	}
+	xf.GlobalOffset = offset
+}
@@ -0,0 +1,720 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// testBoard returns a small valid job: two calibrated stations and three
+// components placed on them
+func testBoard() *XFile {
+	xf := NewXFile()
+	xf.OriginalPOS = "board.pos"
+	xf.Stations = []XStation{
+		{No: 0, ID: 1, DeltX: 100, DeltY: 50, FeedRates: 4, Note: "10k", Height: 0.5, Speed: 100, Status: 6, PHead: 1},
+		{No: 1, ID: 2, DeltX: 120, DeltY: 50, FeedRates: 4, Note: "100nF", Height: 0.5, Speed: 100, Status: 6, PHead: 1},
+	}
+	xf.Components = []XComponent{
+		{No: 0, ID: 1, PHead: 1, STNo: 1, DeltX: 10, DeltY: 10, Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R1 - R_0603", Side: "top"},
+		{No: 1, ID: 2, PHead: 1, STNo: 1, DeltX: 20, DeltY: 10, Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R2 - R_0603", Side: "top"},
+		{No: 2, ID: 3, PHead: 1, STNo: 2, DeltX: 15, DeltY: 30, Angle: 90, Height: 0.5, Skip: 6, Speed: 100, Explain: "100nF", Note: "C1 - C_0603", Side: "top"},
+	}
+	return xf
+}
+
+// findIssue returns the first validation issue of type typ, or nil
+func findIssue(issues []DPVValidationError, typ string) *DPVValidationError {
+	for i := range issues {
+		if issues[i].Type == typ {
+			return &issues[i]
+		}
+	}
+	return nil
+}
+
+// dpvRows returns the generated DPV rows of a table, e.g. "EComponent"
+func dpvRows(dpv, table string) []string {
+	var rows []string
+	for _, line := range strings.Split(dpv, "\r\n") {
+		if strings.HasPrefix(line, table+",") {
+			rows = append(rows, line)
+		}
+	}
+	return rows
+}
+
+func TestApplyBoardRotation(t *testing.T) {
+	xf := NewXFile()
+	xf.Components = []XComponent{
+		{DeltX: 0, DeltY: 0, Angle: 135},
+		{DeltX: 10, DeltY: 20, Angle: -90},
+	}
+	if err := ApplyBoardRotation(xf, 90); err != nil {
+		t.Fatalf("ApplyBoardRotation: %v", err)
+	}
+
+	// Rotated counter-clockwise about the bounding box center (5, 10)
+	want := []struct{ x, y, angle float64 }{
+		{15, 5, -135},
+		{-5, 15, 0},
+	}
+	for i, w := range want {
+		c := xf.Components[i]
+		if c.DeltX != w.x || c.DeltY != w.y || c.Angle != w.angle {
+			t.Errorf("component %d at (%v, %v) angle %v, want (%v, %v) angle %v", i, c.DeltX, c.DeltY, c.Angle, w.x, w.y, w.angle)
+		}
+	}
+
+	if err := ApplyBoardRotation(xf, 45); err == nil {
+		t.Error("ApplyBoardRotation accepted 45 degrees")
+	}
+}
+
+func TestGenerateDPVAppliesBoardRotation(t *testing.T) {
+	xf := testBoard()
+	xf.BoardRotation = 180
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+
+	// Bounding box center is (15, 20): R1 (10, 10) lands on (20, 30) and
+	// C1 at 90 degrees turns to -90
+	rows := dpvRows(dpv, "EComponent")
+	if len(rows) != 3 {
+		t.Fatalf("got %d EComponent rows, want 3", len(rows))
+	}
+	if !strings.HasPrefix(rows[0], "EComponent,0,1,1,1,20.00,30.00,180.00,") {
+		t.Errorf("R1 row %q", rows[0])
+	}
+	if !strings.HasPrefix(rows[2], "EComponent,2,3,1,2,15.00,10.00,-90.00,") {
+		t.Errorf("C1 row %q", rows[2])
+	}
+	if xf.Components[0].DeltX != 10 {
+		t.Error("GenerateDPV modified the XFile components")
+	}
+}
+
+func TestValidateDPVRotatedBoardPositions(t *testing.T) {
+	// A 100x10mm board fits unrotated but turned 90 degrees about its
+	// center (55, 10) it reaches down to Y=-40
+	xf := testBoard()
+	xf.Components = xf.Components[:2]
+	xf.Components[0].DeltX, xf.Components[0].DeltY = 5, 5
+	xf.Components[1].DeltX, xf.Components[1].DeltY = 105, 15
+
+	res := ValidateDPV(xf, "board.dpv")
+	if w := findIssue(res.Warnings, "negative_coordinates"); w != nil {
+		t.Errorf("unrotated board warned: %s", w.Message)
+	}
+
+	xf.BoardRotation = 90
+	res = ValidateDPV(xf, "board.dpv")
+	neg := findIssue(res.Warnings, "negative_coordinates")
+	if neg == nil || neg.Row != 0 || !strings.Contains(neg.Message, "(60.00, -40.00)") {
+		t.Errorf("negative_coordinates warning = %+v, want R1 at (60.00, -40.00)", neg)
+	}
+	if findIssue(res.Warnings, "xy_travel_exceeded") == nil {
+		t.Errorf("no xy_travel_exceeded warning in %+v", res.Warnings)
+	}
+
+	// The flagged position is the one written to the file
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	if rows := dpvRows(dpv, "EComponent"); !strings.HasPrefix(rows[0], "EComponent,0,1,1,1,60.00,-40.00,") {
+		t.Errorf("R1 row %q", rows[0])
+	}
+}
+
+func TestApplyBoardRotationMatchesExport(t *testing.T) {
+	// A DNP part far off the board must not move the rotation center
+	board := func() *XFile {
+		xf := testBoard()
+		xf.Components = append(xf.Components, XComponent{No: 3, ID: 4, PHead: 1, STNo: 1, DeltX: 200, DeltY: 150,
+			Height: 0.5, Skip: 6, Speed: 100, Explain: "10k", Note: "R3 - R_0603", DNP: true})
+		return xf
+	}
+
+	rotated := board()
+	rotated.BoardRotation = 90
+	want, err := GenerateDPV(rotated, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+
+	applied := board()
+	if err := ApplyBoardRotation(applied, 90); err != nil {
+		t.Fatalf("ApplyBoardRotation: %v", err)
+	}
+	got, err := GenerateDPV(applied, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	if g, w := dpvRows(got, "EComponent"), dpvRows(want, "EComponent"); strings.Join(g, "\n") != strings.Join(w, "\n") {
+		t.Errorf("ApplyBoardRotation then export:\n%s\nexport with BoardRotation=90:\n%s", strings.Join(g, "\n"), strings.Join(w, "\n"))
+	}
+}
+
+func TestValidateDPVDuplicateComponents(t *testing.T) {
+	xf := testBoard()
+	xf.Components[2].ID = 1               // same ID as R1
+	xf.Components[1].Note = "R1 - R_0603" // same Ref as R1
+
+	result := ValidateDPV(xf, "board.dpv")
+	if result.Valid {
+		t.Error("duplicate component ID passed validation")
+	}
+
+	idErr := findIssue(result.Errors, "duplicate_component_id")
+	if idErr == nil {
+		t.Fatalf("no duplicate_component_id error in %+v", result.Errors)
+	}
+	if idErr.Row != 2 || !strings.Contains(idErr.Message, "rows 0 and 2") {
+		t.Errorf("duplicate ID error %+v, want rows 0 and 2", idErr)
+	}
+
+	refWarn := findIssue(result.Warnings, "duplicate_component_ref")
+	if refWarn == nil {
+		t.Fatalf("no duplicate_component_ref warning in %+v", result.Warnings)
+	}
+	if !strings.Contains(refWarn.Message, "R1 at rows 0 and 1") {
+		t.Errorf("duplicate ref warning %q", refWarn.Message)
+	}
+}
+
+func TestValidateDPVComponentHeights(t *testing.T) {
+	xf := testBoard()
+	xf.Components[0].Height = -0.5
+	xf.Components[1].Height = 12.0
+
+	result := ValidateDPV(xf, "board.dpv")
+	if result.Valid {
+		t.Error("negative component height passed validation")
+	}
+	if e := findIssue(result.Errors, "component_height_negative"); e == nil || e.Row != 0 {
+		t.Errorf("component_height_negative error = %+v", e)
+	}
+	if w := findIssue(result.Warnings, "component_height_exceeded"); w == nil || w.Row != 1 {
+		t.Errorf("component_height_exceeded warning = %+v", w)
+	}
+	// Both still differ from their station's 0.5mm height
+	mismatches := 0
+	for _, w := range result.Warnings {
+		if w.Type == "height_mismatch" {
+			mismatches++
+		}
+	}
+	if mismatches != 2 {
+		t.Errorf("got %d height_mismatch warnings, want 2", mismatches)
+	}
+}
+
+func TestQuantizeAngle(t *testing.T) {
+	tests := []struct {
+		angle, step, want float64
+	}{
+		{12.37, 0.5, 12.5},
+		{12.2, 0.5, 12},
+		{-12.37, 0.5, -12.5},
+		{179.9, 0.5, 180},
+		{-179.9, 0.5, 180},
+		{12.37, 0, 12.37},
+	}
+	for _, tt := range tests {
+		if got := quantizeAngle(tt.angle, tt.step); !approx(got, tt.want) {
+			t.Errorf("quantizeAngle(%v, %v) = %v, want %v", tt.angle, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestQuantizeAngles(t *testing.T) {
+	xf := testBoard()
+	xf.Components[0].Angle = 12.37
+	xf.Components[1].Angle = -0.2
+	QuantizeAngles(xf, 0.5)
+	for i, want := range []float64{12.5, 0, 90} {
+		if got := xf.Components[i].Angle; !approx(got, want) {
+			t.Errorf("component %d angle %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSetAngleStep(t *testing.T) {
+	t.Cleanup(func() { SetAngleStep(DefaultAngleStep) })
+	xf := testBoard()
+	xf.Components[0].Angle = 12.37
+
+	if err := SetAngleStep(5); err != nil {
+		t.Fatalf("SetAngleStep(5): %v", err)
+	}
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "angle_off_grid"); w == nil || !strings.Contains(w.Message, "exported as 10.00") {
+		t.Errorf("angle_off_grid warning = %+v, want 12.37 exported as 10.00", w)
+	}
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	if rows := dpvRows(dpv, "EComponent"); !strings.Contains(rows[0], ",10.00,") {
+		t.Errorf("R1 row %q, want angle 10.00", rows[0])
+	}
+
+	// A step of 0 exports angles unchanged
+	if err := SetAngleStep(0); err != nil {
+		t.Fatalf("SetAngleStep(0): %v", err)
+	}
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "angle_off_grid"); w != nil {
+		t.Errorf("snapping off but warned: %s", w.Message)
+	}
+	if dpv, _ := GenerateDPV(xf, "board.dpv", false, DefaultPrecision); !strings.Contains(dpvRows(dpv, "EComponent")[0], ",12.37,") {
+		t.Errorf("R1 row %q, want angle 12.37", dpvRows(dpv, "EComponent")[0])
+	}
+
+	for _, bad := range []float64{-0.5, 91, math.NaN()} {
+		if err := SetAngleStep(bad); err == nil {
+			t.Errorf("SetAngleStep(%v) accepted", bad)
+		}
+	}
+	if step := AngleStep(); step != 0 {
+		t.Errorf("rejected steps changed AngleStep to %v", step)
+	}
+}
+
+func TestGenerateDPVQuantizesAngles(t *testing.T) {
+	xf := testBoard()
+	xf.Components[0].Angle = 12.37
+
+	result := ValidateDPV(xf, "board.dpv")
+	if w := findIssue(result.Warnings, "angle_off_grid"); w == nil || w.Row != 0 || !strings.Contains(w.Message, "12.50") {
+		t.Errorf("angle_off_grid warning = %+v, want row 0 exported as 12.50", w)
+	}
+
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	if rows := dpvRows(dpv, "EComponent"); !strings.Contains(rows[0], ",12.50,") {
+		t.Errorf("R1 row %q, want angle 12.50", rows[0])
+	}
+	if xf.Components[0].Angle != 12.37 {
+		t.Errorf("export changed the project angle to %v", xf.Components[0].Angle)
+	}
+
+	xf.Components[0].Angle = 12.5
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "angle_off_grid"); w != nil {
+		t.Errorf("on-grid angle warned: %s", w.Message)
+	}
+}
+
+func TestValidateDPVUnusedStation(t *testing.T) {
+	xf := testBoard()
+	xf.Stations = append(xf.Stations,
+		XStation{No: 2, ID: 3, DeltX: 140, DeltY: 50, FeedRates: 4, Note: "1uF", Height: 0.5, Speed: 100, Status: 6, PHead: 1},
+		XStation{No: 3, ID: 4, DeltX: 160, DeltY: 50, FeedRates: 4, Note: "4.7uF", Height: 0.5, Speed: 100, Status: 6, PHead: 1, DNP: true},
+	)
+
+	result := ValidateDPV(xf, "board.dpv")
+	var unused []DPVValidationError
+	for _, w := range result.Warnings {
+		if w.Type == "unused_station" {
+			unused = append(unused, w)
+		}
+	}
+	if len(unused) != 1 || !strings.Contains(unused[0].Message, "1uF") {
+		t.Errorf("unused_station warnings %+v, want one for station 3 (1uF), none for the DNP station", unused)
+	}
+
+	if w := findIssue(ValidateDPV(testBoard(), "board.dpv").Warnings, "unused_station"); w != nil {
+		t.Errorf("board with every station used warned: %s", w.Message)
+	}
+}
+
+func TestValidateDPVFeedRateTape(t *testing.T) {
+	xf := testBoard()
+	xf.Components[2].Note = "U1 - QFP-48_7x7mm"
+	xf.Stations[1].FeedRates = 2
+
+	warn := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "feedrate_tape_mismatch")
+	if warn == nil || warn.Row != 1 || !strings.Contains(warn.Message, "24mm tape") {
+		t.Errorf("QFP on FeedRates=2 warning = %+v, want row 1 naming 24mm tape", warn)
+	}
+
+	xf.Stations[1].FeedRates = 8
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "feedrate_tape_mismatch"); w != nil {
+		t.Errorf("QFP on FeedRates=8 warned: %s", w.Message)
+	}
+	if w := findIssue(ValidateDPV(testBoard(), "board.dpv").Warnings, "feedrate_tape_mismatch"); w != nil {
+		t.Errorf("0603 parts on FeedRates=4 warned: %s", w.Message)
+	}
+
+	// The mapping is configurable: with 0603 on 24mm tape a 4mm feed is short
+	TapeWidthRules["0603"] = 24
+	t.Cleanup(func() { TapeWidthRules["0603"] = 8 })
+	if w := findIssue(ValidateDPV(testBoard(), "board.dpv").Warnings, "feedrate_tape_mismatch"); w == nil {
+		t.Error("0603 mapped to 24mm tape on FeedRates=4 did not warn")
+	}
+}
+
+func TestValidateDPVFlagBits(t *testing.T) {
+	xf := testBoard()
+	xf.Components[1].Skip = 16
+	xf.Stations[1].Status = 255
+
+	result := ValidateDPV(xf, "board.dpv")
+	if result.Valid {
+		t.Error("undefined flag bits passed validation")
+	}
+	skip := findIssue(result.Errors, "invalid_component_skip")
+	if skip == nil || skip.Row != 1 || !strings.Contains(skip.Message, "bit 16 ") {
+		t.Errorf("Skip=16 error = %+v, want row 1 naming bit 16", skip)
+	}
+	status := findIssue(result.Errors, "invalid_station_status")
+	if status == nil || status.Row != 1 || !strings.Contains(status.Message, "bits 16, 32, 64, 128 ") {
+		t.Errorf("Status=255 error = %+v, want row 1 naming bits 16-128", status)
+	}
+
+	if msg := flagBitsProblem(1 | 2 | 4 | 8); msg != "" {
+		t.Errorf("flags 15 reported as %q", msg)
+	}
+}
+
+func TestValidateDPVTravel(t *testing.T) {
+	if w := findIssue(ValidateDPV(testBoard(), "board.dpv").Warnings, "xy_travel_exceeded"); w != nil {
+		t.Errorf("board inside the travel warned: %s", w.Message)
+	}
+
+	xf := testBoard()
+	xf.Components[1].DeltX = 500
+	result := ValidateDPV(xf, "board.dpv")
+	w := findIssue(result.Warnings, "xy_travel_exceeded")
+	if w == nil || w.Row != 1 || !strings.Contains(w.Message, "R2") {
+		t.Errorf("travel warning = %+v, want one for R2 (row 1)", w)
+	}
+
+	// The GlobalOffset counts toward the absolute position
+	xf = testBoard()
+	xf.GlobalOffset = GlobalOffset{X: 480}
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "xy_travel_exceeded"); w == nil {
+		t.Error("no travel warning with the offset pushing parts past the travel")
+	}
+}
+
+func TestValidateDPVPanelCoordCount(t *testing.T) {
+	xf := testBoard()
+	if err := BuildPanel(xf, 50, 40, 2, 2, nil); err != nil {
+		t.Fatalf("BuildPanel: %v", err)
+	}
+	if issue := findIssue(ValidateDPV(xf, "board.dpv").Errors, "panel_coord_count"); issue != nil {
+		t.Errorf("unexpected error for a complete panel: %+v", issue)
+	}
+
+	// A 2x2 array with only 3 board origins
+	xf.PanelCoord = xf.PanelCoord[:3]
+	res := ValidateDPV(xf, "board.dpv")
+	if res.Valid {
+		t.Error("panel with a missing board origin is valid")
+	}
+	if findIssue(res.Errors, "panel_coord_count") == nil {
+		t.Errorf("no panel_coord_count error in %+v", res.Errors)
+	}
+
+	// A multi-board panel re-imported from a DPV (which has no Panel_Coord
+	// table) gets its origins back and still validates
+	if err := BuildPanel(xf, 50, 40, 2, 2, []int{3}); err != nil {
+		t.Fatalf("BuildPanel: %v", err)
+	}
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	parsed, err := ParseDPV(strings.NewReader(dpv))
+	if err != nil {
+		t.Fatalf("ParseDPV: %v", err)
+	}
+	res = ValidateDPV(parsed, "board.dpv")
+	if issue := findIssue(res.Errors, "panel_coord_count"); issue != nil {
+		t.Errorf("re-imported panel: %+v", issue)
+	}
+	if !res.Valid {
+		t.Errorf("re-imported panel invalid: %+v", res.Errors)
+	}
+}
+
+func TestParseDPVRoundTrip(t *testing.T) {
+	xf := testBoard()
+	for i := range xf.Stations {
+		xf.Stations[i].NThreshold, xf.Stations[i].NVisualRadio = 60, 100
+	}
+	xf.Components[2].Note = "C1 - C_0603, 50V"
+	xf.Components[2].Delay = 10
+	if err := BuildPanel(xf, 50, 40, 2, 2, []int{3}); err != nil {
+		t.Fatalf("BuildPanel: %v", err)
+	}
+
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	got, err := ParseDPV(strings.NewReader(dpv))
+	if err != nil {
+		t.Fatalf("ParseDPV: %v", err)
+	}
+
+	if got.OriginalPOS != xf.OriginalPOS {
+		t.Errorf("OriginalPOS %q, want %q", got.OriginalPOS, xf.OriginalPOS)
+	}
+	if len(got.Stations) != len(xf.Stations) {
+		t.Fatalf("got %d stations, want %d", len(got.Stations), len(xf.Stations))
+	}
+	for i, want := range xf.Stations {
+		if got.Stations[i] != want {
+			t.Errorf("station %d = %+v, want %+v", i, got.Stations[i], want)
+		}
+	}
+	if len(got.Components) != len(xf.Components) {
+		t.Fatalf("got %d components, want %d", len(got.Components), len(xf.Components))
+	}
+	for i, want := range xf.Components {
+		want.Side = "" // Not stored in the DPV
+		if got.Components[i] != want {
+			t.Errorf("component %d = %+v, want %+v", i, got.Components[i], want)
+		}
+	}
+
+	// The multi-board panel survives, including the skipped board and the
+	// board origins, which GenerateDPV does not write
+	if len(got.PanelArray) != len(xf.PanelArray) {
+		t.Fatalf("Panel_Array %+v, want %+v", got.PanelArray, xf.PanelArray)
+	}
+	for i, want := range xf.PanelArray {
+		if got.PanelArray[i] != want {
+			t.Errorf("Panel_Array row %d = %+v, want %+v", i, got.PanelArray[i], want)
+		}
+	}
+	if len(got.PanelCoord) != len(xf.PanelCoord) {
+		t.Fatalf("Panel_Coord %+v, want %+v", got.PanelCoord, xf.PanelCoord)
+	}
+	for i, want := range xf.PanelCoord {
+		if got.PanelCoord[i] != want {
+			t.Errorf("Panel_Coord row %d = %+v, want %+v", i, got.PanelCoord[i], want)
+		}
+	}
+	if result := ValidateDPV(got, "board.dpv"); !result.Valid {
+		t.Errorf("re-imported job fails validation: %+v", result.Errors)
+	}
+}
+
+func TestGenerateDPVSelfCheck(t *testing.T) {
+	// Commas in notes are quoted, so the self-check reads the file back
+	xf := testBoard()
+	xf.Stations[1].Note = "100nF, 50V"
+	xf.Components[2].Note = "C1 - C_0603, X7R"
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV with commas in notes: %v", err)
+	}
+	if err := checkDPVRoundTrip(dpv, 2, 3); err != nil {
+		t.Errorf("self-check: %v", err)
+	}
+
+	// A quoting regression that swallows rows is caught
+	broken := strings.Replace(dpv, `"100nF, 50V"`, `"100nF, 50V`, 1)
+	if err := checkDPVRoundTrip(broken, 2, 3); err == nil {
+		t.Error("self-check passed a station note with an unclosed quote")
+	}
+	if err := checkDPVRoundTrip(dpv, 2, 4); err == nil {
+		t.Error("self-check passed a component count mismatch")
+	}
+}
+
+func TestGenerateDPVRejectsLineBreakInNote(t *testing.T) {
+	xf := testBoard()
+	xf.Components[1].Note = "R2 - R_0603\nsecond line"
+
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Errors, "line_break_in_field"); w == nil || w.Row != 1 || w.Field != "EComponent.Note" {
+		t.Errorf("line break error = %+v, want EComponent.Note row 1", w)
+	}
+	if _, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision); err == nil || !strings.Contains(err.Error(), "line break") {
+		t.Errorf("GenerateDPV error = %v, want a line break error", err)
+	}
+
+	// A comma is quoted and keeps the row on one line
+	xf.Components[1].Note = "R2 - R_0603, 1%"
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	if err := checkDPVLines(dpv); err != nil {
+		t.Errorf("checkDPVLines: %v", err)
+	}
+	if err := checkDPVLines("separated\r\nFILE,a.dpv\n"); err == nil {
+		t.Error("checkDPVLines accepted a bare \\n line ending")
+	}
+}
+
+func TestValidateDPVOverlappingComponents(t *testing.T) {
+	xf := testBoard()
+	xf.Components[1].DeltX, xf.Components[1].DeltY = 10.1, 10.1 // 0.14mm from R1
+
+	w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "overlapping_components")
+	if w == nil || w.Row != 1 || !strings.Contains(w.Message, "R1") || !strings.Contains(w.Message, "R2") {
+		t.Errorf("overlap warning = %+v, want one naming R1 and R2", w)
+	}
+
+	// Pairs stacked by design are allowed in either order
+	xf.AllowedOverlaps = [][2]string{{"R2", "R1"}}
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "overlapping_components"); w != nil {
+		t.Errorf("allowed pair warned: %s", w.Message)
+	}
+
+	// DNP parts do not overlap anything
+	xf.AllowedOverlaps = nil
+	xf.Components[1].DNP = true
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "overlapping_components"); w != nil {
+		t.Errorf("DNP part warned: %s", w.Message)
+	}
+}
+
+func TestValidateDPVDuplicateStationNotes(t *testing.T) {
+	// A second "10k" station nothing uses: a warning only
+	xf := testBoard()
+	xf.Stations = append(xf.Stations, XStation{No: 2, ID: 3, DeltX: 140, DeltY: 50, FeedRates: 4, Note: "10k",
+		Height: 0.5, Speed: 100, Status: 6, PHead: 1})
+	res := ValidateDPV(xf, "board.dpv")
+	if findIssue(res.Warnings, "duplicate_station_note") == nil {
+		t.Errorf("no duplicate_station_note warning in %+v", res.Warnings)
+	}
+	if issue := findIssue(res.Errors, "ambiguous_station_note"); issue != nil {
+		t.Errorf("unexpected error with one station in use: %+v", issue)
+	}
+
+	// R2 moved onto the second "10k" station: both are in use, so a STACK
+	// import would put R1 and R2 back on one feeder
+	xf.Components[1].STNo = 3
+	res = ValidateDPV(xf, "board.dpv")
+	if res.Valid {
+		t.Error("job with two 10k stations in use is valid")
+	}
+	issue := findIssue(res.Errors, "ambiguous_station_note")
+	if issue == nil {
+		t.Fatalf("no ambiguous_station_note error in %+v", res.Errors)
+	}
+	if !strings.Contains(issue.Message, "Stations 1, 3") {
+		t.Errorf("message %q does not name stations 1 and 3", issue.Message)
+	}
+	if issue := findIssue(res.Warnings, "duplicate_station_note"); issue != nil {
+		t.Errorf("duplicate_station_note reported as well as the error: %+v", issue)
+	}
+}
+
+func TestValidateDPVNonFiniteValues(t *testing.T) {
+	tests := []struct {
+		field string
+		set   func(xf *XFile)
+	}{
+		{"EComponent.DeltX", func(xf *XFile) { xf.Components[0].DeltX = math.Inf(1) }},
+		{"EComponent.DeltY", func(xf *XFile) { xf.Components[1].DeltY = math.NaN() }},
+		{"EComponent.Angle", func(xf *XFile) { xf.Components[2].Angle = math.NaN() }},
+		{"EComponent.Height", func(xf *XFile) { xf.Components[0].Height = math.Inf(-1) }},
+		{"Station.DeltX", func(xf *XFile) { xf.Stations[0].DeltX = math.NaN() }},
+		{"Station.Height", func(xf *XFile) { xf.Stations[1].Height = math.Inf(1) }},
+		{"GlobalOffset.Y", func(xf *XFile) { xf.GlobalOffset.Y = math.Inf(1) }},
+	}
+	for _, tt := range tests {
+		xf := testBoard()
+		tt.set(xf)
+		res := ValidateDPV(xf, "board.dpv")
+		if res.Valid {
+			t.Errorf("%s: non-finite value is valid", tt.field)
+		}
+		issue := findIssue(res.Errors, "non_finite_value")
+		if issue == nil || issue.Field != tt.field {
+			t.Errorf("%s: non_finite_value error %+v", tt.field, issue)
+		}
+	}
+}
+
+func TestValidateDPVVisionSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		threshold  int
+		radio      int
+		wantUnused bool
+	}{
+		{name: "vision on with settings", status: 6, threshold: 110, radio: 200},
+		{name: "vision on with machine defaults", status: 6},
+		{name: "vision on with default threshold only", status: 6, radio: 200},
+		{name: "vision off with threshold", status: 2, threshold: 110, wantUnused: true},
+		{name: "vision off with ratio", status: 2, radio: 200, wantUnused: true},
+		{name: "vision off without settings", status: 2},
+	}
+	for _, tt := range tests {
+		xf := testBoard()
+		xf.Stations[0].Status = tt.status
+		xf.Stations[0].NThreshold, xf.Stations[0].NVisualRadio = tt.threshold, tt.radio
+		for i := range xf.Components {
+			if xf.Components[i].STNo == 1 {
+				xf.Components[i].Skip = tt.status
+			}
+		}
+
+		res := ValidateDPV(xf, "board.dpv")
+		if !res.Valid {
+			t.Errorf("%s: invalid: %+v", tt.name, res.Errors)
+		}
+		issue := findIssue(res.Warnings, "vision_settings_unused")
+		if (issue != nil) != tt.wantUnused {
+			t.Errorf("%s: vision_settings_unused warning %+v, want %v", tt.name, issue, tt.wantUnused)
+		}
+		if issue != nil && issue.Row != 0 {
+			t.Errorf("%s: warning on row %d, want 0", tt.name, issue.Row)
+		}
+		if len(res.Warnings) != 0 && !tt.wantUnused {
+			t.Errorf("%s: unexpected warnings %+v", tt.name, res.Warnings)
+		}
+	}
+}
+
+func TestGenerateDPVPrecision(t *testing.T) {
+	xf := testBoard()
+	xf.Stations[0].DeltX = 100.5678
+	xf.Components[0].DeltX, xf.Components[0].DeltY = 10.1234, 10.25
+
+	tests := []struct {
+		precision int
+		station   string
+		component string
+		stack     string
+	}{
+		{
+			precision: 2,
+			station:   "Station,0,1,100.57,50.00,4,10k,0.50,100,6,0,0,0.00,0,0,0,0",
+			component: "EComponent,0,1,1,1,10.12,10.25,0.00,0.50,6,100,10k,R1 - R_0603,0",
+			stack:     "Station,0,1,1,100.57,50.00,4,10k,0.50,100,6,0,0,0.00,0,0,0,0",
+		},
+		{
+			precision: 3,
+			station:   "Station,0,1,100.568,50.000,4,10k,0.50,100,6,0,0,0.00,0,0,0,0",
+			component: "EComponent,0,1,1,1,10.123,10.250,0.00,0.50,6,100,10k,R1 - R_0603,0",
+			stack:     "Station,0,1,1,100.568,50.000,4,10k,0.50,100,6,0,0,0.00,0,0,0,0",
+		},
+	}
+	for _, tt := range tests {
+		dpv, err := GenerateDPV(xf, "board.dpv", false, tt.precision)
+		if err != nil {
+			t.Fatalf("precision %d: GenerateDPV: %v", tt.precision, err)
+		}
+		if got := dpvRows(dpv, "Station")[0]; got != tt.station {
+			t.Errorf("precision %d: Station row %q, want %q", tt.precision, got, tt.station)
+		}
+		if got := dpvRows(dpv, "EComponent")[0]; got != tt.component {
+			t.Errorf("precision %d: EComponent row %q, want %q", tt.precision, got, tt.component)
+		}
+		if got := dpvRows(GenerateStack(xf, tt.precision), "Station")[0]; got != tt.stack {
+			t.Errorf("precision %d: STACK row %q, want %q", tt.precision, got, tt.stack)
+		}
+	}
+}
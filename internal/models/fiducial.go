@@ -0,0 +1,92 @@
+package models
+
+import (
+	"math"
+	"strings"
+)
+
+// CalibPoint is a PCB calibration point pre-populated from a fiducial
+type CalibPoint struct {
+	Note string  `json:"note"` // UL, LR or LL
+	Ref  string  `json:"ref"`  // Fiducial reference designator
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// DetectFiducials finds fiducials in the component table (Ref starting
+// with "FID" or value "FIDUCIAL", including DNP rows) and picks the
+// upper-left, lower-right and lower-left ones, in the order the machine's
+// CalibPoint table expects. Returns nil unless three distinct corners exist.
+func DetectFiducials(xf *XFile) []CalibPoint {
+	return calibCorners(fiducialComponents(xf))
+}
+
+// isFiducial reports whether a component is a fiducial mark
+func isFiducial(c XComponent) bool {
+	return strings.HasPrefix(strings.ToUpper(componentRef(c)), "FID") ||
+		strings.EqualFold(c.Explain, "FIDUCIAL")
+}
+
+// fiducialComponents returns copies of all fiducial components
+func fiducialComponents(xf *XFile) []XComponent {
+	var fids []XComponent
+	for _, c := range xf.Components {
+		if isFiducial(c) {
+			fids = append(fids, c)
+		}
+	}
+	return fids
+}
+
+// calibCorners picks UL (min X, max Y), LR (max X, min Y) and LL (min X,
+// min Y) from the fiducials by geometry
+func calibCorners(fids []XComponent) []CalibPoint {
+	if len(fids) < 3 {
+		return nil
+	}
+
+	ul, lr, ll := 0, 0, 0
+	for i, f := range fids {
+		if f.DeltX-f.DeltY < fids[ul].DeltX-fids[ul].DeltY {
+			ul = i
+		}
+		if f.DeltX-f.DeltY > fids[lr].DeltX-fids[lr].DeltY {
+			lr = i
+		}
+		if f.DeltX+f.DeltY < fids[ll].DeltX+fids[ll].DeltY {
+			ll = i
+		}
+	}
+	if ul == lr || ul == ll || lr == ll {
+		return nil
+	}
+
+	point := func(note string, f XComponent) CalibPoint {
+		return CalibPoint{Note: note, Ref: componentRef(f), X: f.DeltX, Y: f.DeltY}
+	}
+	return []CalibPoint{point("UL", fids[ul]), point("LR", fids[lr]), point("LL", fids[ll])}
+}
+
+// boundsCorners returns the UL, LR and LL corners of the components'
+// bounding box as calibration points, a starting reference for boards
+// without fiducials. Returns nil if there are no components.
+func boundsCorners(comps []XComponent) []CalibPoint {
+	if len(comps) == 0 {
+		return nil
+	}
+
+	minX, maxX := comps[0].DeltX, comps[0].DeltX
+	minY, maxY := comps[0].DeltY, comps[0].DeltY
+	for _, c := range comps[1:] {
+		minX = math.Min(minX, c.DeltX)
+		maxX = math.Max(maxX, c.DeltX)
+		minY = math.Min(minY, c.DeltY)
+		maxY = math.Max(maxY, c.DeltY)
+	}
+
+	return []CalibPoint{
+		{Note: "UL", X: minX, Y: maxY},
+		{Note: "LR", X: maxX, Y: minY},
+		{Note: "LL", X: minX, Y: minY},
+	}
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectFiducials(t *testing.T) {
+	xf := testBoard()
+	xf.Components = append(xf.Components,
+		XComponent{ID: 4, DeltX: 45, DeltY: 2, Note: "FID2 - Fiducial_1mm", DNP: true},
+		XComponent{ID: 5, DeltX: 2, DeltY: 38, Note: "FID1 - Fiducial_1mm", DNP: true},
+		XComponent{ID: 6, DeltX: 3, DeltY: 3, Explain: "FIDUCIAL", Note: "M3 - Fiducial_1mm", DNP: true},
+	)
+
+	want := []CalibPoint{
+		{Note: "UL", Ref: "FID1", X: 2, Y: 38},
+		{Note: "LR", Ref: "FID2", X: 45, Y: 2},
+		{Note: "LL", Ref: "M3", X: 3, Y: 3},
+	}
+	if got := DetectFiducials(xf); !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectFiducials = %+v, want %+v", got, want)
+	}
+
+	// With GlobalOffset applied in the CalibPoint and CalibFator tables
+	xf.GlobalOffset = GlobalOffset{X: 10, Y: 5}
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	wantRows := []string{
+		"CalibPoint,0,1,12.00,43.00,UL,0,0,0,0",
+		"CalibPoint,1,2,55.00,7.00,LR,0,0,0,0",
+		"CalibPoint,2,3,13.00,8.00,LL,0,0,0,0",
+	}
+	if got := dpvRows(dpv, "CalibPoint"); !reflect.DeepEqual(got, wantRows) {
+		t.Errorf("CalibPoint rows %q, want %q", got, wantRows)
+	}
+	if got := dpvRows(dpv, "CalibFator"); len(got) != 1 || got[0] != "CalibFator,0,1200,4300,5500,700,1300,800,0,0,0,0,0,0,0" {
+		t.Errorf("CalibFator rows %q", got)
+	}
+}
+
+func TestDetectFiducialsNeedsThreeCorners(t *testing.T) {
+	xf := NewXFile()
+	xf.Components = []XComponent{
+		{DeltX: 0, DeltY: 0, Note: "FID1 - Fiducial"},
+		{DeltX: 50, DeltY: 0, Note: "FID2 - Fiducial"},
+	}
+	if got := DetectFiducials(xf); got != nil {
+		t.Errorf("DetectFiducials with two fiducials = %+v, want nil", got)
+	}
+}
+
+func TestGenerateDPVCalibFromBounds(t *testing.T) {
+	// Components span (10, 10) to (20, 30); a DNP part outside is ignored
+	xf := testBoard()
+	xf.Components = append(xf.Components, XComponent{ID: 4, STNo: 1, DeltX: 90, DeltY: 90, Explain: "10k", Note: "R3 - R_0603", DNP: true})
+	xf.GlobalOffset = GlobalOffset{X: 5, Y: 5}
+
+	calibRows := func(calibFromBounds bool) []string {
+		t.Helper()
+		dpv, err := GenerateDPV(xf, "board.dpv", calibFromBounds, DefaultPrecision)
+		if err != nil {
+			t.Fatalf("GenerateDPV: %v", err)
+		}
+		return dpvRows(dpv, "CalibPoint")
+	}
+
+	zeros := []string{
+		"CalibPoint,0,1,0,0,,0,0,0,0",
+		"CalibPoint,1,2,0,0,,0,0,0,0",
+		"CalibPoint,2,3,0,0,,0,0,0,0",
+	}
+	if got := calibRows(false); !reflect.DeepEqual(got, zeros) {
+		t.Errorf("without calibFromBounds: %q, want %q", got, zeros)
+	}
+
+	// UL, LR and LL corners of the bounding box, offset applied
+	want := []string{
+		"CalibPoint,0,1,15.00,35.00,UL,0,0,0,0",
+		"CalibPoint,1,2,25.00,15.00,LR,0,0,0,0",
+		"CalibPoint,2,3,15.00,15.00,LL,0,0,0,0",
+	}
+	if got := calibRows(true); !reflect.DeepEqual(got, want) {
+		t.Errorf("with calibFromBounds: %q, want %q", got, want)
+	}
+
+	// Fiducials take precedence over the bounding box
+	xf.Components = append(xf.Components,
+		XComponent{ID: 5, DeltX: 2, DeltY: 38, Note: "FID1 - Fiducial_1mm", DNP: true},
+		XComponent{ID: 6, DeltX: 45, DeltY: 2, Note: "FID2 - Fiducial_1mm", DNP: true},
+		XComponent{ID: 7, DeltX: 3, DeltY: 3, Note: "FID3 - Fiducial_1mm", DNP: true},
+	)
+	if got := calibRows(true); len(got) != 3 || got[0] != "CalibPoint,0,1,7.00,43.00,UL,0,0,0,0" {
+		t.Errorf("with fiducials: %q, want the fiducial positions", got)
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExportInfo identifies one export package, so ZIPs of the same board can
+// be told apart
+type ExportInfo struct {
+	JobID    string    `json:"jobId"`    // Short random ID
+	Time     time.Time `json:"time"`     // When the package was generated
+	Filename string    `json:"filename"` // ZIP file name
+}
+
+// NewExportInfo creates the identity of a new export package, naming the
+// ZIP <baseName>_<YYYYMMDD-HHMMSS>_<job ID>.zip
+func NewExportInfo(baseName string) ExportInfo {
+	now := time.Now()
+	jobID := uuid.New().String()[:8]
+	return ExportInfo{
+		JobID:    jobID,
+		Time:     now,
+		Filename: fmt.Sprintf("%s_%s_%s.zip", baseName, now.Format("20060102-150405"), jobID),
+	}
+}
+
+// Manifest is a machine-readable summary of an export package
+type Manifest struct {
+	JobID        string       `json:"jobId"`
+	Generated    time.Time    `json:"generated"`
+	OriginalPOS  string       `json:"originalPOS"`
+	Components   int          `json:"components"` // Active (placed) components
+	Stations     int          `json:"stations"`   // Active stations
+	DNP          int          `json:"dnp"`        // Components marked Do Not Place
+	BoundingBox  BoundingBox  `json:"boundingBox"`
+	GlobalOffset GlobalOffset `json:"globalOffset"`
+}
+
+// BoundingBox is the extent of the active component positions (mm),
+// before GlobalOffset is applied
+type BoundingBox struct {
+	MinX float64 `json:"minX"`
+	MinY float64 `json:"minY"`
+	MaxX float64 `json:"maxX"`
+	MaxY float64 `json:"maxY"`
+}
+
+// GenerateManifest creates the manifest.json content for the export package
+func GenerateManifest(xf *XFile, job ExportInfo) (string, error) {
+	m := Manifest{
+		JobID:        job.JobID,
+		Generated:    job.Time,
+		OriginalPOS:  xf.OriginalPOS,
+		GlobalOffset: xf.GlobalOffset,
+	}
+
+	first := true
+	for _, c := range xf.Components {
+		if c.DNP {
+			m.DNP++
+			continue
+		}
+		m.Components++
+		if first {
+			m.BoundingBox = BoundingBox{MinX: c.DeltX, MinY: c.DeltY, MaxX: c.DeltX, MaxY: c.DeltY}
+			first = false
+			continue
+		}
+		m.BoundingBox.MinX = math.Min(m.BoundingBox.MinX, c.DeltX)
+		m.BoundingBox.MinY = math.Min(m.BoundingBox.MinY, c.DeltY)
+		m.BoundingBox.MaxX = math.Max(m.BoundingBox.MaxX, c.DeltX)
+		m.BoundingBox.MaxY = math.Max(m.BoundingBox.MaxY, c.DeltY)
+	}
+	for _, s := range xf.Stations {
+		if !s.DNP {
+			m.Stations++
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,99 @@
+package models
+
+import "fmt"
+
+// BuildPanel rebuilds the Panel_Array and Panel_Coord tables for an
+// equally spaced NumX x NumY step-and-repeat panel.
+// Boards are numbered 1 to N row major, left to right columns, bottom to
+// top rows; each board listed in skip gets its own Panel_Array skip row.
+func BuildPanel(xf *XFile, intervalX, intervalY float64, numX, numY int, skip []int) error {
+	if numX < 1 || numY < 1 {
+		return fmt.Errorf("NumX (%d) and NumY (%d) must be at least 1", numX, numY)
+	}
+	if numX > 1 && intervalX == 0 {
+		return fmt.Errorf("IntervalX must be nonzero when NumX is %d", numX)
+	}
+	if numY > 1 && intervalY == 0 {
+		return fmt.Errorf("IntervalY must be nonzero when NumY is %d", numY)
+	}
+
+	boards := numX * numY
+	for _, id := range skip {
+		if id < 1 || id > boards {
+			return fmt.Errorf("skipped board %d is out of range (1-%d)", id, boards)
+		}
+	}
+
+	// First row is the array specification, the rest are boards to skip
+	panelArray := []PanelArrayRow{
+		{No: 0, ID: 1, IntervalX: intervalX, IntervalY: intervalY, NumX: numX, NumY: numY},
+	}
+	seen := make(map[int]bool)
+	for _, id := range skip {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		panelArray = append(panelArray, PanelArrayRow{No: len(panelArray), ID: id})
+	}
+
+	xf.PanelArray = panelArray
+	xf.PanelCoord = panelCoords(intervalX, intervalY, numX, numY)
+	return nil
+}
+
+// panelCoords returns the Panel_Coord rows of an equally spaced panel: the
+// board origin offsets relative to board 1 (lower left of panel), row major
+func panelCoords(intervalX, intervalY float64, numX, numY int) []PanelCoordRow {
+	panelCoord := []PanelCoordRow{}
+	for row := 0; row < numY; row++ {
+		for col := 0; col < numX; col++ {
+			panelCoord = append(panelCoord, PanelCoordRow{
+				No:    len(panelCoord),
+				ID:    len(panelCoord) + 1,
+				DeltX: float64(col) * intervalX,
+				DeltY: float64(row) * intervalY,
+			})
+		}
+	}
+	return panelCoord
+}
+
+// FlattenPanel replaces the panel tables with a single 1x1 board by copying
+// every component once per Panel_Coord board, offset by that board's
+// origin. Boards marked as skipped in Panel_Array are left out. This is for
+// firmware that ignores the panel tables. Returns the number of boards.
+func FlattenPanel(xf *XFile) int {
+	skipped := make(map[int]bool)
+	if len(xf.PanelArray) > 1 {
+		for _, row := range xf.PanelArray[1:] {
+			skipped[row.ID] = true
+		}
+	}
+
+	boards := xf.PanelCoord
+	if len(boards) == 0 {
+		boards = []PanelCoordRow{{ID: 1}}
+	}
+
+	var flat []XComponent
+	count := 0
+	for _, board := range boards {
+		if skipped[board.ID] {
+			continue
+		}
+		count++
+		for _, c := range xf.Components {
+			c.DeltX += board.DeltX
+			c.DeltY += board.DeltY
+			c.No = len(flat)
+			c.ID = len(flat) + 1
+			flat = append(flat, c)
+		}
+	}
+
+	xf.Components = flat
+	xf.PanelArray = []PanelArrayRow{{No: 0, ID: 1, NumX: 1, NumY: 1}}
+	xf.PanelCoord = []PanelCoordRow{{No: 0, ID: 1}}
+	return count
+}
@@ -0,0 +1,102 @@
+package models
+
+import "testing"
+
+func TestBuildPanel2x3(t *testing.T) {
+	xf := NewXFile()
+	if err := BuildPanel(xf, 50, 40, 2, 3, []int{4}); err != nil {
+		t.Fatalf("BuildPanel: %v", err)
+	}
+
+	if len(xf.PanelArray) != 2 {
+		t.Fatalf("got %d Panel_Array rows, want the array row and one skip row", len(xf.PanelArray))
+	}
+	if a := xf.PanelArray[0]; a.IntervalX != 50 || a.IntervalY != 40 || a.NumX != 2 || a.NumY != 3 {
+		t.Errorf("array row %+v", a)
+	}
+	if skip := xf.PanelArray[1]; skip.No != 1 || skip.ID != 4 {
+		t.Errorf("skip row %+v, want No 1 ID 4", skip)
+	}
+
+	if len(xf.PanelCoord) != 6 {
+		t.Fatalf("got %d Panel_Coord rows, want 6", len(xf.PanelCoord))
+	}
+	want := [][2]float64{{0, 0}, {50, 0}, {0, 40}, {50, 40}, {0, 80}, {50, 80}}
+	for i, w := range want {
+		c := xf.PanelCoord[i]
+		if c.No != i || c.ID != i+1 || c.DeltX != w[0] || c.DeltY != w[1] {
+			t.Errorf("coord %d = %+v, want ID %d at (%v, %v)", i, c, i+1, w[0], w[1])
+		}
+	}
+}
+
+func TestBuildPanelRejectsBadArrays(t *testing.T) {
+	tests := []struct {
+		name       string
+		ix, iy     float64
+		numX, numY int
+		skip       []int
+	}{
+		{"zero IntervalX", 0, 40, 2, 3, nil},
+		{"zero IntervalY", 50, 0, 2, 3, nil},
+		{"zero NumX", 50, 40, 0, 3, nil},
+		{"skip out of range", 50, 40, 2, 3, []int{7}},
+	}
+	for _, tt := range tests {
+		if err := BuildPanel(NewXFile(), tt.ix, tt.iy, tt.numX, tt.numY, tt.skip); err == nil {
+			t.Errorf("%s: BuildPanel accepted it", tt.name)
+		}
+	}
+
+	// A single column needs no IntervalX
+	if err := BuildPanel(NewXFile(), 0, 40, 1, 3, nil); err != nil {
+		t.Errorf("1x3 panel without IntervalX: %v", err)
+	}
+}
+
+func TestGenerateDPVPanel2x3(t *testing.T) {
+	xf := testBoard()
+	if err := BuildPanel(xf, 50, 40, 2, 3, nil); err != nil {
+		t.Fatalf("BuildPanel: %v", err)
+	}
+	if result := ValidateDPV(xf, "board.dpv"); !result.Valid {
+		t.Fatalf("2x3 panel fails validation: %+v", result.Errors)
+	}
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	rows := dpvRows(dpv, "Panel_Array")
+	if len(rows) != 1 || rows[0] != "Panel_Array,0,1,50.00,40.00,2,3" {
+		t.Errorf("Panel_Array rows %q", rows)
+	}
+}
+
+func TestFlattenPanel(t *testing.T) {
+	xf := testBoard()
+	if err := BuildPanel(xf, 50, 40, 2, 2, []int{3}); err != nil {
+		t.Fatalf("BuildPanel: %v", err)
+	}
+	perBoard := len(xf.Components)
+
+	boards := FlattenPanel(xf)
+	if boards != 3 {
+		t.Errorf("flattened %d boards, want 3 (board 3 skipped)", boards)
+	}
+	if len(xf.Components) != perBoard*boards {
+		t.Fatalf("got %d components, want %d x %d", len(xf.Components), perBoard, boards)
+	}
+
+	// Board 4 (upper right) holds the last copy of R1
+	if c := xf.Components[2*perBoard]; c.DeltX != 60 || c.DeltY != 50 || componentRef(c) != "R1" {
+		t.Errorf("R1 on board 4 = %+v, want at (60, 50)", c)
+	}
+	for i, c := range xf.Components {
+		if c.No != i || c.ID != i+1 {
+			t.Errorf("component %d has No %d ID %d", i, c.No, c.ID)
+		}
+	}
+	if len(xf.PanelArray) != 1 || xf.PanelArray[0].NumX != 1 || xf.PanelArray[0].NumY != 1 || len(xf.PanelCoord) != 1 {
+		t.Errorf("panel tables not reset to 1x1: %+v %+v", xf.PanelArray, xf.PanelCoord)
+	}
+}
@@ -0,0 +1,537 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OptimizePlacementOrder reorders active components to reduce head travel.
+// Components are grouped by station (in Station table order) and each group
+// is visited nearest-neighbor first, continuing from where the previous group
+// ended. DNP components are kept at the end in their original order. If the
+// result is not shorter than the current order, the current order is kept.
+// No. and ID are renumbered sequentially either way.
+func OptimizePlacementOrder(xf *XFile) {
+	var active, dnp []XComponent
+	for _, c := range xf.Components {
+		if c.DNP {
+			dnp = append(dnp, c)
+		} else {
+			active = append(active, c)
+		}
+	}
+
+	// Group by station, ordered by the Station table
+	groups := make(map[int][]XComponent)
+	for _, c := range active {
+		groups[c.STNo] = append(groups[c.STNo], c)
+	}
+	var stationOrder []int
+	for _, s := range xf.Stations {
+		if _, ok := groups[s.ID]; ok {
+			stationOrder = append(stationOrder, s.ID)
+		}
+	}
+	// Components referencing unknown stations go last, in POS order
+	known := make(map[int]bool)
+	for _, id := range stationOrder {
+		known[id] = true
+	}
+	for _, c := range active {
+		if !known[c.STNo] {
+			known[c.STNo] = true
+			stationOrder = append(stationOrder, c.STNo)
+		}
+	}
+
+	optimized := make([]XComponent, 0, len(active))
+	x, y := 0.0, 0.0
+	for _, id := range stationOrder {
+		remaining := groups[id]
+		for len(remaining) > 0 {
+			best := 0
+			bestDist := math.Inf(1)
+			for i, c := range remaining {
+				if d := math.Hypot(c.DeltX-x, c.DeltY-y); d < bestDist {
+					best, bestDist = i, d
+				}
+			}
+			next := remaining[best]
+			optimized = append(optimized, next)
+			x, y = next.DeltX, next.DeltY
+			remaining = append(remaining[:best], remaining[best+1:]...)
+		}
+	}
+
+	if PlacementTravel(optimized) >= PlacementTravel(active) {
+		optimized = active
+	}
+
+	xf.Components = append(optimized, dnp...)
+	for i := range xf.Components {
+		xf.Components[i].No = i
+		xf.Components[i].ID = i + 1
+	}
+}
+
+// PlacementTravel returns the total Euclidean distance (mm) visited when
+// placing components in order, starting from the board origin
+func PlacementTravel(comps []XComponent) float64 {
+	total := 0.0
+	x, y := 0.0, 0.0
+	for _, c := range comps {
+		total += math.Hypot(c.DeltX-x, c.DeltY-y)
+		x, y = c.DeltX, c.DeltY
+	}
+	return total
+}
+
+// DefaultAutoOffsetMargin is the default clearance (mm) kept between the
+// board and the PCB area edge by AutoOffset
+const DefaultAutoOffsetMargin = 5.0
+
+// AutoOffset sets GlobalOffset so the bounding box of the active components
+// starts margin mm from the machine origin, keeping the board within the
+// 345x355mm PCB limits. The box is taken after BoardRotation, as the board
+// is exported. Returns an error if the margin is negative or the board does
+// not fit.
+func AutoOffset(xf *XFile, margin float64) error {
+	if margin < 0 || math.IsNaN(margin) {
+		return fmt.Errorf("margin %v cannot be negative", margin)
+	}
+
+	var active []XComponent
+	for _, c := range xf.Components {
+		if !c.DNP {
+			active = append(active, c)
+		}
+	}
+	if len(active) == 0 {
+		return fmt.Errorf("no active components to position")
+	}
+	rotated := append([]XComponent(nil), active...)
+	rotateBoard(rotated, active, xf.BoardRotation)
+
+	minX, minY := rotated[0].DeltX, rotated[0].DeltY
+	maxX, maxY := minX, minY
+	for _, c := range rotated {
+		minX = math.Min(minX, c.DeltX)
+		maxX = math.Max(maxX, c.DeltX)
+		minY = math.Min(minY, c.DeltY)
+		maxY = math.Max(maxY, c.DeltY)
+	}
+
+	width := maxX - minX
+	height := maxY - minY
+	if width+2*margin > maxPCBX || height+2*margin > maxPCBY {
+		return fmt.Errorf("board %.2fx%.2fmm with %.2fmm margin exceeds PCB max size %.0fx%.0fmm",
+			width, height, margin, maxPCBX, maxPCBY)
+	}
+
+	xf.GlobalOffset.X = margin - minX
+	xf.GlobalOffset.Y = margin - minY
+	return nil
+}
+
+// MirrorX mirrors component positions left-to-right within their bounding
+// box and adjusts each Angle to match. POSRows are left untouched.
+func MirrorX(xf *XFile) {
+	cx, _ := componentsCenter(xf.Components)
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		c.DeltX = 2*cx - c.DeltX
+		c.Angle = NormalizeAngle(180 - c.Angle)
+	}
+}
+
+// MirrorY mirrors component positions top-to-bottom within their bounding
+// box and adjusts each Angle to match. POSRows are left untouched.
+func MirrorY(xf *XFile) {
+	_, cy := componentsCenter(xf.Components)
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		c.DeltY = 2*cy - c.DeltY
+		c.Angle = NormalizeAngle(-c.Angle)
+	}
+}
+
+// SwapXY exchanges the X and Y position of every component, undoing a POS
+// export with swapped columns, and adjusts each Angle to match. POSRows are
+// left untouched.
+func SwapXY(xf *XFile) {
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		c.DeltX, c.DeltY = c.DeltY, c.DeltX
+		c.Angle = NormalizeAngle(90 - c.Angle)
+	}
+}
+
+// Nudge adds dx, dy to the position of every non-DNP component, station or
+// both (target "components", "stations" or "both"), e.g. after the board
+// fixture has shifted. POSRows are left untouched. Returns the number of
+// items moved.
+func Nudge(xf *XFile, target string, dx, dy float64) (int, error) {
+	var components, stations bool
+	switch target {
+	case "components":
+		components = true
+	case "stations":
+		stations = true
+	case "both":
+		components, stations = true, true
+	default:
+		return 0, fmt.Errorf("invalid target %q (must be \"components\", \"stations\" or \"both\")", target)
+	}
+
+	moved := 0
+	if components {
+		for i := range xf.Components {
+			if c := &xf.Components[i]; !c.DNP {
+				c.DeltX += dx
+				c.DeltY += dy
+				moved++
+			}
+		}
+	}
+	if stations {
+		for i := range xf.Stations {
+			if s := &xf.Stations[i]; !s.DNP {
+				s.DeltX += dx
+				s.DeltY += dy
+				moved++
+			}
+		}
+	}
+	return moved, nil
+}
+
+var (
+	angleOffsetsMu sync.RWMutex
+	angleOffsets   = map[string]float64{}
+)
+
+// PackageAngleOffsets returns a copy of the per-package angle corrections
+// (package fragment -> degrees) applied to components on POS conversion
+func PackageAngleOffsets() map[string]float64 {
+	angleOffsetsMu.RLock()
+	defer angleOffsetsMu.RUnlock()
+	offsets := make(map[string]float64, len(angleOffsets))
+	for pkg, deg := range angleOffsets {
+		offsets[pkg] = deg
+	}
+	return offsets
+}
+
+// SetPackageAngleOffsets replaces the per-package angle corrections. They
+// correct footprint libraries whose 0 degree orientation differs from the
+// machine's (e.g. diodes or polarized capacitors drawn the other way round).
+func SetPackageAngleOffsets(offsets map[string]float64) error {
+	copied := make(map[string]float64, len(offsets))
+	for pkg, deg := range offsets {
+		if strings.TrimSpace(pkg) == "" {
+			return fmt.Errorf("angle offset package must not be empty")
+		}
+		if math.IsNaN(deg) || math.IsInf(deg, 0) {
+			return fmt.Errorf("angle offset for %s must be a finite number", pkg)
+		}
+		copied[pkg] = deg
+	}
+	angleOffsetsMu.Lock()
+	defer angleOffsetsMu.Unlock()
+	angleOffsets = copied
+	return nil
+}
+
+// ApplyAngleOffsets adds the package angle correction (longest matching
+// fragment wins) to each component's Angle and normalizes the result.
+// Returns the number of components changed.
+func ApplyAngleOffsets(xf *XFile) int {
+	offsets := PackageAngleOffsets()
+	if len(offsets) == 0 {
+		return 0
+	}
+
+	changed := 0
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		if deg := matchPackageRule(componentPackage(*c), offsets, 0); deg != 0 {
+			c.Angle = NormalizeAngle(c.Angle + deg)
+			changed++
+		}
+	}
+	return changed
+}
+
+// Heatmap counts active component placements per cell of a Bins x Bins
+// grid laid over their bounding box, in machine coordinates (GlobalOffset
+// applied). Counts is indexed [row][column], row 0 at the minimum Y.
+type Heatmap struct {
+	Bins        int         `json:"bins"`
+	BoundingBox BoundingBox `json:"boundingBox"`
+	CellWidth   float64     `json:"cellWidth"`  // mm
+	CellHeight  float64     `json:"cellHeight"` // mm
+	Counts      [][]int     `json:"counts"`
+	Total       int         `json:"total"` // Active components counted
+}
+
+// BuildHeatmap buckets the active components into a bins x bins grid over
+// their bounding box. Components on the maximum edge fall in the last cell.
+func BuildHeatmap(xf *XFile, bins int) (*Heatmap, error) {
+	if bins < 1 {
+		return nil, fmt.Errorf("bins %d must be at least 1", bins)
+	}
+
+	hm := &Heatmap{Bins: bins, Counts: make([][]int, bins)}
+	for i := range hm.Counts {
+		hm.Counts[i] = make([]int, bins)
+	}
+
+	var xs, ys []float64
+	for _, c := range xf.Components {
+		if !c.DNP {
+			xs = append(xs, c.DeltX+xf.GlobalOffset.X)
+			ys = append(ys, c.DeltY+xf.GlobalOffset.Y)
+		}
+	}
+	if len(xs) == 0 {
+		return hm, nil
+	}
+
+	box := BoundingBox{MinX: xs[0], MinY: ys[0], MaxX: xs[0], MaxY: ys[0]}
+	for i := range xs {
+		box.MinX = math.Min(box.MinX, xs[i])
+		box.MaxX = math.Max(box.MaxX, xs[i])
+		box.MinY = math.Min(box.MinY, ys[i])
+		box.MaxY = math.Max(box.MaxY, ys[i])
+	}
+	hm.BoundingBox = box
+	hm.CellWidth = (box.MaxX - box.MinX) / float64(bins)
+	hm.CellHeight = (box.MaxY - box.MinY) / float64(bins)
+
+	cell := func(v, min, size float64) int {
+		if size == 0 {
+			return 0
+		}
+		return int(math.Min(math.Floor((v-min)/size), float64(bins-1)))
+	}
+	for i := range xs {
+		hm.Counts[cell(ys[i], box.MinY, hm.CellHeight)][cell(xs[i], box.MinX, hm.CellWidth)]++
+		hm.Total++
+	}
+	return hm, nil
+}
+
+// HeadSummary is the share of a job placed by one nozzle
+type HeadSummary struct {
+	PHead      int            `json:"phead"`
+	Placements int            `json:"placements"`
+	Travel     float64        `json:"travel"` // Estimated mm, feeder pick + placement per part
+	Sizes      map[string]int `json:"sizes"`  // Part size (package fragment) -> count, "other" if unknown
+}
+
+// SummarizeHeads splits the active components by PHead (1 and 2, plus any
+// other value in use). Travel is estimated by visiting each component's
+// station and then its placement (GlobalOffset applied) in table order,
+// starting from the machine origin. Part sizes are the DefaultVisionRules
+// package fragments.
+func SummarizeHeads(xf *XFile) []HeadSummary {
+	stationPos := make(map[int][2]float64)
+	for _, s := range xf.Stations {
+		stationPos[s.ID] = [2]float64{s.DeltX, s.DeltY}
+	}
+	sizes := make(map[string]string)
+	for fragment := range DefaultVisionRules {
+		sizes[fragment] = fragment
+	}
+
+	type headState struct {
+		summary HeadSummary
+		x, y    float64
+	}
+	heads := map[int]*headState{
+		1: {summary: HeadSummary{PHead: 1, Sizes: map[string]int{}}},
+		2: {summary: HeadSummary{PHead: 2, Sizes: map[string]int{}}},
+	}
+	for _, c := range xf.Components {
+		if c.DNP {
+			continue
+		}
+		h, ok := heads[c.PHead]
+		if !ok {
+			h = &headState{summary: HeadSummary{PHead: c.PHead, Sizes: map[string]int{}}}
+			heads[c.PHead] = h
+		}
+
+		h.summary.Placements++
+		if pos, ok := stationPos[c.STNo]; ok {
+			h.summary.Travel += math.Hypot(pos[0]-h.x, pos[1]-h.y)
+			h.x, h.y = pos[0], pos[1]
+		}
+		x, y := c.DeltX+xf.GlobalOffset.X, c.DeltY+xf.GlobalOffset.Y
+		h.summary.Travel += math.Hypot(x-h.x, y-h.y)
+		h.x, h.y = x, y
+
+		size, ok := lookupPackageRule(componentPackage(c), sizes)
+		if !ok {
+			size = "other"
+		}
+		h.summary.Sizes[size]++
+	}
+
+	summaries := make([]HeadSummary, 0, len(heads))
+	for _, h := range heads {
+		summaries = append(summaries, h.summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].PHead < summaries[j].PHead })
+	return summaries
+}
+
+// GenerateHeadSummary creates the heads.txt content for the export package,
+// showing how the placements are split between the nozzles
+func GenerateHeadSummary(xf *XFile) string {
+	var sb strings.Builder
+
+	summaries := SummarizeHeads(xf)
+	total := 0
+	for _, s := range summaries {
+		total += s.Placements
+	}
+
+	sb.WriteString("CharmTool Nozzle Summary\r\n")
+	sb.WriteString("========================\r\n")
+	sb.WriteString(fmt.Sprintf("Generated: %s\r\n", time.Now().Format("2006-01-02 15:04:05")))
+	sb.WriteString(fmt.Sprintf("Placements: %d\r\n", total))
+
+	for _, s := range summaries {
+		sb.WriteString("\r\n")
+		switch s.PHead {
+		case 1:
+			sb.WriteString("PHead 1 (left nozzle)\r\n")
+		case 2:
+			sb.WriteString("PHead 2 (right nozzle)\r\n")
+		default:
+			sb.WriteString(fmt.Sprintf("PHead %d (invalid)\r\n", s.PHead))
+		}
+
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(s.Placements) / float64(total)
+		}
+		sb.WriteString(fmt.Sprintf("  Placements: %d (%.0f%%)\r\n", s.Placements, share))
+		sb.WriteString(fmt.Sprintf("  Travel estimate: %.0f mm\r\n", s.Travel))
+
+		names := make([]string, 0, len(s.Sizes))
+		for name := range s.Sizes {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if s.Sizes[names[i]] != s.Sizes[names[j]] {
+				return s.Sizes[names[i]] > s.Sizes[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		mix := make([]string, len(names))
+		for i, name := range names {
+			mix[i] = fmt.Sprintf("%s x%d", name, s.Sizes[name])
+		}
+		if len(mix) == 0 {
+			mix = []string{"none"}
+		}
+		sb.WriteString(fmt.Sprintf("  Part sizes: %s\r\n", strings.Join(mix, ", ")))
+	}
+
+	return sb.String()
+}
+
+// partOutlines maps package name fragments to the body size (mm, width x
+// height at 0 degrees) drawn by GeneratePlacementSVG, longest match wins
+var partOutlines = map[string][2]float64{
+	"0201":    {0.6, 0.3},
+	"0402":    {1.0, 0.5},
+	"0603":    {1.6, 0.8},
+	"0805":    {2.0, 1.25},
+	"1206":    {3.2, 1.6},
+	"1210":    {3.2, 2.5},
+	"2512":    {6.3, 3.2},
+	"SOT-23":  {2.9, 1.3},
+	"SOD-123": {2.7, 1.6},
+	"SOT-223": {6.5, 3.5},
+	"SOIC":    {4.9, 3.9},
+	"TSSOP":   {5.0, 4.4},
+	"QFN":     {4.0, 4.0},
+	"QFP":     {10.0, 10.0},
+	"DPAK":    {6.5, 6.1},
+	"TO-252":  {6.5, 6.1},
+}
+
+// defaultPartOutline is drawn for packages without a partOutlines entry
+var defaultPartOutline = [2]float64{2.0, 1.0}
+
+// svgMargin is the space (mm) drawn around the components
+const svgMargin = 5.0
+
+// GeneratePlacementSVG draws the components over their bounding box as an
+// SVG image, one group per component with a tick showing its rotation.
+// Groups have the class "top", "bottom" or "dnp". Board coordinates are
+// used (without GlobalOffset or BoardRotation), with Y pointing up.
+func GeneratePlacementSVG(xf *XFile) string {
+	var sb strings.Builder
+
+	minX, minY, maxX, maxY := 0.0, 0.0, 0.0, 0.0
+	for i, c := range xf.Components {
+		if i == 0 {
+			minX, maxX, minY, maxY = c.DeltX, c.DeltX, c.DeltY, c.DeltY
+			continue
+		}
+		minX = math.Min(minX, c.DeltX)
+		maxX = math.Max(maxX, c.DeltX)
+		minY = math.Min(minY, c.DeltY)
+		maxY = math.Max(maxY, c.DeltY)
+	}
+
+	// SVG Y grows downwards, so positions are drawn at -Y
+	width := maxX - minX + 2*svgMargin
+	height := maxY - minY + 2*svgMargin
+	sb.WriteString(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="%.2f %.2f %.2f %.2f" width="%.0fmm" height="%.0fmm">`+"\n",
+		minX-svgMargin, -maxY-svgMargin, width, height, width, height))
+	sb.WriteString("<style>" +
+		".board{fill:#f4f1e8;stroke:#888;stroke-width:0.2}" +
+		".top rect{fill:#4a90d9}" +
+		".bottom rect{fill:#d98c4a}" +
+		".dnp rect{fill:none;stroke:#999;stroke-width:0.15;stroke-dasharray:0.4}" +
+		"line{stroke:#222;stroke-width:0.15}" +
+		"</style>\n")
+	sb.WriteString(fmt.Sprintf(`<rect class="board" x="%.2f" y="%.2f" width="%.2f" height="%.2f"/>`+"\n",
+		minX-svgMargin/2, -maxY-svgMargin/2, maxX-minX+svgMargin, maxY-minY+svgMargin))
+
+	for _, c := range xf.Components {
+		class := "top"
+		if c.DNP {
+			class = "dnp"
+		} else if c.Side == "bottom" {
+			class = "bottom"
+		}
+
+		size, ok := lookupPackageRule(componentPackage(c), partOutlines)
+		if !ok {
+			size = defaultPartOutline
+		}
+		w, h := size[0], size[1]
+
+		// Counter-clockwise board rotation is clockwise with Y flipped
+		sb.WriteString(fmt.Sprintf(`<g class="%s" transform="translate(%.2f %.2f) rotate(%.2f)">`,
+			class, c.DeltX, -c.DeltY, NormalizeAngle(-c.Angle)))
+		sb.WriteString(fmt.Sprintf("<title>%s</title>", html.EscapeString(c.Note)))
+		sb.WriteString(fmt.Sprintf(`<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f"/>`, -w/2, -h/2, w, h))
+		sb.WriteString(fmt.Sprintf(`<line x1="0" y1="0" x2="%.2f" y2="0"/>`, w/2))
+		sb.WriteString("</g>\n")
+	}
+
+	sb.WriteString("</svg>\n")
+	return sb.String()
+}
@@ -0,0 +1,325 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptimizePlacementOrderReducesTravel(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{{ID: 1, Note: "10k"}, {ID: 2, Note: "100nF"}}
+	// Zig-zag across the board within each station
+	points := [][3]float64{
+		{1, 90, 0}, {1, 0, 0}, {1, 80, 10}, {1, 10, 10}, {1, 70, 20},
+		{2, 5, 50}, {2, 95, 50}, {2, 15, 60}, {2, 85, 60},
+	}
+	for i, p := range points {
+		xf.Components = append(xf.Components, XComponent{No: i, ID: i + 1, STNo: int(p[0]), DeltX: p[1], DeltY: p[2]})
+	}
+	xf.Components = append(xf.Components, XComponent{No: 9, ID: 10, STNo: 1, DeltX: 500, DeltY: 500, DNP: true, Note: "R99"})
+
+	naive := PlacementTravel(xf.Components[:9])
+	OptimizePlacementOrder(xf)
+
+	optimized := PlacementTravel(xf.Components[:9])
+	if optimized > naive {
+		t.Errorf("travel %.1fmm after optimizing, %.1fmm before", optimized, naive)
+	}
+	if optimized >= naive {
+		t.Errorf("travel not reduced for a zig-zag fixture (%.1fmm)", naive)
+	}
+
+	for i, c := range xf.Components {
+		if c.No != i || c.ID != i+1 {
+			t.Errorf("component %d numbered No %d ID %d", i, c.No, c.ID)
+		}
+	}
+	if last := xf.Components[9]; !last.DNP || last.Note != "R99" {
+		t.Errorf("DNP component not kept last: %+v", last)
+	}
+	// Components stay grouped by station, in Station table order
+	for i, c := range xf.Components[:9] {
+		if want := 1 + i/5; c.STNo != want {
+			t.Errorf("position %d has STNo %d, want %d", i, c.STNo, want)
+		}
+	}
+}
+
+func TestOptimizePlacementOrderKeepsShorterOrder(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{{ID: 1}}
+	for i, x := range []float64{1, 2, 3, 4} {
+		xf.Components = append(xf.Components, XComponent{ID: i + 1, STNo: 1, DeltX: x})
+	}
+	before := PlacementTravel(xf.Components)
+	OptimizePlacementOrder(xf)
+	if after := PlacementTravel(xf.Components); after != before {
+		t.Errorf("travel changed from %v to %v for an already optimal order", before, after)
+	}
+}
+
+func TestAutoOffset(t *testing.T) {
+	xf := NewXFile()
+	xf.Components = []XComponent{
+		{DeltX: -120, DeltY: 300},
+		{DeltX: 80, DeltY: 450},
+		{DeltX: 500, DeltY: 500, DNP: true}, // ignored
+	}
+	if err := AutoOffset(xf, 5); err != nil {
+		t.Fatalf("AutoOffset: %v", err)
+	}
+	if xf.GlobalOffset.X != 125 || xf.GlobalOffset.Y != -295 {
+		t.Errorf("GlobalOffset = %+v, want {125 -295}", xf.GlobalOffset)
+	}
+	for _, c := range xf.Components[:2] {
+		x, y := c.DeltX+xf.GlobalOffset.X, c.DeltY+xf.GlobalOffset.Y
+		if x < 5 || y < 5 || x > maxPCBX-5 || y > maxPCBY-5 {
+			t.Errorf("component at (%v, %v) outside the PCB area with margin", x, y)
+		}
+	}
+}
+
+func TestAutoOffsetBoardTooLarge(t *testing.T) {
+	xf := NewXFile()
+	xf.Components = []XComponent{{DeltX: 0}, {DeltX: maxPCBX}}
+	if err := AutoOffset(xf, 5); err == nil {
+		t.Error("AutoOffset placed a board wider than the PCB area")
+	}
+	if err := AutoOffset(NewXFile(), 5); err == nil {
+		t.Error("AutoOffset succeeded without components")
+	}
+}
+
+func TestAutoOffsetNegativeMargin(t *testing.T) {
+	xf := testBoard()
+	if err := AutoOffset(xf, -1); err == nil {
+		t.Error("AutoOffset accepted a negative margin")
+	}
+	if xf.GlobalOffset != (GlobalOffset{}) {
+		t.Errorf("rejected margin changed GlobalOffset to %+v", xf.GlobalOffset)
+	}
+}
+
+func TestAutoOffsetRotatedBoard(t *testing.T) {
+	// Turned 90 degrees about (55, 10), the 100x10mm board spans X 50..60
+	// and Y -40..60 on the machine
+	xf := testBoard()
+	xf.Components = xf.Components[:2]
+	xf.Components[0].DeltX, xf.Components[0].DeltY = 5, 5
+	xf.Components[1].DeltX, xf.Components[1].DeltY = 105, 15
+	xf.BoardRotation = 90
+
+	if err := AutoOffset(xf, 5); err != nil {
+		t.Fatalf("AutoOffset: %v", err)
+	}
+	if xf.GlobalOffset != (GlobalOffset{X: -45, Y: 45}) {
+		t.Errorf("GlobalOffset = %+v, want {-45 45}", xf.GlobalOffset)
+	}
+	res := ValidateDPV(xf, "board.dpv")
+	for _, typ := range []string{"negative_coordinates", "xy_travel_exceeded", "pcb_size_x", "pcb_size_y"} {
+		if w := findIssue(res.Warnings, typ); w != nil {
+			t.Errorf("offset board warned: %s", w.Message)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	board := func() *XFile {
+		xf := NewXFile()
+		xf.Components = []XComponent{
+			{DeltX: 10, DeltY: 10, Angle: 0},
+			{DeltX: 30, DeltY: 10, Angle: 90},
+			{DeltX: 20, DeltY: 40, Angle: 45},
+		}
+		xf.POSRows = []POSRow{{Ref: "R1", PosX: 10, PosY: 10}}
+		return xf
+	}
+	type place struct{ x, y, angle float64 }
+	tests := []struct {
+		name   string
+		mirror func(*XFile)
+		want   []place
+	}{
+		// Bounding box 10..30 x 10..40, center (20, 25)
+		{"x", MirrorX, []place{{30, 10, 180}, {10, 10, 90}, {20, 40, 135}}},
+		{"y", MirrorY, []place{{10, 40, 0}, {30, 40, -90}, {20, 10, -45}}},
+	}
+	for _, tt := range tests {
+		xf := board()
+		tt.mirror(xf)
+		for i, w := range tt.want {
+			if c := xf.Components[i]; !approx(c.DeltX, w.x) || !approx(c.DeltY, w.y) || !approx(c.Angle, w.angle) {
+				t.Errorf("mirror %s: component %d at (%v, %v) angle %v, want (%v, %v) angle %v",
+					tt.name, i, c.DeltX, c.DeltY, c.Angle, w.x, w.y, w.angle)
+			}
+		}
+		if row := xf.POSRows[0]; row.PosX != 10 || row.PosY != 10 {
+			t.Errorf("mirror %s changed POS row to (%v, %v)", tt.name, row.PosX, row.PosY)
+		}
+
+		// Mirroring twice restores the board
+		tt.mirror(xf)
+		for i, c := range xf.Components {
+			if orig := board().Components[i]; !approx(c.DeltX, orig.DeltX) || !approx(c.DeltY, orig.DeltY) || !approx(c.Angle, orig.Angle) {
+				t.Errorf("mirror %s twice: component %d = %+v, want %+v", tt.name, i, c, orig)
+			}
+		}
+	}
+}
+
+func TestSwapXY(t *testing.T) {
+	// A 350mm long board fits the 345x355mm PCB area along Y only; its POS
+	// export has the X and Y columns swapped
+	xf := testBoard()
+	xf.Components[0].DeltX, xf.Components[0].DeltY = 348, 10
+	xf.Components[1].DeltX, xf.Components[1].DeltY = 350, 20
+	xf.Components[1].Angle = 30
+
+	warn := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "xy_possibly_swapped")
+	if warn == nil || !strings.Contains(warn.Message, "2 components are outside") || !strings.Contains(warn.Message, "0 would be") {
+		t.Fatalf("xy_possibly_swapped warning = %+v, want 2 outside and 0 swapped", warn)
+	}
+
+	SwapXY(xf)
+	type place struct{ x, y, angle float64 }
+	want := []place{{10, 348, 90}, {20, 350, 60}, {30, 15, 0}}
+	for i, w := range want {
+		if c := xf.Components[i]; !approx(c.DeltX, w.x) || !approx(c.DeltY, w.y) || !approx(c.Angle, w.angle) {
+			t.Errorf("component %d at (%v, %v) angle %v, want (%v, %v) angle %v", i, c.DeltX, c.DeltY, c.Angle, w.x, w.y, w.angle)
+		}
+	}
+	if w := findIssue(ValidateDPV(xf, "board.dpv").Warnings, "xy_possibly_swapped"); w != nil {
+		t.Errorf("still warned after SwapXY: %s", w.Message)
+	}
+
+	if w := findIssue(ValidateDPV(testBoard(), "board.dpv").Warnings, "xy_possibly_swapped"); w != nil {
+		t.Errorf("board inside the PCB area warned: %s", w.Message)
+	}
+}
+
+func TestNudge(t *testing.T) {
+	board := func() *XFile {
+		xf := testBoard()
+		xf.Components[1].DNP = true
+		xf.Stations = append(xf.Stations, XStation{No: 2, ID: 3, DeltX: 140, DeltY: 50, Note: "spare", DNP: true})
+		xf.POSRows = []POSRow{{Ref: "R1", PosX: 10, PosY: 10}}
+		return xf
+	}
+	tests := []struct {
+		target               string
+		moved                int
+		components, stations bool
+	}{
+		{"components", 2, true, false},
+		{"stations", 2, false, true},
+		{"both", 4, true, true},
+	}
+	for _, tt := range tests {
+		xf, orig := board(), board()
+		moved, err := Nudge(xf, tt.target, 1.5, -2)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		if moved != tt.moved {
+			t.Errorf("%s: moved %d items, want %d", tt.target, moved, tt.moved)
+		}
+		for i, c := range xf.Components {
+			o := orig.Components[i]
+			dx, dy := 0.0, 0.0
+			if tt.components && !c.DNP {
+				dx, dy = 1.5, -2
+			}
+			if !approx(c.DeltX, o.DeltX+dx) || !approx(c.DeltY, o.DeltY+dy) {
+				t.Errorf("%s: %s at (%v, %v), want (%v, %v)", tt.target, c.Note, c.DeltX, c.DeltY, o.DeltX+dx, o.DeltY+dy)
+			}
+		}
+		for i, s := range xf.Stations {
+			o := orig.Stations[i]
+			dx, dy := 0.0, 0.0
+			if tt.stations && !s.DNP {
+				dx, dy = 1.5, -2
+			}
+			if !approx(s.DeltX, o.DeltX+dx) || !approx(s.DeltY, o.DeltY+dy) {
+				t.Errorf("%s: station %d at (%v, %v), want (%v, %v)", tt.target, s.ID, s.DeltX, s.DeltY, o.DeltX+dx, o.DeltY+dy)
+			}
+		}
+		if row := xf.POSRows[0]; row.PosX != 10 || row.PosY != 10 {
+			t.Errorf("%s: changed POS row to (%v, %v)", tt.target, row.PosX, row.PosY)
+		}
+	}
+
+	if _, err := Nudge(board(), "feeders", 1, 1); err == nil {
+		t.Error("Nudge accepted target \"feeders\"")
+	}
+}
+
+func TestApplyAngleOffsets(t *testing.T) {
+	prev := PackageAngleOffsets()
+	if err := SetPackageAngleOffsets(map[string]float64{"SOD": 180, "SOD-123": 90}); err != nil {
+		t.Fatalf("SetPackageAngleOffsets: %v", err)
+	}
+	t.Cleanup(func() { SetPackageAngleOffsets(prev) })
+
+	pos := &POSData{Rows: []POSRow{
+		{Ref: "D1", Val: "1N4148", Package: "D_SOD-123", PosX: 10, PosY: 10, Rot: 0, Side: "top"},
+		{Ref: "D2", Val: "1N4148", Package: "D_SOD-123", PosX: 20, PosY: 10, Rot: 135, Side: "top"},
+		{Ref: "D3", Val: "BAT54", Package: "D_SOD-323", PosX: 30, PosY: 10, Rot: 90, Side: "top"},
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 40, PosY: 10, Rot: 90, Side: "top"},
+	}}
+	xf := ConvertPOSToXFile(pos, "board.pos")
+
+	// SOD-123 gets its own +90, normalized into (-180, 180]; other SOD
+	// packages the shorter fragment's 180; everything else is unchanged
+	want := []float64{90, -135, -90, 90}
+	for i, c := range xf.Components {
+		if c.Angle != want[i] {
+			t.Errorf("%s angle %v, want %v", componentRef(c), c.Angle, want[i])
+		}
+	}
+	// The original POS rotation is kept
+	if xf.POSRows[0].Rot != 0 {
+		t.Errorf("POS row rotation changed to %v", xf.POSRows[0].Rot)
+	}
+
+	if err := SetPackageAngleOffsets(map[string]float64{" ": 90}); err == nil {
+		t.Error("SetPackageAngleOffsets accepted an empty package")
+	}
+}
+
+func TestSummarizeHeads(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{ID: 1, DeltX: 0, DeltY: 10, Note: "10k"},
+		{ID: 2, DeltX: 30, DeltY: 40, Note: "LM358"},
+	}
+	xf.Components = []XComponent{
+		{PHead: 1, STNo: 1, DeltX: 0, DeltY: 20, Note: "R1 - R_0603"},
+		{PHead: 1, STNo: 1, DeltX: 0, DeltY: 30, Note: "R2 - R_0603"},
+		{PHead: 2, STNo: 2, DeltX: 30, DeltY: 0, Note: "U1 - SOIC-8"},
+		{PHead: 2, STNo: 2, DeltX: 90, DeltY: 90, Note: "U2 - SOIC-8", DNP: true},
+		{PHead: 1, STNo: 3, DeltX: 0, DeltY: 40, Note: "J1 - PinHeader_1x04"},
+	}
+
+	got := SummarizeHeads(xf)
+	// Travel: origin -> feeder -> placement for each part in table order;
+	// J1's station does not exist, so only its placement move counts
+	want := []HeadSummary{
+		{PHead: 1, Placements: 3, Travel: 10 + 10 + 10 + 20 + 10, Sizes: map[string]int{"0603": 2, "other": 1}},
+		{PHead: 2, Placements: 1, Travel: 50 + 40, Sizes: map[string]int{"SOIC": 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SummarizeHeads = %+v, want %+v", got, want)
+	}
+
+	report := GenerateHeadSummary(xf)
+	for _, line := range []string{
+		"Placements: 4\r\n",
+		"PHead 1 (left nozzle)\r\n  Placements: 3 (75%)\r\n  Travel estimate: 60 mm\r\n",
+		"PHead 2 (right nozzle)\r\n  Placements: 1 (25%)\r\n  Travel estimate: 90 mm\r\n",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("heads.txt missing %q:\n%s", line, report)
+		}
+	}
+}
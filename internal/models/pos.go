@@ -4,214 +4,523 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 // POSData holds parsed POS file data (internal parsing structure)
+// Row positions keep the sign used in the file. Normally Y grows upwards
+// from the board origin, as on the machine; some KiCad versions write
+// board coordinates with Y growing downwards, so every part has a negative
+// Y. InvertY marks such files (see DetectInvertedY) and makes
+// ConvertPOSToXFile negate PosY.
 type POSData struct {
-	Headers []string `json:"headers"`
-	Rows    []POSRow `json:"rows"`
+	Headers  []string `json:"headers"`
+	Rows     []POSRow `json:"rows"`
+	Units    string   `json:"units"`    // Units of the source file; Rows are always in mm
+	Comments []string `json:"comments"` // Leading "#" comment lines before the header
+	InvertY  bool     `json:"invertY"`  // Y grows downwards; negated on conversion
 }
 
-// ParsePOS parses a KiCad POS file and returns structured data
-// Supports both whitespace-delimited format (with # header) and CSV format
-func ParsePOS(r io.Reader) (*POSData, error) {
-	content, err := io.ReadAll(r)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
-	}
+// Supported POS coordinate units
+const (
+	UnitsMM   = "mm"
+	UnitsMil  = "mil"
+	UnitsInch = "in"
+)
 
-	text := string(content)
+// unitScale holds the millimeter conversion factor for each unit
+var unitScale = map[string]float64{
+	UnitsMM:   1,
+	UnitsMil:  0.0254,
+	UnitsInch: 25.4,
+}
 
-	// Check if this is CSV format (contains commas in data lines)
-	if strings.Contains(text, ",") && !strings.HasPrefix(strings.TrimSpace(text), "#") {
-		return parseCSVFormat(text)
-	}
+// maxPOSLineLength is the longest line (bytes) ParsePOS accepts
+const maxPOSLineLength = 1 << 20
 
-	// Parse KiCad whitespace-delimited format
-	return parseKiCadFormat(text)
-}
+// posRowSplitter splits one data line of a POS file into fields; lines
+// that hold no component (blank, comments) yield nil
+type posRowSplitter func(line string) []string
+
+// ParsePOS parses a KiCad POS file and returns structured data
+// Supports whitespace-delimited format (with # header), CSV format,
+// Altium Pick Place reports and Eagle mount files.
+// The file is read line by line and only the parsed rows are kept, so
+// large panel files never have to be held in memory as a whole.
+func ParsePOS(r io.Reader) (*POSData, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxPOSLineLength)
 
-// parseKiCadFormat parses the KiCad POS format with # header and whitespace delimiters
-func parseKiCadFormat(text string) (*POSData, error) {
-	// Remove BOM if present
-	text = strings.TrimPrefix(text, "\xef\xbb\xbf")
+	var (
+		data      *POSData       // Set once the header row is found
+		colMap    map[string]int // Columns of the header row
+		splitRow  posRowSplitter // Splits the data rows that follow it
+		comments  []string       // "#" lines before the header
+		units     unitsDetector
+		firstLine = true
+		sniffed   bool // hashFirst and csvLikely are known
+		hashFirst bool // The file starts with a "#" comment (KiCad)
+		csvLikely bool // The first non-comment line has , or ; in it
+		delimited bool // Any non-comment line before the header has , or ;
+	)
 
-	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if firstLine {
+			// Remove BOM if present (EasyEDA/JLCPCB exports include one)
+			line = strings.TrimPrefix(line, "\xef\xbb\xbf")
+			firstLine = false
+		}
 
-	var headerLine string
-	var headerLineIdx int = -1
-	var dataLines []string
+		if data != nil {
+			fields := splitRow(line)
+			if fields == nil {
+				units.observe(line)
+				continue
+			}
+			units.observeRow(fields, colMap)
+			if row := parseRowFields(fields, colMap); row.Ref != "" {
+				data.Rows = append(data.Rows, row)
+			}
+			continue
+		}
+		units.observe(line)
 
-	// First pass: find the header line (# line containing "Ref")
-	for i, line := range lines {
 		trimmed := strings.TrimSpace(line)
 		if trimmed == "" {
 			continue
 		}
 
-		// Look for header line: starts with # and contains "Ref"
-		if strings.HasPrefix(trimmed, "#") {
-			content := strings.TrimPrefix(trimmed, "#")
-			content = strings.TrimSpace(content)
-			// Check if this line contains "Ref" (case insensitive)
-			if strings.Contains(strings.ToLower(content), "ref") {
-				headerLine = content
-				headerLineIdx = i
-				break
+		// Altium and Eagle headers are recognized by their column names
+		// wherever they appear; anything before them is preamble
+		var err error
+		lower := strings.ToLower(line)
+		switch {
+		case strings.Contains(lower, "designator") && strings.Contains(lower, "center-x"):
+			data, colMap, splitRow, err = altiumHeader(line)
+		case strings.Contains(lower, "element") && strings.Contains(lower, "coord-x"):
+			data, colMap, splitRow = eagleHeader(trimmed)
+		case strings.HasPrefix(trimmed, "#"):
+			if !sniffed {
+				hashFirst, sniffed = true, true
+			}
+			// A "#" line is the KiCad header unless the file looks like CSV
+			content := strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+			if (hashFirst || !csvLikely) && isKiCadHeader(content) {
+				data, colMap, splitRow, err = kicadHeader(content, comments)
+			} else {
+				comments = append(comments, trimmed)
+			}
+		default:
+			hasDelim := strings.ContainsAny(trimmed, ",;")
+			if !sniffed {
+				csvLikely, sniffed = hasDelim, true
 			}
+			delimited = delimited || hasDelim
+			if !hashFirst && hasDelim {
+				data, colMap, splitRow = csvHeader(trimmed)
+			}
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
 
-	// If no # header with Ref found, try first # line
-	if headerLine == "" {
-		for i, line := range lines {
-			trimmed := strings.TrimSpace(line)
-			if strings.HasPrefix(trimmed, "#") {
-				headerLine = strings.TrimPrefix(trimmed, "#")
-				headerLine = strings.TrimSpace(headerLine)
-				headerLineIdx = i
-				break
-			}
+	if data == nil {
+		if !hashFirst && delimited {
+			return nil, fmt.Errorf("could not find POS header row (need Ref and Val or PosX columns)")
+		}
+		if len(comments) > 0 {
+			// Report what the first comment line lacks as a KiCad header
+			_, _, _, err := kicadHeader(strings.TrimSpace(strings.TrimLeft(comments[0], "#")), nil)
+			return nil, err
 		}
+		return nil, fmt.Errorf("could not find KiCad POS header row (need # Ref Val ... line)")
 	}
 
-	if headerLine == "" {
-		return nil, fmt.Errorf("could not find KiCad POS header row (need # Ref Val ... line)")
+	// Convert coordinates to millimeters
+	data.Units = UnitsMM
+	if err := data.ForceUnits(units.result(data.Headers)); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ForceUnits overrides the detected units of the source file and rescales
+// all row coordinates to millimeters accordingly
+func (p *POSData) ForceUnits(units string) error {
+	to, ok := unitScale[units]
+	if !ok {
+		return fmt.Errorf("unsupported units %q (use mm, mil or in)", units)
+	}
+	from, ok := unitScale[p.Units]
+	if !ok {
+		from = 1
+	}
+
+	factor := to / from
+	for i := range p.Rows {
+		p.Rows[i].PosX *= factor
+		p.Rows[i].PosY *= factor
 	}
+	p.Units = units
+	return nil
+}
+
+// unitsPattern matches a coordinate value with a unit suffix, e.g. "120.5mil"
+var unitsPattern = regexp.MustCompile(`^[-+]?[\d.,]+\s*(mm|mils?|in)$`)
+
+// unitsDetector determines the coordinate units of a POS file from a units
+// comment line (KiCad "## Unit = in", Altium "Units used: mil"), unit
+// annotated headers ("Center-X(mil)") or suffixed PosX/PosY values, in that
+// order of preference. Lines other than data rows are fed to observe, the
+// fields of data rows to observeRow.
+type unitsDetector struct {
+	fromLine   string // Units named by the first units comment line
+	fromSuffix string // Units of the first suffixed coordinate
+}
 
-	// Second pass: collect data lines (after header, non-comment lines)
-	for i, line := range lines {
-		if i <= headerLineIdx {
+// observeRow records the units suffix of a data row's PosX/PosY values, if
+// any. Other columns are ignored: a package like "DIP-8_300mil" says
+// nothing about the coordinates.
+func (d *unitsDetector) observeRow(fields []string, colMap map[string]int) {
+	if d.fromSuffix != "" {
+		return
+	}
+	for _, key := range []string{"posx", "posy"} {
+		idx, ok := colMap[key]
+		if !ok || idx >= len(fields) {
 			continue
 		}
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
+		if m := unitsPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(fields[idx]))); m != nil {
+			d.fromSuffix = unitsFromWord(m[1])
+			return
+		}
+	}
+}
+
+// observe records the units a units comment line declares, if any
+func (d *unitsDetector) observe(line string) {
+	if d.fromLine != "" {
+		return
+	}
+
+	lower := strings.TrimSpace(strings.TrimLeft(strings.ToLower(strings.TrimSpace(line)), "#"))
+	if !strings.HasPrefix(lower, "unit") {
+		return
+	}
+	if _, value, ok := strings.Cut(lower, "="); ok {
+		lower = value
+	} else if _, value, ok := strings.Cut(lower, ":"); ok {
+		lower = value
+	}
+	d.fromLine = unitsFromWord(strings.TrimSpace(strings.Split(lower, ",")[0]))
+}
+
+// result returns the detected units given the file's column headers,
+// defaulting to mm
+func (d *unitsDetector) result(headers []string) string {
+	if d.fromLine != "" {
+		return d.fromLine
+	}
+
+	for _, h := range headers {
+		lower := strings.ToLower(h)
+		if strings.Contains(lower, "(mil)") {
+			return UnitsMil
 		}
-		dataLines = append(dataLines, trimmed)
+		if strings.Contains(lower, "(in)") || strings.Contains(lower, "(inch)") {
+			return UnitsInch
+		}
+	}
+
+	if d.fromSuffix != "" {
+		return d.fromSuffix
+	}
+
+	return UnitsMM
+}
+
+// unitsFromWord maps a unit name to a supported units constant
+func unitsFromWord(word string) string {
+	word = strings.TrimSuffix(strings.TrimSpace(word), ".")
+	switch word {
+	case "mm", "millimeter", "millimeters", "millimetre", "millimetres":
+		return UnitsMM
+	case "mil", "mils", "thou":
+		return UnitsMil
+	case "in", "inch", "inches":
+		return UnitsInch
 	}
+	return ""
+}
 
+// isKiCadHeader reports whether the content of a "#" line is a KiCad
+// column header row
+func isKiCadHeader(content string) bool {
+	if strings.Contains(strings.ToLower(content), "ref") {
+		return true
+	}
+	_, hasRef := buildColumnMap(splitByWhitespace(content))["ref"]
+	return hasRef
+}
+
+// kicadHeader sets up parsing of the KiCad POS format from the content of
+// its "# Ref Val ..." header line; rows are whitespace delimited
+func kicadHeader(content string, comments []string) (*POSData, map[string]int, posRowSplitter, error) {
 	// Parse header - split by whitespace
-	headers := splitByWhitespace(headerLine)
+	headers := splitByWhitespace(content)
 	if len(headers) == 0 {
-		return nil, fmt.Errorf("empty header row")
+		return nil, nil, nil, fmt.Errorf("empty header row")
 	}
 
 	// Build column map
 	colMap := buildColumnMap(headers)
 
 	if _, hasRef := colMap["ref"]; !hasRef {
-		return nil, fmt.Errorf("header missing Ref column (found headers: %v)", headers)
+		return nil, nil, nil, fmt.Errorf("header missing Ref column (found headers: %v)", headers)
 	}
 	if _, hasVal := colMap["val"]; !hasVal {
-		return nil, fmt.Errorf("header missing Val column (found headers: %v)", headers)
+		return nil, nil, nil, fmt.Errorf("header missing Val column (found headers: %v)", headers)
 	}
 
+	// Keep the leading comment block (e.g. "## Unit = mm, Angle = deg.")
 	data := &POSData{
-		Headers: headers,
-		Rows:    []POSRow{},
+		Headers:  headers,
+		Rows:     []POSRow{},
+		Comments: comments,
 	}
 
-	// Parse data rows
-	for _, line := range dataLines {
-		fields := splitByWhitespace(line)
-		if len(fields) == 0 {
-			continue
+	splitRow := func(line string) []string {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			return nil
 		}
+		return splitQuotedFields(trimmed)
+	}
 
-		posRow := parseRowFields(fields, colMap)
+	return data, colMap, splitRow, nil
+}
 
-		// Skip rows with no ref
-		if posRow.Ref == "" {
-			continue
-		}
+// csvHeader sets up parsing of a CSV format POS file if line is its header
+// row, or returns nil if it is not
+func csvHeader(line string) (*POSData, map[string]int, posRowSplitter) {
+	// European exports use ';' between fields (and ',' as decimal mark)
+	delim := detectDelimiter(line)
+	headers := parseCSVLine(line, delim)
+	colMap := buildColumnMap(headers)
 
-		data.Rows = append(data.Rows, posRow)
+	// JLCPCB CPL files have no Val column, so a position column
+	// is accepted in its place
+	_, hasRef := colMap["ref"]
+	_, hasVal := colMap["val"]
+	_, hasPosX := colMap["posx"]
+	if !hasRef || (!hasVal && !hasPosX) {
+		return nil, nil, nil
 	}
 
-	return data, nil
+	data := &POSData{
+		Headers: headers,
+		Rows:    []POSRow{},
+	}
+
+	splitRow := func(line string) []string {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			return nil
+		}
+		return parseCSVLine(trimmed, delim)
+	}
+
+	return data, colMap, splitRow
 }
 
-// parseCSVFormat parses CSV format POS files
-func parseCSVFormat(text string) (*POSData, error) {
-	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+// eagleHeader sets up parsing of an Eagle mount (.mnt/mountsmd) file from
+// its Element/Coord-X header row; rows are comma or whitespace separated
+func eagleHeader(headerLine string) (*POSData, map[string]int, posRowSplitter) {
+	split := splitByWhitespace
+	if strings.ContainsAny(headerLine, ",;") {
+		delim := detectDelimiter(headerLine)
+		split = func(line string) []string { return parseCSVLine(line, delim) }
+	}
+
+	headers := split(headerLine)
+	colMap := buildColumnMap(headers)
 
-	// Find header row
-	headerIdx := -1
-	var colMap map[string]int
+	data := &POSData{
+		Headers: headers,
+		Rows:    []POSRow{},
+	}
 
-	for i, line := range lines {
+	splitRow := func(line string) []string {
 		trimmed := strings.TrimSpace(line)
 		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
+			return nil
 		}
+		return split(trimmed)
+	}
 
-		fields := parseCSVLine(trimmed)
-		colMap = buildColumnMap(fields)
+	return data, colMap, splitRow
+}
 
-		if _, hasRef := colMap["ref"]; hasRef {
-			if _, hasVal := colMap["val"]; hasVal {
-				headerIdx = i
-				break
-			}
-		}
+// altiumHeader sets up parsing of an Altium Pick Place report from its
+// header row. The report starts with a free-form preamble, followed by the
+// header row and fixed-width or comma-separated data rows.
+func altiumHeader(headerLine string) (*POSData, map[string]int, posRowSplitter, error) {
+	isCSV := strings.Contains(headerLine, ",")
+
+	var headers []string
+	if isCSV {
+		headers = parseCSVLine(strings.TrimSpace(headerLine), ',')
+	} else {
+		headers = unquoteFields(splitByWhitespace(headerLine))
 	}
 
-	if headerIdx == -1 {
-		return nil, fmt.Errorf("could not find KiCad POS header row (need Ref, Val columns)")
+	colMap := buildColumnMap(headers)
+	if _, hasRef := colMap["ref"]; !hasRef {
+		return nil, nil, nil, fmt.Errorf("header missing Designator column (found headers: %v)", headers)
 	}
 
-	// Get headers
-	headers := parseCSVLine(strings.TrimSpace(lines[headerIdx]))
-	colMap = buildColumnMap(headers)
+	// Column start offsets, used to slice fixed-width rows whose values
+	// contain spaces (e.g. a Comment of "10uF 16V")
+	var colStarts []int
+	if !isCSV {
+		pos := 0
+		for _, h := range splitByWhitespace(headerLine) {
+			idx := strings.Index(headerLine[pos:], h)
+			colStarts = append(colStarts, pos+idx)
+			pos += idx + len(h)
+		}
+	}
 
 	data := &POSData{
 		Headers: headers,
 		Rows:    []POSRow{},
 	}
 
-	// Parse data rows
-	for i := headerIdx + 1; i < len(lines); i++ {
-		trimmed := strings.TrimSpace(lines[i])
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
+	splitRow := func(line string) []string {
+		if strings.TrimSpace(line) == "" {
+			return nil
 		}
 
-		fields := parseCSVLine(trimmed)
-		if len(fields) == 0 {
-			continue
+		var fields []string
+		if isCSV {
+			fields = parseCSVLine(strings.TrimSpace(line), ',')
+		} else {
+			fields = unquoteFields(splitByWhitespace(line))
+			if len(fields) != len(headers) {
+				fields = splitFixedWidth(line, colStarts)
+			}
 		}
+		return fields
+	}
 
-		posRow := parseRowFields(fields, colMap)
+	return data, colMap, splitRow, nil
+}
 
-		if posRow.Ref == "" {
-			continue
+// splitFixedWidth slices a line at the given column start offsets
+func splitFixedWidth(line string, starts []int) []string {
+	fields := make([]string, len(starts))
+	for i, start := range starts {
+		if start >= len(line) {
+			break
 		}
-
-		data.Rows = append(data.Rows, posRow)
+		end := len(line)
+		if i+1 < len(starts) && starts[i+1] < end {
+			end = starts[i+1]
+		}
+		fields[i] = strings.Trim(strings.TrimSpace(line[start:end]), "\"")
 	}
+	return fields
+}
 
-	return data, nil
+// unquoteFields strips surrounding double quotes from each field
+func unquoteFields(fields []string) []string {
+	for i, f := range fields {
+		fields[i] = strings.Trim(f, "\"")
+	}
+	return fields
 }
 
 // splitByWhitespace splits a line by whitespace (spaces/tabs)
 func splitByWhitespace(line string) []string {
-	re := regexp.MustCompile(`\s+`)
-	parts := re.Split(strings.TrimSpace(line), -1)
-	var result []string
-	for _, p := range parts {
-		if p != "" {
-			result = append(result, p)
+	return strings.Fields(line)
+}
+
+// splitQuotedFields splits a whitespace delimited line like
+// splitByWhitespace, but keeps double-quoted text such as "100nF, 50V"
+// together as one field (without the quotes). Inside quotes, "" is a
+// literal quote; "" on its own is an empty field.
+func splitQuotedFields(line string) []string {
+	if !strings.Contains(line, "\"") {
+		return splitByWhitespace(line)
+	}
+
+	var fields []string
+	var field strings.Builder
+	inField, inQuotes := false, false
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '"' && inQuotes && i+1 < len(runes) && runes[i+1] == '"':
+			field.WriteRune('"')
+			i++
+		case r == '"':
+			inQuotes = !inQuotes
+			inField = true
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// detectDelimiter picks ';' or ',' as the field separator of a CSV header
+// line, whichever occurs more often outside quotes
+func detectDelimiter(line string) byte {
+	commas, semicolons := 0, 0
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				commas++
+			}
+		case ';':
+			if !inQuotes {
+				semicolons++
+			}
 		}
 	}
-	return result
+	if semicolons > commas {
+		return ';'
+	}
+	return ','
 }
 
-// parseCSVLine parses a CSV line
-func parseCSVLine(line string) []string {
+// parseCSVLine parses a CSV line split on delim, honoring quoted fields
+func parseCSVLine(line string, delim byte) []string {
 	var fields []string
 	var current strings.Builder
 	inQuotes := false
@@ -225,7 +534,7 @@ func parseCSVLine(line string) []string {
 			} else {
 				inQuotes = !inQuotes
 			}
-		} else if c == ',' && !inQuotes {
+		} else if c == delim && !inQuotes {
 			fields = append(fields, strings.TrimSpace(current.String()))
 			current.Reset()
 		} else {
@@ -241,20 +550,24 @@ func buildColumnMap(headers []string) map[string]int {
 	colMap := make(map[string]int)
 	for j, cell := range headers {
 		lower := strings.ToLower(strings.TrimSpace(cell))
-		if lower == "ref" || lower == "designator" {
+		if lower == "ref" || lower == "designator" || lower == "element" {
 			colMap["ref"] = j
 		} else if lower == "val" || lower == "value" {
 			colMap["val"] = j
-		} else if lower == "package" || lower == "footprint" {
+		} else if lower == "package" {
 			colMap["package"] = j
-		} else if lower == "posx" || lower == "mid x" || lower == "center-x(mm)" {
+		} else if lower == "footprint" {
+			colMap["footprint"] = j
+		} else if lower == "posx" || lower == "mid x" || lower == "coord-x" || strings.HasPrefix(lower, "center-x(") {
 			colMap["posx"] = j
-		} else if lower == "posy" || lower == "mid y" || lower == "center-y(mm)" {
+		} else if lower == "posy" || lower == "mid y" || lower == "coord-y" || strings.HasPrefix(lower, "center-y(") {
 			colMap["posy"] = j
-		} else if lower == "rot" || lower == "rotation" {
+		} else if lower == "rot" || lower == "rotation" || lower == "angle" {
 			colMap["rot"] = j
-		} else if lower == "side" || lower == "layer" || lower == "tb" {
+		} else if lower == "side" || lower == "layer" || lower == "tb" || lower == "mount" {
 			colMap["side"] = j
+		} else if lower == "comment" {
+			colMap["comment"] = j
 		}
 	}
 	return colMap
@@ -270,8 +583,19 @@ func parseRowFields(fields []string, colMap map[string]int) POSRow {
 	if idx, ok := colMap["val"]; ok && idx < len(fields) {
 		posRow.Val = strings.TrimSpace(fields[idx])
 	}
+	// JLCPCB CPL/BOM files carry the value in a Comment column
+	if idx, ok := colMap["comment"]; ok && idx < len(fields) && posRow.Val == "" {
+		posRow.Val = strings.TrimSpace(fields[idx])
+	}
+	if idx, ok := colMap["footprint"]; ok && idx < len(fields) {
+		posRow.Footprint = strings.TrimSpace(fields[idx])
+	}
+	// Newer KiCad exports carry both a short Package and a library Footprint;
+	// the Footprint is only used as the package when no Package column exists
 	if idx, ok := colMap["package"]; ok && idx < len(fields) {
 		posRow.Package = strings.TrimSpace(fields[idx])
+	} else {
+		posRow.Package = posRow.Footprint
 	}
 	if idx, ok := colMap["posx"]; ok && idx < len(fields) {
 		if v, err := parseFloat(fields[idx]); err == nil {
@@ -283,73 +607,152 @@ func parseRowFields(fields []string, colMap map[string]int) POSRow {
 			posRow.PosY = v
 		}
 	}
+	mirrored := false
 	if idx, ok := colMap["rot"]; ok && idx < len(fields) {
-		if v, err := parseFloat(fields[idx]); err == nil {
+		var angle string
+		angle, mirrored = stripEagleAngle(fields[idx])
+		if v, err := parseAngle(angle); err == nil {
 			posRow.Rot = v
 		}
 	}
 	if idx, ok := colMap["side"]; ok && idx < len(fields) {
 		posRow.Side = strings.TrimSpace(fields[idx])
 	}
+	if mirrored && posRow.Side == "" {
+		posRow.Side = "bottom"
+	}
 
 	return posRow
 }
 
-// parseFloat parses a float, handling mm suffix
+// parseFloat parses a finite float, handling mm/mil/in suffixes
+// (unit conversion is applied afterwards by ParsePOS)
 func parseFloat(s string) (float64, error) {
 	s = strings.TrimSpace(s)
-	s = strings.TrimSuffix(s, "mm")
+	for _, suffix := range []string{"mm", "mils", "mil", "in"} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	s = strings.TrimSpace(s)
+	// Decimal comma from semicolon-delimited European exports ("12,5")
+	if strings.Count(s, ",") == 1 && !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	// "NaN", "Inf" and overflowing values like "1e999" are not positions
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("value %q is not a finite number", s)
+	}
+	return v, nil
+}
+
+// parseAngle parses a rotation, tolerating a degree symbol or unit after
+// the number ("90°", "90deg", "-45.0 degrees")
+func parseAngle(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	end := len(s)
+	for end > 0 && !strings.ContainsRune("0123456789.,", rune(s[end-1])) {
+		end--
+	}
+	return parseFloat(s[:end])
+}
+
+// stripEagleAngle removes the Eagle rotation prefix ("R90", "MR270",
+// "SR45") from an angle and reports whether it marks a mirrored (bottom
+// side) part
+func stripEagleAngle(s string) (string, bool) {
 	s = strings.TrimSpace(s)
-	return strconv.ParseFloat(s, 64)
+	prefix := len(s) - len(strings.TrimLeft(s, "SMRsmr"))
+	return s[prefix:], strings.ContainsAny(s[:prefix], "Mm")
 }
 
-// bufio import is used implicitly by the scanner approach if needed
-var _ = bufio.Scanner{}
+// DetectInvertedY reports whether the rows look like they use a downward
+// Y axis: every part has Y <= 0 and at least one is below zero
+func DetectInvertedY(pos *POSData) bool {
+	negative := false
+	for _, row := range pos.Rows {
+		if row.PosY > 0 {
+			return false
+		}
+		if row.PosY < 0 {
+			negative = true
+		}
+	}
+	return negative
+}
 
 // ConvertPOSToXFile converts parsed POS data to XFile format
+// PosY is negated when pos.InvertY is set.
 func ConvertPOSToXFile(pos *POSData, filename string) *XFile {
+	return convertPOS(pos, filename, posBoardWidth(pos.Rows))
+}
+
+// posBoardWidth returns the board width used to mirror bottom-side parts,
+// auto-detected from the largest X in rows
+func posBoardWidth(rows []POSRow) float64 {
+	width := 0.0
+	for _, row := range rows {
+		if row.PosX > width {
+			width = row.PosX
+		}
+	}
+	return width
+}
+
+// convertPOS builds an XFile from pos, mirroring bottom-side parts about
+// boardWidth
+func convertPOS(pos *POSData, filename string, boardWidth float64) *XFile {
 	xf := NewXFile()
 	xf.OriginalPOS = filename
 
 	// Store original POS rows for display
 	xf.POSRows = make([]POSRow, len(pos.Rows))
 	copy(xf.POSRows, pos.Rows)
+	xf.POSComments = append([]string{}, pos.Comments...)
+	xf.POSHeaders = append([]string{}, pos.Headers...)
 
 	// Collect unique values for Station creation
 	valToStationID := make(map[string]int)
 	uniqueVals := []string{}
 
 	for _, row := range pos.Rows {
-		if row.Val != "" {
-			if _, exists := valToStationID[row.Val]; !exists {
+		key := stationKey(row)
+		if key != "" {
+			if _, exists := valToStationID[key]; !exists {
 				stationID := len(uniqueVals) + 1
-				valToStationID[row.Val] = stationID
-				uniqueVals = append(uniqueVals, row.Val)
+				valToStationID[key] = stationID
+				uniqueVals = append(uniqueVals, key)
 			}
 		}
 	}
 
 	// Create Stations from unique values
+	defaults := CurrentStationDefaults()
 	for idx, val := range uniqueVals {
 		station := XStation{
 			No:              idx,
 			ID:              idx + 1,
 			DeltX:           0,
 			DeltY:           0,
-			FeedRates:       4,
+			FeedRates:       defaults.FeedRates,
 			Note:            val,
-			Height:          0.5,
-			Speed:           0,
-			Status:          4, // Vision enabled
+			Height:          defaults.Height,
+			Speed:           defaults.Speed,
+			Status:          defaults.Status,
 			NPixSizeX:       0,
 			NPixSizeY:       0,
 			HeightTake:      0,
-			DelayTake:       10,
-			NPullStripSpeed: 85,
-			NThreshold:      110,
-			NVisualRadio:    200,
+			DelayTake:       defaults.DelayTake,
+			NPullStripSpeed: defaults.NPullStripSpeed,
+			NThreshold:      defaults.NThreshold,
+			NVisualRadio:    defaults.NVisualRadio,
 			Select:          false,
-			PHead:           1,
+			PHead:           defaults.PHead,
 			DNP:             false,
 		}
 		xf.Stations = append(xf.Stations, station)
@@ -357,8 +760,9 @@ func ConvertPOSToXFile(pos *POSData, filename string) *XFile {
 
 	// Create Components from POS rows
 	for idx, row := range pos.Rows {
+		key := stationKey(row)
 		stNo := 1
-		if id, ok := valToStationID[row.Val]; ok {
+		if id, ok := valToStationID[key]; ok {
 			stNo = id
 		}
 
@@ -371,35 +775,254 @@ func ConvertPOSToXFile(pos *POSData, filename string) *XFile {
 			note = row.Package
 		}
 
+		side := "top"
+		deltX := row.PosX
+		angle := row.Rot
+		if isBottomSide(row.Side) {
+			side = "bottom"
+			deltX = boardWidth - row.PosX
+			angle = row.Rot + 180
+		}
+
+		deltY := row.PosY
+		if pos.InvertY {
+			deltY = -row.PosY
+		}
+
 		comp := XComponent{
 			No:      idx,
 			ID:      idx + 1,
-			PHead:   1,
+			PHead:   defaults.PHead,
 			STNo:    stNo,
-			DeltX:   row.PosX,
-			DeltY:   row.PosY,
-			Angle:   row.Rot,
-			Height:  0.5,
-			Skip:    4, // Match Station Status=4 (vision enabled)
-			Speed:   0,
-			Explain: row.Val,
+			DeltX:   deltX,
+			DeltY:   deltY,
+			Angle:   NormalizeAngle(angle),
+			Height:  defaults.Height,
+			Skip:    defaults.Status, // Match the Station Status flags
+			Speed:   defaults.Speed,
+			Explain: key,
 			Note:    note,
 			Delay:   0,
 			Select:  false,
 			DNP:     false,
+			Side:    side,
 		}
 		xf.Components = append(xf.Components, comp)
 	}
 
+	// Correct footprint library rotation conventions
+	ApplyAngleOffsets(xf)
+
 	return xf
 }
 
+// AppendPOSToXFile merges parsed POS data into an existing XFile instead of
+// replacing it (e.g. adding a bottom-layer export to a top-layer one).
+// New components get fresh IDs, and stations are reused by value.
+// Bottom-side parts of both files are mirrored about their combined width.
+// Returns an error if any Ref already exists in the XFile or appears twice
+// in pos.
+func AppendPOSToXFile(xf *XFile, pos *POSData, filename string) error {
+	existingRefs := make(map[string]bool)
+	for _, c := range xf.Components {
+		existingRefs[componentRef(c)] = true
+	}
+	var duplicates []string
+	for _, row := range pos.Rows {
+		if existingRefs[row.Ref] {
+			duplicates = append(duplicates, row.Ref)
+		}
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("duplicate refs already in session: %s", strings.Join(duplicates, ", "))
+	}
+	incomingRefs := make(map[string]bool)
+	for _, row := range pos.Rows {
+		if row.Ref != "" && incomingRefs[row.Ref] {
+			duplicates = append(duplicates, row.Ref)
+		}
+		incomingRefs[row.Ref] = true
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("duplicate refs in %s: %s", filename, strings.Join(duplicates, ", "))
+	}
+
+	// Mirror about the combined extent, moving bottom parts already in the
+	// session if the incoming file widens the board
+	oldWidth := posBoardWidth(xf.POSRows)
+	boardWidth := math.Max(oldWidth, posBoardWidth(pos.Rows))
+	if len(xf.POSRows) > 0 && boardWidth > oldWidth {
+		for i := range xf.Components {
+			if xf.Components[i].Side == "bottom" {
+				xf.Components[i].DeltX += boardWidth - oldWidth
+			}
+		}
+	}
+
+	incoming := convertPOS(pos, filename, boardWidth)
+
+	// Map incoming station IDs to existing stations by Note, adding new ones
+	noteToID := make(map[string]int)
+	maxStationID := 0
+	for _, s := range xf.Stations {
+		noteToID[s.Note] = s.ID
+		if s.ID > maxStationID {
+			maxStationID = s.ID
+		}
+	}
+	stationIDMap := make(map[int]int)
+	for _, s := range incoming.Stations {
+		if id, ok := noteToID[s.Note]; ok {
+			stationIDMap[s.ID] = id
+			continue
+		}
+		maxStationID++
+		stationIDMap[s.ID] = maxStationID
+		noteToID[s.Note] = maxStationID
+		s.ID = maxStationID
+		s.No = len(xf.Stations)
+		xf.Stations = append(xf.Stations, s)
+	}
+
+	maxComponentID := 0
+	for _, c := range xf.Components {
+		if c.ID > maxComponentID {
+			maxComponentID = c.ID
+		}
+	}
+	for _, c := range incoming.Components {
+		if id, ok := stationIDMap[c.STNo]; ok {
+			c.STNo = id
+		}
+		maxComponentID++
+		c.ID = maxComponentID
+		c.No = len(xf.Components)
+		xf.Components = append(xf.Components, c)
+	}
+
+	xf.POSRows = append(xf.POSRows, incoming.POSRows...)
+	if xf.OriginalPOS == "" {
+		xf.OriginalPOS = filename
+	}
+
+	return nil
+}
+
+// MergeBottomPOS combines a KiCad top/bottom placement file pair into one
+// POSData. All bottom rows are marked as bottom side, so they are mirrored
+// by ConvertPOSToXFile using the board width of both files. Bottom rows
+// whose Ref is already in the top file are dropped; their Refs are
+// returned.
+func MergeBottomPOS(top, bottom *POSData) (*POSData, []string) {
+	merged := &POSData{
+		Headers:  top.Headers,
+		Rows:     append([]POSRow(nil), top.Rows...),
+		Units:    top.Units,
+		Comments: top.Comments,
+	}
+
+	topRefs := make(map[string]bool)
+	for _, row := range top.Rows {
+		topRefs[row.Ref] = true
+	}
+
+	var duplicates []string
+	for _, row := range bottom.Rows {
+		if topRefs[row.Ref] {
+			duplicates = append(duplicates, row.Ref)
+			continue
+		}
+		row.Side = "bottom"
+		merged.Rows = append(merged.Rows, row)
+	}
+
+	return merged, duplicates
+}
+
+// stationKey returns the value used to group a POS row into a Station.
+// Rows without a Val (e.g. JLCPCB CPL files) fall back to the Package.
+func stationKey(row POSRow) string {
+	if row.Val != "" {
+		return row.Val
+	}
+	return row.Package
+}
+
+// NormalizeAngle wraps an angle in degrees into the range (-180, 180]
+// e.g. 270 becomes -90, 360 becomes 0 and -180 becomes 180
+func NormalizeAngle(deg float64) float64 {
+	a := math.Mod(deg, 360)
+	if a > 180 {
+		a -= 360
+	} else if a <= -180 {
+		a += 360
+	}
+	if a == 0 {
+		return 0 // avoid emitting -0
+	}
+	return a
+}
+
+// isBottomSide reports whether a POS Side/Layer value refers to the bottom
+// of the board (KiCad "bottom", JLCPCB "B", Altium "BottomLayer")
+func isBottomSide(side string) bool {
+	switch strings.ToLower(strings.TrimSpace(side)) {
+	case "bottom", "b", "bot", "bottomlayer":
+		return true
+	}
+	return false
+}
+
+// twoSidedHint returns why the POS rows look like they come from a
+// two-sided board when the source file had no side column, or "" if they
+// don't (or the side is known). Repeated refs suggest concatenated top and
+// bottom exports; parts at the same spot suggest parts on opposite faces.
+func twoSidedHint(xf *XFile) string {
+	if len(xf.POSHeaders) == 0 {
+		return "" // source columns unknown (e.g. DPV import)
+	}
+	if _, ok := buildColumnMap(xf.POSHeaders)["side"]; ok {
+		return ""
+	}
+
+	seen := make(map[string]bool)
+	for _, row := range xf.POSRows {
+		if row.Ref != "" && seen[row.Ref] {
+			return fmt.Sprintf("ref %s appears more than once", row.Ref)
+		}
+		seen[row.Ref] = true
+	}
+	for i, a := range xf.POSRows {
+		for _, b := range xf.POSRows[i+1:] {
+			if math.Hypot(a.PosX-b.PosX, a.PosY-b.PosY) < OverlapDistance {
+				return fmt.Sprintf("%s and %s are at the same position", a.Ref, b.Ref)
+			}
+		}
+	}
+	return ""
+}
+
 // GeneratePOS generates a KiCad-style POS file from XFile POSRows
 func GeneratePOS(xf *XFile) string {
 	var sb strings.Builder
 
-	// Write header line
-	sb.WriteString("# Ref Val Package PosX PosY Rot Side\r\n")
+	// Re-emit the original comment block; rows are written in mm, so any
+	// units line is rewritten to match
+	for _, line := range xf.POSComments {
+		content := strings.ToLower(strings.TrimSpace(strings.TrimLeft(line, "#")))
+		if strings.HasPrefix(content, "unit") {
+			line = "## Unit = mm, Angle = deg."
+		}
+		sb.WriteString(line + "\r\n")
+	}
+
+	// Write header line, keeping the source file's column order
+	columns := posColumnOrder(xf.POSHeaders)
+	names := make([]string, len(columns))
+	for i, key := range columns {
+		names[i] = posColumnNames[key]
+	}
+	sb.WriteString("# " + strings.Join(names, " ") + "\r\n")
 
 	// Write data rows
 	for _, row := range xf.POSRows {
@@ -407,9 +1030,82 @@ func GeneratePOS(xf *XFile) string {
 		if side == "" {
 			side = "top"
 		}
-		sb.WriteString(fmt.Sprintf("%s %s %s %.4f %.4f %.4f %s\r\n",
-			row.Ref, row.Val, row.Package, row.PosX, row.PosY, row.Rot, side))
+		values := make([]string, len(columns))
+		for i, key := range columns {
+			switch key {
+			case "ref":
+				values[i] = row.Ref
+			case "val":
+				values[i] = row.Val
+			case "package":
+				values[i] = row.Package
+			case "footprint":
+				values[i] = row.Footprint
+			case "posx":
+				values[i] = fmt.Sprintf("%.4f", row.PosX)
+			case "posy":
+				values[i] = fmt.Sprintf("%.4f", row.PosY)
+			case "rot":
+				values[i] = fmt.Sprintf("%.4f", row.Rot)
+			case "side":
+				values[i] = side
+			}
+		}
+		for i, v := range values {
+			values[i] = posQuoteField(v)
+		}
+		sb.WriteString(strings.Join(values, " ") + "\r\n")
 	}
 
 	return sb.String()
 }
+
+// posQuoteField quotes a POS field that would not survive splitting on
+// whitespace: empty values, values with spaces or tabs, and values with
+// quotes (doubled inside the quotes)
+func posQuoteField(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\"") {
+		return v
+	}
+	return "\"" + strings.ReplaceAll(v, "\"", "\"\"") + "\""
+}
+
+// posColumnNames are the KiCad header names GeneratePOS writes for each
+// column key of buildColumnMap
+var posColumnNames = map[string]string{
+	"ref":       "Ref",
+	"val":       "Val",
+	"package":   "Package",
+	"footprint": "Footprint",
+	"posx":      "PosX",
+	"posy":      "PosY",
+	"rot":       "Rot",
+	"side":      "Side",
+}
+
+// posColumnOrder returns the column keys to write, in the order they
+// appeared in the source headers, followed by any standard KiCad column
+// the source lacked. Unrecognized source columns are dropped.
+func posColumnOrder(headers []string) []string {
+	colMap := buildColumnMap(headers)
+	// A Comment column only stands in for a missing Val column
+	if idx, ok := colMap["comment"]; ok {
+		if _, hasVal := colMap["val"]; !hasVal {
+			colMap["val"] = idx
+		}
+		delete(colMap, "comment")
+	}
+
+	keys := make([]string, 0, len(colMap))
+	for key := range colMap {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return colMap[keys[i]] < colMap[keys[j]] })
+
+	for _, key := range []string{"ref", "val", "package", "posx", "posy", "rot", "side"} {
+		if _, ok := colMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,601 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openFixture opens a file from testdata, failing the test if it is missing
+func openFixture(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestParsePOSJLCPCBWithoutVal(t *testing.T) {
+	pos, err := ParsePOS(openFixture(t, "jlcpcb_cpl.csv"))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	if len(pos.Rows) != 5 {
+		t.Fatalf("got %d rows, want 5", len(pos.Rows))
+	}
+
+	r1 := pos.Rows[2]
+	if r1.Ref != "R1" || r1.Package != "R0603" || r1.PosX != 20 || r1.PosY != 8.5 || r1.Rot != 180 {
+		t.Errorf("R1 row = %+v", r1)
+	}
+
+	xf := ConvertPOSToXFile(pos, "board-cpl.csv")
+	want := map[string]bool{"C0402": true, "R0603": true, "SOIC-8_3.9x4.9mm_P1.27mm": true}
+	if len(xf.Stations) != len(want) {
+		t.Fatalf("got %d stations, want %d (one per package)", len(xf.Stations), len(want))
+	}
+	for _, s := range xf.Stations {
+		if !want[s.Note] {
+			t.Errorf("unexpected station Note %q", s.Note)
+		}
+	}
+	if len(xf.Components) != 5 {
+		t.Fatalf("got %d components, want 5", len(xf.Components))
+	}
+	if xf.Components[0].STNo != xf.Components[1].STNo {
+		t.Errorf("C1 and C2 share a package but use stations %d and %d", xf.Components[0].STNo, xf.Components[1].STNo)
+	}
+}
+
+func TestConvertPOSMirrorsBottomSide(t *testing.T) {
+	const file = `### Footprint positions
+## Unit = mm, Angle = deg.
+# Ref     Val       Package        PosX       PosY       Rot  Side
+R1        10k       R_0603         10.0000    5.0000     90.0000  top
+R2        10k       R_0603         20.0000    5.0000     0.0000   bottom
+U1        LM358     SOIC-8         50.0000    30.0000    270.0000 bottom
+`
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	xf := ConvertPOSToXFile(pos, "mixed.pos")
+
+	tests := []struct {
+		ref   string
+		side  string
+		x     float64
+		angle float64
+	}{
+		{"R1", "top", 10, 90},
+		{"R2", "bottom", 30, 180}, // board width 50 (max X) - 20
+		{"U1", "bottom", 0, 90},   // 270 + 180 normalized
+	}
+	for i, tt := range tests {
+		c := xf.Components[i]
+		if componentRef(c) != tt.ref {
+			t.Fatalf("component %d is %q, want %q", i, componentRef(c), tt.ref)
+		}
+		if c.Side != tt.side || c.DeltX != tt.x || c.Angle != tt.angle {
+			t.Errorf("%s: side %q x %v angle %v, want %q %v %v", tt.ref, c.Side, c.DeltX, c.Angle, tt.side, tt.x, tt.angle)
+		}
+	}
+}
+
+func TestParsePOSAltium(t *testing.T) {
+	pos, err := ParsePOS(openFixture(t, "altium_pnp.txt"))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+
+	want := []POSRow{
+		{Ref: "C1", Val: "100nF", Package: "C0603", Footprint: "C0603", PosX: 10.16, PosY: 5.08, Rot: 90, Side: "TopLayer"},
+		{Ref: "C2", Val: "10uF 16V", Package: "C0805", Footprint: "C0805", PosX: 15.24, PosY: 5.08, Rot: 0, Side: "TopLayer"},
+		{Ref: "U1", Val: "LM358", Package: "SOIC-8", Footprint: "SOIC-8", PosX: 30.48, PosY: 20.32, Rot: 270, Side: "BottomLayer"},
+	}
+	if len(pos.Rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(pos.Rows), len(want), pos.Rows)
+	}
+	for i, w := range want {
+		if pos.Rows[i] != w {
+			t.Errorf("row %d = %+v, want %+v", i, pos.Rows[i], w)
+		}
+	}
+}
+
+// approx reports whether a and b are equal to within 1e-9
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestParsePOSMilUnits(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"units line", `## Unit = mil, Angle = deg.
+# Ref Val Package PosX PosY Rot Side
+R1 10k R_0603 1000 500 0 top
+U1 NE555 DIP-8_300mil 2000 -250 90 top
+`},
+		{"suffixed values", `Designator,Val,Package,Mid X,Mid Y,Rotation,Layer
+R1,10k,R_0603,1000mil,500mil,0,T
+U1,NE555,DIP-8_300mil,2000mil,-250mil,90,T
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := ParsePOS(strings.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("ParsePOS: %v", err)
+			}
+			if pos.Units != UnitsMil {
+				t.Errorf("Units = %q, want %q", pos.Units, UnitsMil)
+			}
+			want := [][2]float64{{25.4, 12.7}, {50.8, -6.35}}
+			for i, w := range want {
+				if row := pos.Rows[i]; !approx(row.PosX, w[0]) || !approx(row.PosY, w[1]) {
+					t.Errorf("%s at (%v, %v), want (%v, %v)", row.Ref, row.PosX, row.PosY, w[0], w[1])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePOSIgnoresUnitsInPackageNames(t *testing.T) {
+	const file = `Designator,Val,Package,Mid X,Mid Y,Rotation,Layer
+U1,NE555,DIP-8_300mil,20.32,10.16,0,T
+U2,LM358,SOIC-8_150mil,40.64,10.16,0,T
+`
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	if row := pos.Rows[0]; row.PosX != 20.32 || row.PosY != 10.16 {
+		t.Errorf("U1 at (%v, %v), want the mm values unscaled", row.PosX, row.PosY)
+	}
+}
+
+func TestForceUnits(t *testing.T) {
+	pos, err := ParsePOS(strings.NewReader("# Ref Val Package PosX PosY Rot Side\nR1 10k R_0603 1.5 2 0 top\n"))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	if err := pos.ForceUnits(UnitsInch); err != nil {
+		t.Fatalf("ForceUnits: %v", err)
+	}
+	if row := pos.Rows[0]; !approx(row.PosX, 38.1) || !approx(row.PosY, 50.8) {
+		t.Errorf("R1 at (%v, %v), want (38.1, 50.8)", row.PosX, row.PosY)
+	}
+	if err := pos.ForceUnits("cubits"); err == nil {
+		t.Error("ForceUnits accepted unknown units")
+	}
+}
+
+func TestNormalizeAngle(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{0, 0},
+		{90, 90},
+		{180, 180},
+		{-180, 180},
+		{270, -90},
+		{-270, 90},
+		{360, 0},
+		{-360, 0},
+		{540, 180},
+		{-90, -90},
+		{179.5, 179.5},
+	}
+	for _, tt := range tests {
+		got := NormalizeAngle(tt.in)
+		if got != tt.want || math.Signbit(got) && got == 0 {
+			t.Errorf("NormalizeAngle(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConvertPOSNormalizesAngles(t *testing.T) {
+	pos := &POSData{Rows: []POSRow{
+		{Ref: "R1", Val: "10k", Package: "R_0603", Rot: 270},
+		{Ref: "R2", Val: "10k", Package: "R_0603", Rot: 360},
+	}}
+	xf := ConvertPOSToXFile(pos, "board.pos")
+	if xf.Components[0].Angle != -90 || xf.Components[1].Angle != 0 {
+		t.Errorf("angles %v, %v, want -90, 0", xf.Components[0].Angle, xf.Components[1].Angle)
+	}
+}
+
+func TestAppendPOSToXFile(t *testing.T) {
+	top := &POSData{Rows: []POSRow{
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 10, PosY: 5, Side: "top"},
+		{Ref: "R2", Val: "10k", Package: "R_0603", PosX: 20, PosY: 5, Side: "bottom"},
+		{Ref: "U1", Val: "LM358", Package: "SOIC-8", PosX: 40, PosY: 20, Side: "top"},
+	}}
+	xf := ConvertPOSToXFile(top, "top.pos")
+
+	bottom := &POSData{Rows: []POSRow{
+		{Ref: "R3", Val: "10k", Package: "R_0603", PosX: 50, PosY: 5, Side: "bottom"},
+		{Ref: "C1", Val: "100nF", Package: "C_0603", PosX: 15, PosY: 10, Side: "bottom"},
+	}}
+	if err := AppendPOSToXFile(xf, bottom, "bottom.pos"); err != nil {
+		t.Fatalf("AppendPOSToXFile: %v", err)
+	}
+
+	if len(xf.Components) != 5 || len(xf.Stations) != 3 {
+		t.Fatalf("got %d components and %d stations, want 5 and 3", len(xf.Components), len(xf.Stations))
+	}
+	// The combined board is 50 wide, so every bottom part mirrors about 50
+	want := map[string]float64{"R1": 10, "R2": 30, "U1": 40, "R3": 0, "C1": 35}
+	ids := make(map[int]bool)
+	for _, c := range xf.Components {
+		ref := componentRef(c)
+		if x, ok := want[ref]; !ok || !approx(c.DeltX, x) {
+			t.Errorf("%s at x %v, want %v", ref, c.DeltX, want[ref])
+		}
+		if ids[c.ID] {
+			t.Errorf("component ID %d used twice", c.ID)
+		}
+		ids[c.ID] = true
+	}
+	if r3 := xf.Components[3]; r3.STNo != xf.Components[0].STNo {
+		t.Errorf("R3 on station %d, want the existing 10k station %d", r3.STNo, xf.Components[0].STNo)
+	}
+	if xf.OriginalPOS != "top.pos" || len(xf.POSRows) != 5 {
+		t.Errorf("OriginalPOS %q with %d POS rows", xf.OriginalPOS, len(xf.POSRows))
+	}
+}
+
+func TestAppendPOSToXFileRejectsDuplicateRefs(t *testing.T) {
+	base := &POSData{Rows: []POSRow{{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 10}}}
+	tests := []struct {
+		name string
+		rows []POSRow
+	}{
+		{"already in session", []POSRow{{Ref: "R1", Val: "10k", Package: "R_0603"}}},
+		{"twice in file", []POSRow{{Ref: "C1", Val: "1uF"}, {Ref: "C1", Val: "1uF"}}},
+	}
+	for _, tt := range tests {
+		xf := ConvertPOSToXFile(base, "top.pos")
+		if err := AppendPOSToXFile(xf, &POSData{Rows: tt.rows}, "bottom.pos"); err == nil {
+			t.Errorf("%s: append accepted duplicate refs", tt.name)
+		}
+		if len(xf.Components) != 1 {
+			t.Errorf("%s: rejected append left %d components", tt.name, len(xf.Components))
+		}
+	}
+}
+
+func TestGeneratePOSRoundTrip(t *testing.T) {
+	const file = "## Unit = mm, Angle = deg.\r\n" +
+		"# Ref     Val              Package     PosX      PosY      Rot       Side\r\n" +
+		"R1        10k              R_0603      10.0000   5.0000    90.0000   top\r\n" +
+		"C1        \"100nF, 50V\"     C_0603      12.5000   -7.2500   0.0000    bottom\r\n" +
+		"J1        \"1/4\"\" jack\"    \"\"          30.0000   20.0000   180.0000  top\r\n" +
+		"TP1       \"\"               TestPoint   1.0000    2.0000    0.0000    top\r\n"
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	want := []POSRow{
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 10, PosY: 5, Rot: 90, Side: "top"},
+		{Ref: "C1", Val: "100nF, 50V", Package: "C_0603", PosX: 12.5, PosY: -7.25, Rot: 0, Side: "bottom"},
+		{Ref: "J1", Val: "1/4\" jack", Package: "", PosX: 30, PosY: 20, Rot: 180, Side: "top"},
+		{Ref: "TP1", Val: "", Package: "TestPoint", PosX: 1, PosY: 2, Rot: 0, Side: "top"},
+	}
+	if !reflect.DeepEqual(pos.Rows, want) {
+		t.Fatalf("parsed rows %+v, want %+v", pos.Rows, want)
+	}
+
+	out := GeneratePOS(ConvertPOSToXFile(pos, "board.pos"))
+	again, err := ParsePOS(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("ParsePOS of generated file: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(again.Rows, pos.Rows) {
+		t.Errorf("round trip rows %+v, want %+v\ngenerated:\n%s", again.Rows, pos.Rows, out)
+	}
+	if !reflect.DeepEqual(again.Headers, pos.Headers) {
+		t.Errorf("round trip headers %q, want %q", again.Headers, pos.Headers)
+	}
+}
+
+func TestGeneratePOSKeepsCommentHeader(t *testing.T) {
+	const file = "### Footprint positions - created on 2026-10-15 10:00:00 ###\r\n" +
+		"### Printed by KiCad version 8.0.4\r\n" +
+		"## Unit = mm, Angle = deg.\r\n" +
+		"## Side : top\r\n" +
+		"# Ref     Val       Package        PosX       PosY       Rot  Side\r\n" +
+		"R1        10k       R_0603         10.0000    5.0000     90.0000  top\r\n" +
+		"## End\r\n"
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	out := GeneratePOS(ConvertPOSToXFile(pos, "board.pos"))
+
+	want := "### Footprint positions - created on 2026-10-15 10:00:00 ###\r\n" +
+		"### Printed by KiCad version 8.0.4\r\n" +
+		"## Unit = mm, Angle = deg.\r\n" +
+		"## Side : top\r\n" +
+		"# Ref Val Package PosX PosY Rot Side\r\n"
+	if !strings.HasPrefix(out, want) {
+		t.Errorf("GeneratePOS output:\n%s\nwant it to start with:\n%s", out, want)
+	}
+
+	again, err := ParsePOS(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("ParsePOS of generated file: %v", err)
+	}
+	if strings.Join(again.Comments, "\n") != strings.Join(pos.Comments, "\n") {
+		t.Errorf("comments after round trip %q, want %q", again.Comments, pos.Comments)
+	}
+}
+
+func TestParsePOSPackageAndFootprint(t *testing.T) {
+	tests := []struct {
+		name, file   string
+		pkg, library string
+	}{
+		{"package first", "# Ref Val Package Footprint PosX PosY Rot Side\n" +
+			"R1 10k R_0603 Resistor_SMD:R_0603_1608Metric 10 5 0 top\n", "R_0603", "Resistor_SMD:R_0603_1608Metric"},
+		{"footprint first", "# Ref Val Footprint Package PosX PosY Rot Side\n" +
+			"R1 10k Resistor_SMD:R_0603_1608Metric R_0603 10 5 0 top\n", "R_0603", "Resistor_SMD:R_0603_1608Metric"},
+		{"footprint only", "# Ref Val Footprint PosX PosY Rot Side\n" +
+			"R1 10k R_0603_1608Metric 10 5 0 top\n", "R_0603_1608Metric", "R_0603_1608Metric"},
+	}
+	for _, tt := range tests {
+		pos, err := ParsePOS(strings.NewReader(tt.file))
+		if err != nil {
+			t.Fatalf("%s: ParsePOS: %v", tt.name, err)
+		}
+		if row := pos.Rows[0]; row.Package != tt.pkg || row.Footprint != tt.library || row.PosX != 10 {
+			t.Errorf("%s: row = %+v, want Package %q Footprint %q", tt.name, row, tt.pkg, tt.library)
+		}
+	}
+}
+
+func TestParsePOSEagle(t *testing.T) {
+	pos, err := ParsePOS(openFixture(t, "eagle.mnt"))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+
+	want := []POSRow{
+		{Ref: "C1", Val: "100nF", Package: "C0603", PosX: 10.16, PosY: 5.08, Rot: 90, Side: "top"},
+		{Ref: "R1", Val: "10k", Package: "R0603", PosX: 15.24, PosY: 5.08, Rot: 0, Side: "top"},
+		{Ref: "U1", Val: "LM358", Package: "SO08", PosX: 30.48, PosY: 20.32, Rot: 270, Side: "bottom"},
+	}
+	if len(pos.Rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(pos.Rows), len(want), pos.Rows)
+	}
+	for i, w := range want {
+		if pos.Rows[i] != w {
+			t.Errorf("row %d = %+v, want %+v", i, pos.Rows[i], w)
+		}
+	}
+}
+
+func TestParsePOSSemicolonCSV(t *testing.T) {
+	const file = "Ref;Val;Package;PosX;PosY;Rot;Side\n" +
+		"C1;\"100nF; 50V\";C_0603;12,5;5,25;90;top\n" +
+		"R1;10k;R_0603;20.0;8.0;0;bottom\n"
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	want := []POSRow{
+		{Ref: "C1", Val: "100nF; 50V", Package: "C_0603", PosX: 12.5, PosY: 5.25, Rot: 90, Side: "top"},
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 20, PosY: 8, Rot: 0, Side: "bottom"},
+	}
+	if len(pos.Rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(pos.Rows), len(want), pos.Rows)
+	}
+	for i, w := range want {
+		if pos.Rows[i] != w {
+			t.Errorf("row %d = %+v, want %+v", i, pos.Rows[i], w)
+		}
+	}
+}
+
+func TestValidateDPVMissingSideColumn(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want bool
+	}{
+		{
+			name: "one side without side column",
+			file: "# Ref Val Package PosX PosY Rot\nR1 10k R_0603 10 5 0\nR2 10k R_0603 20 5 0\n",
+		},
+		{
+			name: "repeated ref without side column",
+			file: "# Ref Val Package PosX PosY Rot\nR1 10k R_0603 10 5 0\nR1 10k R_0603 30 5 0\n",
+			want: true,
+		},
+		{
+			name: "stacked parts without side column",
+			file: "# Ref Val Package PosX PosY Rot\nR1 10k R_0603 10 5 0\nC1 100nF C_0603 10 5 90\n",
+			want: true,
+		},
+		{
+			name: "stacked parts with side column",
+			file: "# Ref Val Package PosX PosY Rot Side\nR1 10k R_0603 10 5 0 top\nC1 100nF C_0603 10 5 90 bottom\n",
+		},
+	}
+
+	for _, tt := range tests {
+		pos, err := ParsePOS(strings.NewReader(tt.file))
+		if err != nil {
+			t.Fatalf("%s: ParsePOS: %v", tt.name, err)
+		}
+		res := ValidateDPV(ConvertPOSToXFile(pos, "board.pos"), "board.dpv")
+		if got := findIssue(res.Warnings, "missing_side_column") != nil; got != tt.want {
+			t.Errorf("%s: missing_side_column warning %v, want %v (warnings %+v)", tt.name, got, tt.want, res.Warnings)
+		}
+	}
+}
+
+// BenchmarkParsePOS parses a 50k line KiCad panel file, reporting
+// allocations so changes to the streaming parser can be compared
+func BenchmarkParsePOS(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("## Unit = mm, Angle = deg.\n")
+	sb.WriteString("# Ref     Val       Package        PosX       PosY       Rot  Side\n")
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&sb, "R%-8d 10k       R_0603         %.4f    %.4f     90.0000  top\n", i+1, float64(i%200)*2.5, float64(i/200)*2.5)
+	}
+	file := sb.String()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(file)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos, err := ParsePOS(strings.NewReader(file))
+		if err != nil {
+			b.Fatalf("ParsePOS: %v", err)
+		}
+		if len(pos.Rows) != 50000 {
+			b.Fatalf("got %d rows, want 50000", len(pos.Rows))
+		}
+	}
+}
+
+func TestParseAngle(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "90°", want: 90},
+		{in: "90deg", want: 90},
+		{in: "-45.0", want: -45},
+		{in: " 180.5 ° ", want: 180.5},
+		{in: "-45.0 degrees", want: -45},
+		{in: "270,5°", want: 270.5},
+		{in: "deg", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAngle(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAngle(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAngle(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	// The rotation column keeps the angle instead of reading it as 0
+	const file = "Designator,Val,Package,Mid X,Mid Y,Rotation,Layer\n" +
+		"R1,10k,R_0603,10mm,5mm,90°,top\n" +
+		"R2,10k,R_0603,20mm,5mm,90deg,top\n" +
+		"R3,10k,R_0603,30mm,5mm,-45.0,top\n"
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	for i, want := range []float64{90, 90, -45} {
+		if i >= len(pos.Rows) || pos.Rows[i].Rot != want {
+			t.Errorf("row %d rotation, want %v (rows %+v)", i, want, pos.Rows)
+		}
+	}
+}
+
+func TestParsePOSNonFiniteFields(t *testing.T) {
+	const file = "# Ref Val Package PosX PosY Rot Side\n" +
+		"R1 10k R_0603 Inf 5 0 top\n" +
+		"R2 10k R_0603 10 NaN 0 top\n" +
+		"R3 10k R_0603 1e999 -1e999 Inf top\n"
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	if len(pos.Rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(pos.Rows))
+	}
+	for _, row := range pos.Rows {
+		for _, v := range []float64{row.PosX, row.PosY, row.Rot} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Errorf("%s parsed to %+v", row.Ref, row)
+			}
+		}
+	}
+	if pos.Rows[0].PosY != 5 || pos.Rows[1].PosX != 10 {
+		t.Errorf("finite fields lost: %+v", pos.Rows[:2])
+	}
+}
+
+func TestParsePOSQuotedVal(t *testing.T) {
+	const file = "## Unit = mm, Angle = deg.\n" +
+		"# Ref     Val              Package     PosX      PosY      Rot       Side\n" +
+		"C1        \"100nF, 50V\"     C_0603      12.5000   7.2500    90.0000   top\n" +
+		"C2        \"10uF  X7R 25V\"  C_0805      20.0000   7.2500    0.0000    bottom\n" +
+		"R1        10k              R_0603      5.0000    1.0000    180.0000  top\n"
+	pos, err := ParsePOS(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParsePOS: %v", err)
+	}
+	// The quoted value stays one field, so the columns after it line up
+	want := []POSRow{
+		{Ref: "C1", Val: "100nF, 50V", Package: "C_0603", PosX: 12.5, PosY: 7.25, Rot: 90, Side: "top"},
+		{Ref: "C2", Val: "10uF  X7R 25V", Package: "C_0805", PosX: 20, PosY: 7.25, Rot: 0, Side: "bottom"},
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 5, PosY: 1, Rot: 180, Side: "top"},
+	}
+	if !reflect.DeepEqual(pos.Rows, want) {
+		t.Errorf("rows %+v, want %+v", pos.Rows, want)
+	}
+}
+
+func TestInvertY(t *testing.T) {
+	rows := []POSRow{
+		{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 10, PosY: -5, Side: "top"},
+		{Ref: "R2", Val: "10k", Package: "R_0603", PosX: 20, PosY: 0, Side: "top"},
+		{Ref: "C1", Val: "100nF", Package: "C_0603", PosX: 15, PosY: -30.5, Side: "bottom"},
+	}
+	pos := &POSData{Rows: rows}
+	if !DetectInvertedY(pos) {
+		t.Error("DetectInvertedY = false for rows with Y <= 0")
+	}
+
+	pos.InvertY = true
+	xf := ConvertPOSToXFile(pos, "board.pos")
+	for i, want := range []float64{5, 0, 30.5} {
+		if got := xf.Components[i].DeltY; got != want {
+			t.Errorf("%s DeltY %v, want %v", componentRef(xf.Components[i]), got, want)
+		}
+	}
+	// The POS rows keep the file's sign
+	if xf.POSRows[0].PosY != -5 {
+		t.Errorf("POS row Y changed to %v", xf.POSRows[0].PosY)
+	}
+
+	pos.InvertY = false
+	if got := ConvertPOSToXFile(pos, "board.pos").Components[0].DeltY; got != -5 {
+		t.Errorf("without InvertY DeltY %v, want -5", got)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ys   []float64
+	}{
+		{"one part above the origin", []float64{-5, 2}},
+		{"all on the origin", []float64{0, 0}},
+		{"no rows", nil},
+	} {
+		pos := &POSData{}
+		for _, y := range tt.ys {
+			pos.Rows = append(pos.Rows, POSRow{PosY: y})
+		}
+		if DetectInvertedY(pos) {
+			t.Errorf("%s: DetectInvertedY = true", tt.name)
+		}
+	}
+}
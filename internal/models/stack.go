@@ -8,9 +8,24 @@ import (
 	"strings"
 )
 
+// StackData holds the tables parsed from a STACK file
+type StackData struct {
+	Stations []XStation
+	ICTrays  []ICTrayRow
+}
+
 // ParseStack parses a STACK file and returns Station data
 // STACK files are DPV-like files containing only Station table data
 func ParseStack(r io.Reader) ([]XStation, error) {
+	data, err := ParseStackFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return data.Stations, nil
+}
+
+// ParseStackFile parses a STACK file and returns its Station and ICTray data
+func ParseStackFile(r io.Reader) (*StackData, error) {
 	content, err := io.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read stack file: %w", err)
@@ -30,6 +45,7 @@ func ParseStack(r io.Reader) ([]XStation, error) {
 	// Parse as DPV format
 	var header []string
 	var stations []XStation
+	var trays []ICTrayRow
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -80,27 +96,104 @@ func ParseStack(r io.Reader) ([]XStation, error) {
 			station := parseStationRow(header, row)
 			stations = append(stations, station)
 		}
+
+		// IC tray data row
+		if first == "ICTray" {
+			trayHeader := header
+			if len(trayHeader) == 0 || strings.TrimSpace(trayHeader[len(trayHeader)-1]) != "Start" {
+				trayHeader = []string{"Table", "No.", "ID", "CenterX", "CenterY",
+					"IntervalX", "IntervalY", "NumX", "NumY", "Start"}
+			}
+			trays = append(trays, parseICTrayRow(trayHeader, row))
+		}
 	}
 
-	if len(stations) == 0 {
+	if len(stations) == 0 && len(trays) == 0 {
 		return nil, fmt.Errorf("no Station data found in stack file")
 	}
 
-	return stations, nil
+	return &StackData{Stations: stations, ICTrays: trays}, nil
+}
+
+// parseICTrayRow parses a single ICTray row using the header for column mapping
+func parseICTrayRow(header, row []string) ICTrayRow {
+	colMap := make(map[string]int)
+	for i, h := range header {
+		colMap[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	getValue := func(name string) string {
+		if idx, ok := colMap[name]; ok && idx < len(row) {
+			return strings.TrimSpace(row[idx])
+		}
+		return ""
+	}
+
+	getInt := func(name string, def int) int {
+		if i, err := strconv.Atoi(getValue(name)); err == nil {
+			return i
+		}
+		return def
+	}
+
+	getFloat := func(name string, def float64) float64 {
+		if f, err := strconv.ParseFloat(getValue(name), 64); err == nil {
+			return f
+		}
+		return def
+	}
+
+	return ICTrayRow{
+		No:        getInt("no.", 0),
+		ID:        getInt("id", 1),
+		CenterX:   getFloat("centerx", 0),
+		CenterY:   getFloat("centery", 0),
+		IntervalX: getFloat("intervalx", 0),
+		IntervalY: getFloat("intervaly", 0),
+		NumX:      getInt("numx", 1),
+		NumY:      getInt("numy", 1),
+		Start:     getInt("start", 0),
+	}
+}
+
+// MergeICTrays merges IC tray rows into an XFile, replacing trays with the
+// same ID and appending new ones
+func MergeICTrays(xf *XFile, trays []ICTrayRow) {
+	for _, incoming := range trays {
+		replaced := false
+		for i := range xf.ICTrays {
+			if xf.ICTrays[i].ID == incoming.ID {
+				incoming.No = xf.ICTrays[i].No
+				xf.ICTrays[i] = incoming
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			incoming.No = len(xf.ICTrays)
+			xf.ICTrays = append(xf.ICTrays, incoming)
+		}
+	}
+}
+
+// writeICTrayTable writes the ICTray table for stack files (omitted when
+// empty) with precision decimals for positions
+func writeICTrayTable(sb *strings.Builder, xf *XFile, precision int) {
+	if len(xf.ICTrays) == 0 {
+		return
+	}
+	sb.WriteString("\r\n")
+	sb.WriteString("Table,No.,ID,CenterX,CenterY,IntervalX,IntervalY,NumX,NumY,Start\r\n")
+	for i, t := range xf.ICTrays {
+		sb.WriteString(fmt.Sprintf("ICTray,%d,%d,%.*f,%.*f,%.*f,%.*f,%d,%d,%d\r\n",
+			i, t.ID, precision, t.CenterX, precision, t.CenterY, precision, t.IntervalX, precision, t.IntervalY, t.NumX, t.NumY, t.Start))
+	}
 }
 
 // parseStationRow parses a single Station row using the header for column mapping
 func parseStationRow(header, row []string) XStation {
-	s := XStation{
-		FeedRates:       4,
-		Height:          0.5,
-		Status:          4,
-		DelayTake:       10,
-		NPullStripSpeed: 85,
-		NThreshold:      110,
-		NVisualRadio:    200,
-		PHead:           1,
-	}
+	d := CurrentStationDefaults()
+	s := XStation{}
 
 	colMap := make(map[string]int)
 	for i, h := range header {
@@ -140,11 +233,11 @@ func parseStationRow(header, row []string) XStation {
 	s.ID = getInt("id", 1)
 	s.DeltX = getFloat("deltx", 0)
 	s.DeltY = getFloat("delty", 0)
-	s.FeedRates = getInt("feedrates", 4)
+	s.FeedRates = getInt("feedrates", d.FeedRates)
 	s.Note = getValue("note")
-	s.Height = getFloat("height", 0.5)
-	s.Speed = getInt("speed", 0)
-	s.Status = getInt("status", 4)
+	s.Height = getFloat("height", d.Height)
+	s.Speed = getInt("speed", d.Speed)
+	s.Status = getInt("status", d.Status)
 
 	// Support both V0 (SizeX/SizeY) and V1 (nPixSizeX/nPixSizeY) formats
 	if v := getInt("npixsizex", -1); v >= 0 {
@@ -159,13 +252,13 @@ func parseStationRow(header, row []string) XStation {
 	}
 
 	s.HeightTake = getFloat("heighttake", 0)
-	s.DelayTake = getInt("delaytake", 10)
-	s.NPullStripSpeed = getInt("npullstripspeed", 85)
-	s.NThreshold = getInt("nthreshold", 110)
-	s.NVisualRadio = getInt("nvisualradio", 200)
+	s.DelayTake = getInt("delaytake", d.DelayTake)
+	s.NPullStripSpeed = getInt("npullstripspeed", d.NPullStripSpeed)
+	s.NThreshold = getInt("nthreshold", d.NThreshold)
+	s.NVisualRadio = getInt("nvisualradio", d.NVisualRadio)
 
-	// Extended field: PHead (if present in custom stack format, default to 1)
-	s.PHead = getInt("phead", 1)
+	// Extended field: PHead (if present in custom stack format)
+	s.PHead = getInt("phead", d.PHead)
 
 	return s
 }
@@ -177,21 +270,38 @@ func parseStationRow(header, row []string) XStation {
 func MergeStationsIntoXFile(xf *XFile, stations []XStation, filename string) int {
 	merged := 0
 
-	// Create map of existing stations by Note
+	// Create maps of existing stations by Note, and by ID for stations
+	// without a Note (which would otherwise all share the empty key)
 	noteToIdx := make(map[string]int)
+	unnamedIDToIdx := make(map[int]int)
 	for i, s := range xf.Stations {
 		if s.Note != "" {
 			noteToIdx[s.Note] = i
+		} else {
+			unnamedIDToIdx[s.ID] = i
 		}
 	}
 
 	// Track which incoming stations matched
 	for _, incoming := range stations {
-		if idx, ok := noteToIdx[incoming.Note]; ok {
+		var idx int
+		var ok bool
+		if incoming.Note != "" {
+			idx, ok = noteToIdx[incoming.Note]
+		} else {
+			idx, ok = unnamedIDToIdx[incoming.ID]
+		}
+
+		if ok {
 			// Update existing station (preserve ID to maintain component links)
-			existingID := xf.Stations[idx].ID
+			existing := xf.Stations[idx]
+			// Don't let an uncalibrated duplicate wipe calibrated coordinates
+			if !incoming.DNP && !existing.DNP && incoming.DeltX == 0 && incoming.DeltY == 0 {
+				incoming.DeltX, incoming.DeltY = existing.DeltX, existing.DeltY
+			}
+			incoming.ID = existing.ID
+			incoming.No = existing.No
 			xf.Stations[idx] = incoming
-			xf.Stations[idx].ID = existingID
 			merged++
 		} else {
 			// Add new station with next available ID
@@ -201,9 +311,17 @@ func MergeStationsIntoXFile(xf *XFile, stations []XStation, filename string) int
 					maxID = s.ID
 				}
 			}
+			originalID := incoming.ID
 			incoming.ID = maxID + 1
 			incoming.No = len(xf.Stations)
 			xf.Stations = append(xf.Stations, incoming)
+
+			// Later duplicates in the same file merge into this station
+			if incoming.Note != "" {
+				noteToIdx[incoming.Note] = len(xf.Stations) - 1
+			} else {
+				unnamedIDToIdx[originalID] = len(xf.Stations) - 1
+			}
 		}
 	}
 
@@ -228,6 +346,7 @@ func MergeStationsIntoXFile(xf *XFile, stations []XStation, filename string) int
 }
 
 // rederiveComponentSTNo updates component STNo. to match Station ID by Note
+// Locked components keep their manually assigned station.
 func rederiveComponentSTNo(xf *XFile) {
 	// Build Note -> ID map
 	noteToID := make(map[string]int)
@@ -239,14 +358,41 @@ func rederiveComponentSTNo(xf *XFile) {
 
 	// Update component STNo. based on Explain (Val) matching Station Note
 	for i := range xf.Components {
+		if xf.Components[i].Locked {
+			continue
+		}
 		if id, ok := noteToID[xf.Components[i].Explain]; ok {
 			xf.Components[i].STNo = id
 		}
 	}
 }
 
+// dnpOnlyStations returns the IDs of stations that are referenced by
+// components, all of which are DNP, so the feeder is never picked from
+func dnpOnlyStations(xf *XFile) map[int]bool {
+	dnpOnly := make(map[int]bool)
+	for _, c := range xf.Components {
+		if c.DNP {
+			if _, seen := dnpOnly[c.STNo]; !seen {
+				dnpOnly[c.STNo] = true
+			}
+		} else {
+			dnpOnly[c.STNo] = false
+		}
+	}
+	for id, only := range dnpOnly {
+		if !only {
+			delete(dnpOnly, id)
+		}
+	}
+	return dnpOnly
+}
+
 // GenerateStack generates a STACK file from XFile stations (for DPV export)
-func GenerateStack(xf *XFile) string {
+// Stations used only by DNP components are left out, so the operator does
+// not load feeders that are never picked from. Coordinates are written with
+// precision decimals (0 for integers).
+func GenerateStack(xf *XFile, precision int) string {
 	var sb strings.Builder
 
 	sb.WriteString("separated\r\n")
@@ -257,16 +403,21 @@ func GenerateStack(xf *XFile) string {
 	// Include PHead column in stack format
 	sb.WriteString("Table,No.,ID,PHead,DeltX,DeltY,FeedRates,Note,Height,Speed,Status,nPixSizeX,nPixSizeY,HeightTake,DelayTake,nPullStripSpeed,nThreshold,nVisualRadio\r\n")
 
-	for i, s := range xf.Stations {
-		if s.DNP {
+	dnpOnly := dnpOnlyStations(xf)
+	idx := 0
+	for _, s := range xf.Stations {
+		if s.DNP || dnpOnly[s.ID] {
 			continue
 		}
-		sb.WriteString(fmt.Sprintf("Station,%d,%d,%d,%.2f,%.2f,%d,%s,%.2f,%d,%d,%d,%d,%.2f,%d,%d,%d,%d\r\n",
-			i, s.ID, s.PHead, s.DeltX, s.DeltY, s.FeedRates, stackCsvEscape(s.Note),
+		sb.WriteString(fmt.Sprintf("Station,%d,%d,%d,%.*f,%.*f,%d,%s,%.2f,%d,%d,%d,%d,%.2f,%d,%d,%d,%d\r\n",
+			idx, s.ID, s.PHead, precision, s.DeltX, precision, s.DeltY, s.FeedRates, stackCsvEscape(s.Note),
 			s.Height, s.Speed, s.Status, s.NPixSizeX, s.NPixSizeY,
 			s.HeightTake, s.DelayTake, s.NPullStripSpeed, s.NThreshold, s.NVisualRadio))
+		idx++
 	}
 
+	writeICTrayTable(&sb, xf, precision)
+
 	return sb.String()
 }
 
@@ -294,19 +445,95 @@ func GenerateStacksFile(xf *XFile) string {
 		idx++
 	}
 
+	writeICTrayTable(&sb, xf, DefaultPrecision)
+
 	return sb.String()
 }
 
-// MergeStacksFile parses a .stacks file and merges into XFile
-// Returns (merged count, added count, error)
-func MergeStacksFile(xf *XFile, content string) (int, int, error) {
-	stations, err := ParseStack(strings.NewReader(content))
+// Ways MergeStacksFile can match incoming stations to existing ones
+const (
+	MatchByNote = "note" // Same part value; the incoming station replaces the existing one
+	MatchByID   = "id"   // Same feeder slot; only the coordinates are copied
+)
+
+// StackConflict is a calibrated station whose position a .stacks import
+// disagreed with
+type StackConflict struct {
+	ID   int     `json:"id"`
+	Note string  `json:"note"`
+	OldX float64 `json:"oldX"`
+	OldY float64 `json:"oldY"`
+	NewX float64 `json:"newX"`
+	NewY float64 `json:"newY"`
+	Kept bool    `json:"kept"` // Old position kept: the incoming one was uncalibrated (0, 0)
+}
+
+// mergeStationPosition copies the incoming position onto an existing
+// station, unless it is uncalibrated (0, 0) and the existing one is not.
+// Differences from a calibrated position are returned as a conflict.
+func mergeStationPosition(existing *XStation, incoming XStation) *StackConflict {
+	if existing.DeltX == 0 && existing.DeltY == 0 {
+		existing.DeltX, existing.DeltY = incoming.DeltX, incoming.DeltY
+		return nil
+	}
+	if existing.DeltX == incoming.DeltX && existing.DeltY == incoming.DeltY {
+		return nil
+	}
+
+	conflict := &StackConflict{
+		ID:   existing.ID,
+		Note: existing.Note,
+		OldX: existing.DeltX,
+		OldY: existing.DeltY,
+		NewX: incoming.DeltX,
+		NewY: incoming.DeltY,
+		Kept: incoming.DeltX == 0 && incoming.DeltY == 0,
+	}
+	if !conflict.Kept {
+		existing.DeltX, existing.DeltY = incoming.DeltX, incoming.DeltY
+	}
+	return conflict
+}
+
+// MergeStacksFile parses a .stacks file and merges into XFile, matching
+// stations by Note or by ID (see MatchByNote, MatchByID)
+// Incoming stations at (0, 0) are uncalibrated and do not replace the
+// position of a calibrated station. Returns (merged count, added count,
+// stations whose calibrated position differed from the incoming one, error).
+func MergeStacksFile(xf *XFile, content string, matchBy string) (int, int, []StackConflict, error) {
+	if matchBy != MatchByNote && matchBy != MatchByID {
+		return 0, 0, nil, fmt.Errorf("invalid matchBy %q (use %q or %q)", matchBy, MatchByNote, MatchByID)
+	}
+
+	data, err := ParseStackFile(strings.NewReader(content))
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, nil, err
 	}
+	stations := data.Stations
+	MergeICTrays(xf, data.ICTrays)
 
 	merged := 0
 	added := 0
+	conflicts := []StackConflict{}
+
+	// Feeder slots are fixed: copy the calibrated pocket position onto the
+	// station in the same slot, whatever part it holds. Unknown slots are
+	// not added since no component uses them.
+	if matchBy == MatchByID {
+		idToIdx := make(map[int]int)
+		for i, s := range xf.Stations {
+			idToIdx[s.ID] = i
+		}
+		for _, incoming := range stations {
+			if idx, ok := idToIdx[incoming.ID]; ok {
+				if c := mergeStationPosition(&xf.Stations[idx], incoming); c != nil {
+					conflicts = append(conflicts, *c)
+				}
+				merged++
+			}
+		}
+		return merged, added, conflicts, nil
+	}
 
 	// Create map of existing stations by Note
 	noteToIdx := make(map[string]int)
@@ -320,11 +547,16 @@ func MergeStacksFile(xf *XFile, content string) (int, int, error) {
 	for _, incoming := range stations {
 		if idx, ok := noteToIdx[incoming.Note]; ok {
 			// Update existing station (preserve ID to maintain component links)
-			existingID := xf.Stations[idx].ID
-			existingNo := xf.Stations[idx].No
+			existing := xf.Stations[idx]
+			c := mergeStationPosition(&existing, incoming)
+			if c != nil {
+				conflicts = append(conflicts, *c)
+			}
 			xf.Stations[idx] = incoming
-			xf.Stations[idx].ID = existingID
-			xf.Stations[idx].No = existingNo
+			xf.Stations[idx].ID = existing.ID
+			xf.Stations[idx].No = existing.No
+			xf.Stations[idx].DeltX = existing.DeltX
+			xf.Stations[idx].DeltY = existing.DeltY
 			merged++
 		} else {
 			// Add new station with next available ID
@@ -344,7 +576,7 @@ func MergeStacksFile(xf *XFile, content string) (int, int, error) {
 	// Re-derive component STNo. based on updated Station Notes
 	rederiveComponentSTNo(xf)
 
-	return merged, added, nil
+	return merged, added, conflicts, nil
 }
 
 // stackCsvEscape escapes a string for CSV output
@@ -354,3 +586,113 @@ func stackCsvEscape(s string) string {
 	}
 	return s
 }
+
+// FeederPosition is a feeder's calibrated pocket position from a feeder CSV
+type FeederPosition struct {
+	Note string  `json:"note"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// ParseFeederCSV parses a simple "Note,X,Y" feeder position spreadsheet.
+// A header row is optional; when present, columns named Note/Value/Name,
+// X/DeltX and Y/DeltY are used wherever they are, so extra columns are
+// ignored. Without a header the first three columns are Note, X and Y.
+// Blank lines and rows without a Note are skipped.
+func ParseFeederCSV(r io.Reader) ([]FeederPosition, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	firstLine, _, _ := strings.Cut(text, "\n")
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = rune(detectDelimiter(firstLine))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	noteCol, xCol, yCol := 0, 1, 2
+	if len(rows) > 0 {
+		header := make(map[string]int)
+		for i, cell := range rows[0] {
+			header[strings.ToLower(strings.TrimSpace(cell))] = i
+		}
+		find := func(names ...string) (int, bool) {
+			for _, name := range names {
+				if i, ok := header[name]; ok {
+					return i, true
+				}
+			}
+			return 0, false
+		}
+		n, hasNote := find("note", "value", "val", "name")
+		x, hasX := find("x", "deltx", "posx")
+		y, hasY := find("y", "delty", "posy")
+		if hasNote && hasX && hasY {
+			noteCol, xCol, yCol = n, x, y
+			rows = rows[1:]
+		} else if len(rows[0]) > yCol {
+			// Any other header row has non-numeric coordinates
+			if _, err := parseFloat(rows[0][xCol]); err != nil {
+				rows = rows[1:]
+			}
+		}
+	}
+
+	var positions []FeederPosition
+	for i, row := range rows {
+		if len(row) <= noteCol || strings.TrimSpace(row[noteCol]) == "" {
+			continue
+		}
+		if len(row) <= xCol || len(row) <= yCol {
+			return nil, fmt.Errorf("row %d: expected Note, X and Y", i+1)
+		}
+		x, err := parseFloat(row[xCol])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid X: %w", i+1, err)
+		}
+		y, err := parseFloat(row[yCol])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid Y: %w", i+1, err)
+		}
+		positions = append(positions, FeederPosition{
+			Note: strings.TrimSpace(row[noteCol]),
+			X:    x,
+			Y:    y,
+		})
+	}
+
+	return positions, nil
+}
+
+// MergeFeederPositions copies feeder positions onto the stations with the
+// same Note, following the same rules as a .stacks import. Returns the
+// number of stations updated, the Notes matching no station, and the
+// conflicts with calibrated positions.
+func MergeFeederPositions(xf *XFile, positions []FeederPosition) (int, []string, []StackConflict) {
+	merged := 0
+	unmatched := []string{}
+	conflicts := []StackConflict{}
+	for _, p := range positions {
+		found := false
+		for i := range xf.Stations {
+			if xf.Stations[i].Note != p.Note {
+				continue
+			}
+			found = true
+			if c := mergeStationPosition(&xf.Stations[i], XStation{DeltX: p.X, DeltY: p.Y}); c != nil {
+				conflicts = append(conflicts, *c)
+			}
+			merged++
+		}
+		if !found {
+			unmatched = append(unmatched, p.Note)
+		}
+	}
+	return merged, unmatched, conflicts
+}
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStackICTrayRoundTrip(t *testing.T) {
+	xf := testBoard()
+	tray := ICTrayRow{No: 0, ID: 71, CenterX: 210.5, CenterY: 30.25, IntervalX: 12, IntervalY: 10.5, NumX: 5, NumY: 4, Start: 3}
+	xf.ICTrays = []ICTrayRow{tray}
+
+	data, err := ParseStackFile(strings.NewReader(GenerateStack(xf, DefaultPrecision)))
+	if err != nil {
+		t.Fatalf("ParseStackFile: %v", err)
+	}
+	if len(data.Stations) != len(xf.Stations) {
+		t.Errorf("got %d stations, want %d", len(data.Stations), len(xf.Stations))
+	}
+	if len(data.ICTrays) != 1 || data.ICTrays[0] != tray {
+		t.Fatalf("ICTrays %+v, want [%+v]", data.ICTrays, tray)
+	}
+
+	merged := testBoard()
+	MergeICTrays(merged, data.ICTrays)
+	dpv, err := GenerateDPV(merged, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	rows := dpvRows(dpv, "ICTray")
+	if len(rows) != 1 || rows[0] != "ICTray,0,71,210.50,30.25,12.00,10.50,5,4,3" {
+		t.Errorf("DPV ICTray rows %q", rows)
+	}
+}
+
+func TestMergeStationsEmptyNotes(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{No: 0, ID: 5, DeltX: 10, DeltY: 10},
+		{No: 1, ID: 6, DeltX: 20, DeltY: 10},
+	}
+
+	merged := MergeStationsIntoXFile(xf, []XStation{
+		{ID: 6, DeltX: 25, DeltY: 12},
+		{ID: 9, DeltX: 40, DeltY: 12},
+	}, "feeders.stack")
+
+	if merged != 1 {
+		t.Errorf("merged %d stations, want 1", merged)
+	}
+	if len(xf.Stations) != 3 {
+		t.Fatalf("got %d stations, want 3: %+v", len(xf.Stations), xf.Stations)
+	}
+	if s := xf.Stations[0]; s.ID != 5 || s.DeltX != 10 {
+		t.Errorf("station 5 changed to %+v", s)
+	}
+	if s := xf.Stations[1]; s.ID != 6 || s.DeltX != 25 || s.DeltY != 12 {
+		t.Errorf("station 6 = %+v, want moved to (25, 12)", s)
+	}
+	if s := xf.Stations[2]; s.ID != 7 || s.DeltX != 40 {
+		t.Errorf("new station = %+v, want ID 7 at x 40", s)
+	}
+}
+
+func TestMergeStationsConflictingCoordinates(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{No: 0, ID: 1, Note: "10k", DeltX: 100, DeltY: 50},
+		{No: 1, ID: 2, Note: "100nF", DeltX: 120, DeltY: 50},
+	}
+
+	// Calibrated positions win over (0, 0), in either order
+	MergeStationsIntoXFile(xf, []XStation{
+		{ID: 1, Note: "10k"},
+		{ID: 2, Note: "100nF", DeltX: 130, DeltY: 60},
+		{ID: 3, Note: "1uF"},
+		{ID: 4, Note: "1uF", DeltX: 140, DeltY: 50},
+		{ID: 5, Note: "4.7uF", DeltX: 160, DeltY: 50},
+		{ID: 6, Note: "4.7uF"},
+	}, "feeders.stack")
+
+	want := map[string][2]float64{
+		"10k":   {100, 50},
+		"100nF": {130, 60},
+		"1uF":   {140, 50},
+		"4.7uF": {160, 50},
+	}
+	if len(xf.Stations) != len(want) {
+		t.Fatalf("got %d stations, want %d: %+v", len(xf.Stations), len(want), xf.Stations)
+	}
+	for _, s := range xf.Stations {
+		if w := want[s.Note]; s.DeltX != w[0] || s.DeltY != w[1] {
+			t.Errorf("station %s at (%v, %v), want (%v, %v)", s.Note, s.DeltX, s.DeltY, w[0], w[1])
+		}
+	}
+}
+
+func TestMergeStacksFileByID(t *testing.T) {
+	// Slots 1 and 2 were calibrated while holding other parts
+	calibrated := NewXFile()
+	calibrated.Stations = []XStation{
+		{No: 0, ID: 1, Note: "4.7k", DeltX: 101.5, DeltY: 52.25, FeedRates: 4},
+		{No: 1, ID: 2, Note: "1uF", DeltX: 121.5, DeltY: 52.25, FeedRates: 4},
+		{No: 2, ID: 9, Note: "LED", DeltX: 180, DeltY: 52.25, FeedRates: 4},
+	}
+	content := GenerateStacksFile(calibrated)
+
+	xf := testBoard()
+	before := append([]XStation(nil), xf.Stations...)
+	merged, added, conflicts, err := MergeStacksFile(xf, content, MatchByID)
+	if err != nil {
+		t.Fatalf("MergeStacksFile: %v", err)
+	}
+	if merged != 2 || added != 0 {
+		t.Errorf("merged %d, added %d; want 2 merged (slot 9 is unused), 0 added", merged, added)
+	}
+	if len(xf.Stations) != len(before) {
+		t.Fatalf("got %d stations, want %d", len(xf.Stations), len(before))
+	}
+
+	for i, s := range xf.Stations {
+		want := before[i]
+		for _, c := range calibrated.Stations {
+			if c.ID == want.ID {
+				want.DeltX, want.DeltY = c.DeltX, c.DeltY
+			}
+		}
+		// Only the position moves; the part loaded in the slot stays
+		if s != want {
+			t.Errorf("station %d = %+v, want %+v", s.ID, s, want)
+		}
+	}
+	// Both slots were already calibrated, so the moves are reported
+	if len(conflicts) != 2 || conflicts[0].Kept || conflicts[1].Kept {
+		t.Errorf("conflicts %+v, want stations 1 and 2 moved", conflicts)
+	}
+
+	// Matching by Note finds nothing in common and adds the stations instead
+	xf = testBoard()
+	if merged, added, _, _ := MergeStacksFile(xf, content, MatchByNote); merged != 0 || added != 3 {
+		t.Errorf("by note: merged %d, added %d; want 0, 3", merged, added)
+	}
+
+	if _, _, _, err := MergeStacksFile(testBoard(), content, "slot"); err == nil {
+		t.Error("expected an error for an unknown matchBy")
+	}
+}
+
+func TestGenerateStackSkipsDNPOnlyStations(t *testing.T) {
+	xf := testBoard()
+	// Station 3's only part is DNP; station 4 has no parts at all
+	xf.Stations = append(xf.Stations,
+		XStation{No: 2, ID: 3, DeltX: 140, DeltY: 50, FeedRates: 4, Note: "1uF", Height: 0.5, Speed: 100, Status: 6, PHead: 1},
+		XStation{No: 3, ID: 4, DeltX: 160, DeltY: 50, FeedRates: 4, Note: "spare", Height: 0.5, Speed: 100, Status: 6, PHead: 1},
+	)
+	xf.Components = append(xf.Components, XComponent{No: 3, ID: 4, PHead: 1, STNo: 3, DeltX: 40, DeltY: 10,
+		Height: 0.5, Skip: 6, Speed: 100, Explain: "1uF", Note: "C2 - C_0603", Side: "top", DNP: true})
+
+	data, err := ParseStackFile(strings.NewReader(GenerateStack(xf, DefaultPrecision)))
+	if err != nil {
+		t.Fatalf("ParseStackFile: %v", err)
+	}
+	var ids []int
+	for _, s := range data.Stations {
+		ids = append(ids, s.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 4}) {
+		t.Errorf("STACK station IDs %v, want [1 2 4] (unused stations stay for later jobs)", ids)
+	}
+
+	res := ValidateDPV(xf, "board.dpv")
+	issue := findIssue(res.Warnings, "dnp_only_station")
+	if issue == nil || !strings.Contains(issue.Message, "1uF") {
+		t.Errorf("dnp_only_station warning %+v", issue)
+	}
+	for _, w := range res.Warnings {
+		if w.Type == "dnp_only_station" && strings.Contains(w.Message, "spare") {
+			t.Errorf("station with no parts reported as DNP-only: %+v", w)
+		}
+	}
+
+	// Making the part placeable brings the feeder back
+	xf.Components[3].DNP = false
+	if out := GenerateStack(xf, DefaultPrecision); !strings.Contains(out, ",1uF,") {
+		t.Errorf("STACK without the 1uF station once C2 is placed:\n%s", out)
+	}
+	if findIssue(ValidateDPV(xf, "board.dpv").Warnings, "dnp_only_station") != nil {
+		t.Error("dnp_only_station warning once C2 is placed")
+	}
+}
+
+func TestMergeStacksFileKeepsCalibration(t *testing.T) {
+	incoming := NewXFile()
+	incoming.Stations = []XStation{
+		{No: 0, ID: 1, Note: "10k", FeedRates: 2, Height: 0.4, PHead: 2},                  // uncalibrated
+		{No: 1, ID: 2, Note: "100nF", DeltX: 125.5, DeltY: 51, FeedRates: 4, Height: 0.5}, // recalibrated
+	}
+	content := GenerateStacksFile(incoming)
+
+	xf := testBoard()
+	xf.Stations = append(xf.Stations, XStation{No: 2, ID: 3, Note: "1uF", FeedRates: 4})
+	merged, added, conflicts, err := MergeStacksFile(xf, content, MatchByNote)
+	if err != nil {
+		t.Fatalf("MergeStacksFile: %v", err)
+	}
+	if merged != 2 || added != 0 {
+		t.Errorf("merged %d, added %d; want 2, 0", merged, added)
+	}
+
+	// Zeros do not clobber the calibrated 10k feeder, but its other
+	// settings still come from the file
+	if s := xf.Stations[0]; s.DeltX != 100 || s.DeltY != 50 || s.FeedRates != 2 || s.PHead != 2 {
+		t.Errorf("10k station %+v, want (100, 50) kept with FeedRates 2 and PHead 2", s)
+	}
+	if s := xf.Stations[1]; s.DeltX != 125.5 || s.DeltY != 51 {
+		t.Errorf("100nF station at (%v, %v), want (125.5, 51)", s.DeltX, s.DeltY)
+	}
+
+	want := []StackConflict{
+		{ID: 1, Note: "10k", OldX: 100, OldY: 50, NewX: 0, NewY: 0, Kept: true},
+		{ID: 2, Note: "100nF", OldX: 120, OldY: 50, NewX: 125.5, NewY: 51, Kept: false},
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("conflicts %+v, want %+v", conflicts, want)
+	}
+
+	// An uncalibrated station takes any incoming position without a conflict
+	content = GenerateStacksFile(&XFile{Stations: []XStation{{ID: 1, Note: "1uF", DeltX: 140, DeltY: 50, FeedRates: 4}}})
+	_, _, conflicts, err = MergeStacksFile(xf, content, MatchByNote)
+	if err != nil {
+		t.Fatalf("MergeStacksFile: %v", err)
+	}
+	if s := xf.Stations[2]; s.DeltX != 140 || s.DeltY != 50 || len(conflicts) != 0 {
+		t.Errorf("1uF station at (%v, %v) with conflicts %+v, want (140, 50) and none", s.DeltX, s.DeltY, conflicts)
+	}
+}
+
+func TestParseFeederCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{
+			name: "no header",
+			csv:  "10k,101.5,52\n100nF,121.5,52\nLED,180,52\n",
+		},
+		{
+			name: "header with extra columns",
+			csv:  "Slot,Value,Qty,X,Y\n1,10k,500,101.5,52\n2,100nF,200,121.5,52\n3,LED,50,180,52\n",
+		},
+		{
+			name: "other header, semicolons and decimal commas",
+			csv:  "Part;PosX mm;PosY mm\n10k;101,5;52\n100nF;121,5;52\nLED;180;52\n",
+		},
+	}
+	want := []FeederPosition{{"10k", 101.5, 52}, {"100nF", 121.5, 52}, {"LED", 180, 52}}
+
+	for _, tt := range tests {
+		positions, err := ParseFeederCSV(strings.NewReader(tt.csv))
+		if err != nil {
+			t.Errorf("%s: ParseFeederCSV: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(positions, want) {
+			t.Errorf("%s: positions %+v, want %+v", tt.name, positions, want)
+		}
+	}
+
+	if _, err := ParseFeederCSV(strings.NewReader("10k,101.5,52\n100nF,abc,52\n")); err == nil {
+		t.Error("expected an error for a non-numeric X")
+	}
+}
+
+func TestMergeFeederPositions(t *testing.T) {
+	positions, err := ParseFeederCSV(strings.NewReader("Note,X,Y\n10k,101.5,52\n100nF,0,0\nLED,180,52\n"))
+	if err != nil {
+		t.Fatalf("ParseFeederCSV: %v", err)
+	}
+
+	xf := testBoard()
+	merged, unmatched, conflicts := MergeFeederPositions(xf, positions)
+	if merged != 2 {
+		t.Errorf("merged %d, want 2", merged)
+	}
+	if !reflect.DeepEqual(unmatched, []string{"LED"}) {
+		t.Errorf("unmatched %v, want [LED]", unmatched)
+	}
+	if s := xf.Stations[0]; s.DeltX != 101.5 || s.DeltY != 52 {
+		t.Errorf("10k station at (%v, %v), want (101.5, 52)", s.DeltX, s.DeltY)
+	}
+	// The blank 100nF row keeps the calibrated position
+	if s := xf.Stations[1]; s.DeltX != 120 || s.DeltY != 50 {
+		t.Errorf("100nF station at (%v, %v), want (120, 50) kept", s.DeltX, s.DeltY)
+	}
+	if len(conflicts) != 2 || conflicts[0].Kept || !conflicts[1].Kept {
+		t.Errorf("conflicts %+v, want 10k moved and 100nF kept", conflicts)
+	}
+}
+
+func TestMergeKeepsLockedSTNo(t *testing.T) {
+	stations := []XStation{
+		{ID: 1, Note: "10k", DeltX: 101, DeltY: 50, FeedRates: 4},
+		{ID: 2, Note: "100nF", DeltX: 121, DeltY: 50, FeedRates: 4},
+	}
+	stacks := NewXFile()
+	stacks.Stations = stations
+
+	merges := []struct {
+		name  string
+		merge func(xf *XFile)
+	}{
+		{"STACK file", func(xf *XFile) { MergeStationsIntoXFile(xf, stations, "feeders.stack") }},
+		{".stacks file", func(xf *XFile) {
+			if _, _, _, err := MergeStacksFile(xf, GenerateStacksFile(stacks), MatchByNote); err != nil {
+				t.Fatalf("MergeStacksFile: %v", err)
+			}
+		}},
+	}
+	for _, m := range merges {
+		// R2 was moved onto the 100nF feeder's slot by hand and locked;
+		// R1 was moved the same way without locking
+		xf := testBoard()
+		xf.Components[0].STNo = 2
+		xf.Components[1].STNo, xf.Components[1].Locked = 2, true
+
+		m.merge(xf)
+		if got := xf.Components[1].STNo; got != 2 {
+			t.Errorf("%s: locked R2 STNo %d, want 2", m.name, got)
+		}
+		if got := xf.Components[0].STNo; got != 1 {
+			t.Errorf("%s: unlocked R1 STNo %d, want re-derived 1", m.name, got)
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Reel feeder banks on the CHM-T48VB (see ValidateDPV for the full ID map)
+var (
+	leftReelSlots  = slotRange(1, 29)
+	rightReelSlots = slotRange(36, 64)
+)
+
+// slotRange returns the station IDs from first to last inclusive
+func slotRange(first, last int) []int {
+	ids := make([]int, 0, last-first+1)
+	for id := first; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// slotsFrom returns the slots with an ID of at least startID that are not
+// in taken
+func slotsFrom(slots []int, startID int, taken map[int]bool) []int {
+	var free []int
+	for _, id := range slots {
+		if id >= startID && !taken[id] {
+			free = append(free, id)
+		}
+	}
+	return free
+}
+
+// isReelStation reports whether a station takes part in reel slot
+// assignment: DNP stations and the front tray, vibratory and IC tray
+// positions (71-99) keep their IDs
+func isReelStation(s XStation) bool {
+	return !s.DNP && (s.ID < 71 || s.ID > 99)
+}
+
+// AssignFeederSlots distributes the active reel stations across the left
+// (1-29) and right (36-64) reel banks, alternating banks so both sides are
+// used evenly. Component STNo. references are updated to follow their
+// station. Tray and vibratory stations (71-99), and so the ICTray table,
+// are left alone, as are DNP stations, whose IDs stay reserved.
+// When balanceHeads is set, left bank stations use PHead 1 and right bank
+// stations use PHead 2, and matching components are updated too.
+// Slots below startID are reserved (e.g. for feeders kept loaded with
+// common parts) and left free.
+func AssignFeederSlots(xf *XFile, balanceHeads bool, startID int) error {
+	// Slots held by stations that keep their IDs
+	taken := make(map[int]bool)
+	var reel []int // Indexes of the stations to assign
+	for i, s := range xf.Stations {
+		if isReelStation(s) {
+			reel = append(reel, i)
+		} else {
+			taken[s.ID] = true
+		}
+	}
+
+	leftReelSlots := slotsFrom(leftReelSlots, startID, taken)
+	rightReelSlots := slotsFrom(rightReelSlots, startID, taken)
+
+	available := len(leftReelSlots) + len(rightReelSlots)
+	if len(reel) > available {
+		return fmt.Errorf("%d stations exceed the %d available reel slots from ID %d", len(reel), available, max(startID, 1))
+	}
+
+	oldToNew := make(map[int]int)
+	left, right := 0, 0
+	for n, i := range reel {
+		s := &xf.Stations[i]
+
+		// Alternate banks, spilling into whichever bank still has room
+		useLeft := (n%2 == 0 && left < len(leftReelSlots)) || right >= len(rightReelSlots)
+		var newID, head int
+		if useLeft {
+			newID, head = leftReelSlots[left], 1
+			left++
+		} else {
+			newID, head = rightReelSlots[right], 2
+			right++
+		}
+
+		oldToNew[s.ID] = newID
+		s.ID = newID
+		if balanceHeads {
+			s.PHead = head
+		}
+	}
+
+	headByStation := make(map[int]int)
+	for i := range xf.Stations {
+		xf.Stations[i].No = i
+		headByStation[xf.Stations[i].ID] = xf.Stations[i].PHead
+	}
+
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		if id, ok := oldToNew[c.STNo]; ok {
+			c.STNo = id
+			if balanceHeads {
+				c.PHead = headByStation[id]
+			}
+		}
+	}
+
+	return nil
+}
+
+// DefaultHeadRules maps package name fragments to a nozzle. Small and
+// fine-pitch parts stay on PHead 1; larger packages move to PHead 2 so both
+// nozzles share the load.
+var DefaultHeadRules = map[string]int{
+	"0201":    1,
+	"0402":    1,
+	"0603":    1,
+	"0805":    1,
+	"QFN":     1,
+	"TSSOP":   1,
+	"QFP":     1,
+	"1206":    2,
+	"1210":    2,
+	"2512":    2,
+	"SOIC":    2,
+	"SOT-223": 2,
+	"DPAK":    2,
+	"TO-252":  2,
+	"CP_Elec": 2,
+}
+
+// headTwoMinHeight is the part height (mm) at and above which PHead 2 is used
+const headTwoMinHeight = 2.0
+
+// AssignHeads assigns a nozzle to each station from its components'
+// package names using rules (package fragment -> PHead, longest match
+// wins), or PHead 2 for tall parts, and syncs the matching components.
+// Returns the number of stations whose PHead changed.
+func AssignHeads(xf *XFile, rules map[string]int) int {
+	// First package seen for each station
+	stationPackage := make(map[int]string)
+	for _, c := range xf.Components {
+		if _, ok := stationPackage[c.STNo]; !ok {
+			stationPackage[c.STNo] = componentPackage(c)
+		}
+	}
+
+	changed := 0
+	stationHead := make(map[int]int)
+	for i := range xf.Stations {
+		s := &xf.Stations[i]
+		head := headForPackage(stationPackage[s.ID], rules)
+		if s.Height >= headTwoMinHeight {
+			head = 2
+		}
+		if s.PHead != head {
+			s.PHead = head
+			changed++
+		}
+		stationHead[s.ID] = head
+	}
+
+	for i := range xf.Components {
+		if head, ok := stationHead[xf.Components[i].STNo]; ok {
+			xf.Components[i].PHead = head
+		}
+	}
+
+	return changed
+}
+
+// headForPackage returns the PHead for the longest rule matching pkg,
+// defaulting to PHead 1
+func headForPackage(pkg string, rules map[string]int) int {
+	return matchPackageRule(pkg, rules, 1)
+}
+
+// matchPackageRule returns the value of the longest rule fragment found in
+// pkg (case-insensitive), or def if no rule matches
+func matchPackageRule[T any](pkg string, rules map[string]T, def T) T {
+	if v, ok := lookupPackageRule(pkg, rules); ok {
+		return v
+	}
+	return def
+}
+
+// lookupPackageRule returns the value of the longest rule fragment found in
+// pkg (case-insensitive) and whether any rule matched
+func lookupPackageRule[T any](pkg string, rules map[string]T) (T, bool) {
+	pkg = strings.ToLower(pkg)
+	var value T
+	bestLen := 0
+	for fragment, v := range rules {
+		if len(fragment) > bestLen && strings.Contains(pkg, strings.ToLower(fragment)) {
+			value, bestLen = v, len(fragment)
+		}
+	}
+	return value, bestLen > 0
+}
+
+// DefaultFeedRateRules maps package name fragments to a FeedRates value
+// (tape advance in mm). Tiny chips sit on a 2mm pitch, big ICs on 8mm;
+// anything unmatched uses defaultFeedRate.
+var DefaultFeedRateRules = map[string]int{
+	"0201":    2,
+	"0402":    2,
+	"0603":    4,
+	"0805":    4,
+	"1206":    4,
+	"SOT-23":  4,
+	"SOD-123": 4,
+	"SOIC":    8,
+	"TSSOP":   8,
+	"QFN":     8,
+	"QFP":     8,
+	"SOT-223": 8,
+	"DPAK":    8,
+	"TO-252":  8,
+	"CP_Elec": 8,
+}
+
+// defaultFeedRate is the FeedRates used when no rule matches a package
+const defaultFeedRate = 4
+
+// SuggestFeedRates sets each station's FeedRates from its components'
+// package names using rules (package fragment -> FeedRates, longest match
+// wins). Returns a warning for every station whose FeedRates changed.
+func SuggestFeedRates(xf *XFile, rules map[string]int) []string {
+	// First package seen for each station
+	stationPackage := make(map[int]string)
+	for _, c := range xf.Components {
+		if _, ok := stationPackage[c.STNo]; !ok {
+			stationPackage[c.STNo] = componentPackage(c)
+		}
+	}
+
+	var warnings []string
+	for i := range xf.Stations {
+		s := &xf.Stations[i]
+		pkg, ok := stationPackage[s.ID]
+		if !ok || pkg == "" {
+			continue
+		}
+		rate := matchPackageRule(pkg, rules, defaultFeedRate)
+		if s.FeedRates != rate {
+			warnings = append(warnings, fmt.Sprintf("Station %d (%s, %s): FeedRates %d changed to %d",
+				s.ID, s.Note, pkg, s.FeedRates, rate))
+			s.FeedRates = rate
+		}
+	}
+
+	return warnings
+}
+
+// DefaultDelayRules maps package name fragments to a pickup delay in
+// centiseconds. Tall parts and parts that stick to the tape need the nozzle
+// to dwell before lifting; unmatched packages keep their current delay.
+var DefaultDelayRules = map[string]int{
+	"CP_Elec":   20,
+	"Elec":      20,
+	"Connector": 30,
+	"USB":       30,
+	"PinHeader": 30,
+	"JST":       30,
+	"Crystal":   10,
+	"Inductor":  10,
+	"Button":    20,
+	"SW_":       20,
+}
+
+// SuggestDelays sets each station's DelayTake, and the Delay of its
+// components, from their package names using rules (package fragment ->
+// delay in centiseconds, longest match wins). Stations with no matching
+// rule are left unchanged. Returns the number of stations changed.
+func SuggestDelays(xf *XFile, rules map[string]int) int {
+	// First package seen for each station
+	stationPackage := make(map[int]string)
+	for _, c := range xf.Components {
+		if _, ok := stationPackage[c.STNo]; !ok {
+			stationPackage[c.STNo] = componentPackage(c)
+		}
+	}
+
+	changed := 0
+	stationDelay := make(map[int]int)
+	for i := range xf.Stations {
+		s := &xf.Stations[i]
+		delay := matchPackageRule(stationPackage[s.ID], rules, -1)
+		if delay < 0 {
+			continue
+		}
+		if s.DelayTake != delay {
+			s.DelayTake = delay
+			changed++
+		}
+		stationDelay[s.ID] = delay
+	}
+
+	for i := range xf.Components {
+		if delay, ok := stationDelay[xf.Components[i].STNo]; ok {
+			xf.Components[i].Delay = delay
+		}
+	}
+
+	return changed
+}
+
+// VisionSettings are the Station vision parameters tuned per package size
+type VisionSettings struct {
+	NThreshold   int `json:"nthreshold"`
+	NVisualRadio int `json:"nvisualradio"`
+	NPixSizeX    int `json:"npixsizex"`
+	NPixSizeY    int `json:"npixsizey"`
+}
+
+// Validate reports the first vision setting that would fail ValidateDPV
+func (v VisionSettings) Validate() error {
+	if v.NThreshold < 0 || v.NThreshold > 256 {
+		return fmt.Errorf("nthreshold %d must be 0 (default) or 1-256", v.NThreshold)
+	}
+	if v.NVisualRadio < 0 {
+		return fmt.Errorf("nvisualradio %d cannot be negative", v.NVisualRadio)
+	}
+	if v.NPixSizeX < 0 || v.NPixSizeY < 0 {
+		return fmt.Errorf("npixsizex/npixsizey %dx%d cannot be negative", v.NPixSizeX, v.NPixSizeY)
+	}
+	return nil
+}
+
+// DefaultVisionRules maps package name fragments to vision settings by part
+// size. Tiny chips need a lower threshold and tighter ratio to be found
+// against the tape, large ICs a higher threshold and a bigger pixel window.
+// These are starting points to fine-tune on the machine.
+var DefaultVisionRules = map[string]VisionSettings{
+	"0201":    {NThreshold: 90, NVisualRadio: 150, NPixSizeX: 12, NPixSizeY: 6},
+	"0402":    {NThreshold: 100, NVisualRadio: 180, NPixSizeX: 20, NPixSizeY: 10},
+	"0603":    {NThreshold: 110, NVisualRadio: 200, NPixSizeX: 32, NPixSizeY: 16},
+	"0805":    {NThreshold: 110, NVisualRadio: 200, NPixSizeX: 40, NPixSizeY: 25},
+	"1206":    {NThreshold: 120, NVisualRadio: 200, NPixSizeX: 64, NPixSizeY: 32},
+	"SOT-23":  {NThreshold: 120, NVisualRadio: 200, NPixSizeX: 58, NPixSizeY: 26},
+	"SOD-123": {NThreshold: 120, NVisualRadio: 200, NPixSizeX: 54, NPixSizeY: 32},
+	"SOIC":    {NThreshold: 130, NVisualRadio: 220, NPixSizeX: 100, NPixSizeY: 80},
+	"TSSOP":   {NThreshold: 130, NVisualRadio: 220, NPixSizeX: 90, NPixSizeY: 60},
+	"QFN":     {NThreshold: 140, NVisualRadio: 220, NPixSizeX: 80, NPixSizeY: 80},
+	"QFP":     {NThreshold: 140, NVisualRadio: 240, NPixSizeX: 140, NPixSizeY: 140},
+}
+
+// TuneVision sets each station's NThreshold, NVisualRadio and NPixSizeX/Y
+// from its components' package names using rules (package fragment ->
+// settings, longest match wins). Stations with no matching rule are left
+// unchanged. Returns the number of stations changed.
+func TuneVision(xf *XFile, rules map[string]VisionSettings) int {
+	// First package seen for each station
+	stationPackage := make(map[int]string)
+	for _, c := range xf.Components {
+		if _, ok := stationPackage[c.STNo]; !ok {
+			stationPackage[c.STNo] = componentPackage(c)
+		}
+	}
+
+	changed := 0
+	for i := range xf.Stations {
+		s := &xf.Stations[i]
+		pkg, ok := stationPackage[s.ID]
+		if !ok || pkg == "" {
+			continue
+		}
+		v, ok := lookupPackageRule(pkg, rules)
+		if !ok {
+			continue
+		}
+		current := VisionSettings{
+			NThreshold:   s.NThreshold,
+			NVisualRadio: s.NVisualRadio,
+			NPixSizeX:    s.NPixSizeX,
+			NPixSizeY:    s.NPixSizeY,
+		}
+		if current == v {
+			continue
+		}
+		s.NThreshold = v.NThreshold
+		s.NVisualRadio = v.NVisualRadio
+		s.NPixSizeX = v.NPixSizeX
+		s.NPixSizeY = v.NPixSizeY
+		changed++
+	}
+
+	return changed
+}
+
+// DefaultNoVisionPackages are package name fragments of large parts, mostly
+// connectors, that vision centering tends to reject; operators place them
+// with vision off
+var DefaultNoVisionPackages = []string{
+	"Connector",
+	"PinHeader",
+	"PinSocket",
+	"USB",
+	"JST",
+	"Molex",
+	"TerminalBlock",
+}
+
+// DisableVisionForPackages clears the vision flag (bit 4) on the Skip of every
+// component whose package contains one of packages (case-insensitive) and on
+// the Status of its station. The station's nThreshold/nVisualRadio are reset
+// so validation does not flag them as unused; since the station no longer
+// has vision, GenerateDPV leaves the component flags clear. Returns the
+// number of components and stations changed.
+func DisableVisionForPackages(xf *XFile, packages []string) (int, int) {
+	rules := make(map[string]bool, len(packages))
+	for _, p := range packages {
+		if p = strings.TrimSpace(p); p != "" {
+			rules[p] = true
+		}
+	}
+
+	components := 0
+	stationIDs := make(map[int]bool)
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		if _, ok := lookupPackageRule(componentPackage(*c), rules); !ok {
+			continue
+		}
+		stationIDs[c.STNo] = true
+		if c.Skip&4 != 0 {
+			c.Skip &^= 4
+			components++
+		}
+	}
+
+	stations := 0
+	for i := range xf.Stations {
+		s := &xf.Stations[i]
+		if !stationIDs[s.ID] {
+			continue
+		}
+		if s.Status&4 != 0 || s.NThreshold != 0 || s.NVisualRadio != 0 {
+			s.Status &^= 4
+			s.NThreshold = 0
+			s.NVisualRadio = 0
+			stations++
+		}
+	}
+
+	return components, stations
+}
+
+// StationSummary describes a station and the components assigned to it
+type StationSummary struct {
+	ID    int      `json:"id"`
+	Note  string   `json:"note"`
+	DeltX float64  `json:"deltx"`
+	DeltY float64  `json:"delty"`
+	DNP   bool     `json:"dnp"`
+	Refs  []string `json:"refs"` // Refs of the components using this station
+}
+
+// SummarizeStations returns the stations in table order, each with the refs
+// of the components assigned to it
+func SummarizeStations(xf *XFile) []StationSummary {
+	refs := make(map[int][]string)
+	for _, c := range xf.Components {
+		refs[c.STNo] = append(refs[c.STNo], componentRef(c))
+	}
+
+	summaries := make([]StationSummary, 0, len(xf.Stations))
+	for _, s := range xf.Stations {
+		stationRefs := refs[s.ID]
+		if stationRefs == nil {
+			stationRefs = []string{}
+		}
+		summaries = append(summaries, StationSummary{
+			ID:    s.ID,
+			Note:  s.Note,
+			DeltX: s.DeltX,
+			DeltY: s.DeltY,
+			DNP:   s.DNP,
+			Refs:  stationRefs,
+		})
+	}
+	return summaries
+}
+
+// MergeStations moves all components of the station with Note fromNote onto
+// the station with Note toNote (e.g. "0.1uF" onto "100nF"), removes the
+// emptied station and renumbers Station No.
+// Returns the number of components reassigned.
+func MergeStations(xf *XFile, fromNote, toNote string) (int, error) {
+	fromIdx, toIdx := -1, -1
+	for i, s := range xf.Stations {
+		if s.Note == fromNote && fromIdx == -1 {
+			fromIdx = i
+		}
+		if s.Note == toNote && toIdx == -1 {
+			toIdx = i
+		}
+	}
+	if fromIdx == -1 {
+		return 0, fmt.Errorf("station not found: %q", fromNote)
+	}
+	if toIdx == -1 {
+		return 0, fmt.Errorf("station not found: %q", toNote)
+	}
+	if fromIdx == toIdx {
+		return 0, fmt.Errorf("cannot merge station %q into itself", fromNote)
+	}
+
+	fromID := xf.Stations[fromIdx].ID
+	to := xf.Stations[toIdx]
+
+	moved := 0
+	for i := range xf.Components {
+		c := &xf.Components[i]
+		if c.STNo == fromID || c.Explain == fromNote {
+			c.STNo = to.ID
+			c.Explain = to.Note
+			moved++
+		}
+	}
+
+	xf.Stations = append(xf.Stations[:fromIdx], xf.Stations[fromIdx+1:]...)
+	for i := range xf.Stations {
+		xf.Stations[i].No = i
+	}
+
+	return moved, nil
+}
+
+// ReorderStations puts the Station table in the order given by ids (e.g.
+// the physical feeder layout) and renumbers Station No. to match. IDs are
+// kept, so component STNo. references are unaffected. ids must list every
+// station exactly once.
+func ReorderStations(xf *XFile, ids []int) error {
+	if len(ids) != len(xf.Stations) {
+		return fmt.Errorf("got %d station IDs for %d stations", len(ids), len(xf.Stations))
+	}
+
+	byID := make(map[int]XStation)
+	for _, s := range xf.Stations {
+		if _, dup := byID[s.ID]; dup {
+			return fmt.Errorf("station ID %d is not unique", s.ID)
+		}
+		byID[s.ID] = s
+	}
+
+	reordered := make([]XStation, 0, len(ids))
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		s, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("station not found: %d", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("station %d listed more than once", id)
+		}
+		seen[id] = true
+		s.No = len(reordered)
+		reordered = append(reordered, s)
+	}
+
+	xf.Stations = reordered
+	return nil
+}
+
+// SetStationCoord sets the feeder position (DeltX/DeltY) of the station with
+// the given ID
+func SetStationCoord(xf *XFile, id int, x, y float64) error {
+	for i := range xf.Stations {
+		if xf.Stations[i].ID == id {
+			xf.Stations[i].DeltX = x
+			xf.Stations[i].DeltY = y
+			return nil
+		}
+	}
+	return fmt.Errorf("station not found: %d", id)
+}
+
+// StationDefaults are the parameters given to stations created from a POS
+// file, and to STACK file rows that leave a column out
+type StationDefaults struct {
+	FeedRates       int     `json:"feedrates"`
+	Height          float64 `json:"height"`
+	Speed           int     `json:"speed"`
+	Status          int     `json:"status"`
+	DelayTake       int     `json:"delaytake"`
+	NPullStripSpeed int     `json:"npullstripspeed"`
+	NThreshold      int     `json:"nthreshold"`
+	NVisualRadio    int     `json:"nvisualradio"`
+	PHead           int     `json:"phead"`
+}
+
+// BuiltinStationDefaults are the station defaults used unless
+// SetStationDefaults replaces them
+var BuiltinStationDefaults = StationDefaults{
+	FeedRates:       4,
+	Height:          0.5,
+	Speed:           0,
+	Status:          4, // Vision enabled
+	DelayTake:       10,
+	NPullStripSpeed: 85,
+	NThreshold:      110,
+	NVisualRadio:    200,
+	PHead:           1,
+}
+
+var (
+	stationDefaultsMu sync.RWMutex
+	stationDefaults   = BuiltinStationDefaults
+)
+
+// CurrentStationDefaults returns the station defaults in effect
+func CurrentStationDefaults() StationDefaults {
+	stationDefaultsMu.RLock()
+	defer stationDefaultsMu.RUnlock()
+	return stationDefaults
+}
+
+// SetStationDefaults replaces the station defaults used for new stations
+// after checking them against the same limits as ValidateDPV
+func SetStationDefaults(d StationDefaults) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	stationDefaultsMu.Lock()
+	defer stationDefaultsMu.Unlock()
+	stationDefaults = d
+	return nil
+}
+
+// Validate reports the first station default that would fail ValidateDPV
+func (d StationDefaults) Validate() error {
+	if d.FeedRates != 2 && d.FeedRates != 4 && d.FeedRates != 8 {
+		return fmt.Errorf("feedrates %d must be 2, 4 or 8", d.FeedRates)
+	}
+	if d.Height < 0 || d.Height > MaxComponentHeight {
+		return fmt.Errorf("height %.2f must be between 0 and %.2fmm", d.Height, MaxComponentHeight)
+	}
+	if d.Speed != 0 && (d.Speed < 50 || d.Speed > 100) {
+		return fmt.Errorf("speed %d must be 0 (100%%) or 50-100", d.Speed)
+	}
+	if msg := flagBitsProblem(d.Status); msg != "" {
+		return fmt.Errorf("status %d is invalid: %s", d.Status, msg)
+	}
+	if d.DelayTake < 0 {
+		return fmt.Errorf("delaytake %d cannot be negative", d.DelayTake)
+	}
+	if d.NPullStripSpeed < 0 || d.NPullStripSpeed > 100 {
+		return fmt.Errorf("npullstripspeed %d must be 0-100", d.NPullStripSpeed)
+	}
+	if d.NThreshold < 0 || d.NThreshold > 256 {
+		return fmt.Errorf("nthreshold %d must be 0 (default) or 1-256", d.NThreshold)
+	}
+	if d.NVisualRadio < 0 {
+		return fmt.Errorf("nvisualradio %d cannot be negative", d.NVisualRadio)
+	}
+	if d.PHead != 1 && d.PHead != 2 {
+		return fmt.Errorf("phead %d must be 1 or 2", d.PHead)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reelBoard returns an XFile with n active stations (IDs 1..n), each used
+// by one component
+func reelBoard(n int) *XFile {
+	xf := NewXFile()
+	for i := 1; i <= n; i++ {
+		xf.Stations = append(xf.Stations, XStation{No: i - 1, ID: i, Note: fmt.Sprintf("part%d", i), PHead: 1})
+		xf.Components = append(xf.Components, XComponent{No: i - 1, ID: i, STNo: i, PHead: 1, Note: fmt.Sprintf("R%d - R_0603", i)})
+	}
+	return xf
+}
+
+// validReelSlot reports whether id is a left or right bank reel slot
+func validReelSlot(id int) bool {
+	return (id >= 1 && id <= 29) || (id >= 36 && id <= 64)
+}
+
+func TestAssignFeederSlotsAvoidsReservedRanges(t *testing.T) {
+	xf := reelBoard(50)
+	// A DNP station keeps its slot, and an IC tray station keeps its ID
+	// and tray row
+	xf.Stations = append(xf.Stations,
+		XStation{No: 50, ID: 3, Note: "unused", DNP: true},
+		XStation{No: 51, ID: 91, Note: "STM32"},
+	)
+	xf.Components = append(xf.Components, XComponent{No: 50, ID: 51, STNo: 91, PHead: 1, Note: "U1 - LQFP-48"})
+	xf.ICTrays = []ICTrayRow{{ID: 91, NumX: 4, NumY: 2}}
+
+	if err := AssignFeederSlots(xf, true, 0); err != nil {
+		t.Fatalf("AssignFeederSlots: %v", err)
+	}
+
+	seen := make(map[int]bool)
+	for _, s := range xf.Stations[:50] {
+		if !validReelSlot(s.ID) {
+			t.Errorf("station %s got ID %d outside the reel banks", s.Note, s.ID)
+		}
+		if s.ID == 3 {
+			t.Errorf("station %s took the DNP station's slot 3", s.Note)
+		}
+		if seen[s.ID] {
+			t.Errorf("slot %d assigned twice", s.ID)
+		}
+		seen[s.ID] = true
+		if want := 1 + s.ID/36; s.PHead != want {
+			t.Errorf("station %d has PHead %d, want %d", s.ID, s.PHead, want)
+		}
+	}
+	if xf.Stations[50].ID != 3 || xf.Stations[51].ID != 91 || xf.ICTrays[0].ID != 91 {
+		t.Errorf("DNP/tray stations moved: %d, %d, tray %d", xf.Stations[50].ID, xf.Stations[51].ID, xf.ICTrays[0].ID)
+	}
+
+	for i, c := range xf.Components[:50] {
+		if want := xf.Stations[i].ID; c.STNo != want {
+			t.Errorf("%s has STNo %d, want %d", c.Note, c.STNo, want)
+		}
+	}
+	if c := xf.Components[50]; c.STNo != 91 {
+		t.Errorf("%s moved off the tray to STNo %d", c.Note, c.STNo)
+	}
+}
+
+func TestAssignFeederSlotsTooManyStations(t *testing.T) {
+	if err := AssignFeederSlots(reelBoard(59), false, 0); err == nil {
+		t.Error("59 stations fit in 58 reel slots")
+	}
+}
+
+func TestAssignFeederSlotsStartID(t *testing.T) {
+	xf := reelBoard(4)
+	if err := AssignFeederSlots(xf, false, 5); err != nil {
+		t.Fatalf("AssignFeederSlots: %v", err)
+	}
+	var ids []int
+	for _, s := range xf.Stations {
+		ids = append(ids, s.ID)
+	}
+	// Left bank from the requested offset, alternating with the right bank
+	if want := []int{5, 36, 6, 37}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("station IDs %v, want %v", ids, want)
+	}
+	for i, c := range xf.Components {
+		if c.STNo != ids[i] {
+			t.Errorf("%s has STNo %d, want %d", c.Note, c.STNo, ids[i])
+		}
+	}
+
+	// Slots 60-64 hold only five stations
+	if err := AssignFeederSlots(reelBoard(6), false, 60); err == nil {
+		t.Error("6 stations fit in the slots from ID 60")
+	}
+}
+
+func TestAssignHeads(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{ID: 1, Note: "100nF", PHead: 2},
+		{ID: 2, Note: "LM358", PHead: 1},
+		{ID: 3, Note: "1000uF", PHead: 1, Height: 10},
+		{ID: 4, Note: "TPS62", PHead: 2},
+	}
+	xf.Components = []XComponent{
+		{STNo: 1, PHead: 2, Note: "C1 - C_0402_1005Metric"},
+		{STNo: 2, PHead: 1, Note: "U1 - SOIC-8_3.9x4.9mm"},
+		{STNo: 3, PHead: 1, Note: "C2 - CP_Radial_D10.0mm"},
+		{STNo: 4, PHead: 2, Note: "U2 - QFN-16_3x3mm"},
+	}
+
+	changed := AssignHeads(xf, DefaultHeadRules)
+	if changed != 4 {
+		t.Errorf("changed %d stations, want 4", changed)
+	}
+
+	// 0402 and fine-pitch QFN stay on PHead 1; SOIC and tall parts use 2
+	for i, want := range []int{1, 2, 2, 1} {
+		if xf.Stations[i].PHead != want {
+			t.Errorf("station %s PHead = %d, want %d", xf.Stations[i].Note, xf.Stations[i].PHead, want)
+		}
+		if xf.Components[i].PHead != want {
+			t.Errorf("component %s PHead = %d, want %d", xf.Components[i].Note, xf.Components[i].PHead, want)
+		}
+	}
+
+	// Custom rules replace the defaults
+	AssignHeads(xf, map[string]int{"0402": 2})
+	if xf.Stations[0].PHead != 2 || xf.Components[0].PHead != 2 {
+		t.Error("custom rule did not move 0402 parts to PHead 2")
+	}
+}
+
+func TestMergeStations(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{No: 0, ID: 1, Note: "100nF"},
+		{No: 1, ID: 2, Note: "0.1uF"},
+		{No: 2, ID: 3, Note: "10k"},
+	}
+	xf.Components = []XComponent{
+		{ID: 1, STNo: 1, Explain: "100nF", Note: "C1 - C_0603"},
+		{ID: 2, STNo: 2, Explain: "0.1uF", Note: "C2 - C_0603"},
+		{ID: 3, STNo: 2, Explain: "0.1uF", Note: "C3 - C_0603"},
+		{ID: 4, STNo: 3, Explain: "10k", Note: "R1 - R_0603"},
+	}
+
+	moved, err := MergeStations(xf, "0.1uF", "100nF")
+	if err != nil {
+		t.Fatalf("MergeStations: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("moved %d components, want 2", moved)
+	}
+
+	wantSTNo := []int{1, 1, 1, 3}
+	for i, c := range xf.Components {
+		if c.STNo != wantSTNo[i] {
+			t.Errorf("%s on station %d, want %d", componentRef(c), c.STNo, wantSTNo[i])
+		}
+	}
+	if xf.Components[1].Explain != "100nF" {
+		t.Errorf("C2 Explain %q, want 100nF", xf.Components[1].Explain)
+	}
+	if len(xf.Stations) != 2 || xf.Stations[0].Note != "100nF" || xf.Stations[1].Note != "10k" {
+		t.Fatalf("stations after merge %+v", xf.Stations)
+	}
+	for i, s := range xf.Stations {
+		if s.No != i {
+			t.Errorf("station %s No %d, want %d", s.Note, s.No, i)
+		}
+	}
+
+	if _, err := MergeStations(xf, "0.1uF", "100nF"); err == nil {
+		t.Error("merging a removed station succeeded")
+	}
+	if _, err := MergeStations(xf, "10k", "10k"); err == nil {
+		t.Error("merging a station into itself succeeded")
+	}
+}
+
+func TestSuggestFeedRates(t *testing.T) {
+	packages := []string{"C_0402_1005Metric", "R_0603_1608Metric", "SOIC-8_3.9x4.9mm", "SOT-223-3", "Crystal_HC49", "SOT-23"}
+	xf := NewXFile()
+	for i, pkg := range packages {
+		xf.Stations = append(xf.Stations, XStation{No: i, ID: i + 1, Note: pkg, FeedRates: 4})
+		xf.Components = append(xf.Components, XComponent{ID: i + 1, STNo: i + 1, Note: fmt.Sprintf("U%d - %s", i+1, pkg)})
+	}
+	xf.Stations = append(xf.Stations, XStation{No: len(packages), ID: 99, Note: "unused", FeedRates: 2})
+
+	warnings := SuggestFeedRates(xf, DefaultFeedRateRules)
+
+	// SOT-223 must win over the shorter SOT-23 fragment
+	want := []int{2, 4, 8, 8, 4, 4, 2}
+	for i, s := range xf.Stations {
+		if s.FeedRates != want[i] {
+			t.Errorf("station %s FeedRates %d, want %d", s.Note, s.FeedRates, want[i])
+		}
+	}
+	if len(warnings) != 3 {
+		t.Errorf("got %d warnings, want 3 (0402, SOIC, SOT-223): %q", len(warnings), warnings)
+	}
+
+	// A custom table replaces the defaults
+	warnings = SuggestFeedRates(xf, map[string]int{"Crystal": 8})
+	if xf.Stations[4].FeedRates != 8 || xf.Stations[0].FeedRates != defaultFeedRate {
+		t.Errorf("custom rules: crystal %d, 0402 %d", xf.Stations[4].FeedRates, xf.Stations[0].FeedRates)
+	}
+	if len(warnings) != 4 {
+		t.Errorf("custom rules: got %d warnings, want 4: %q", len(warnings), warnings)
+	}
+}
+
+func TestSuggestDelays(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{No: 0, ID: 1, Note: "USB-C"},
+		{No: 1, ID: 2, Note: "10k", DelayTake: 5},
+	}
+	xf.Components = []XComponent{
+		{ID: 1, STNo: 1, Note: "J1 - USB_C_Receptacle_GCT_USB4085"},
+		{ID: 2, STNo: 2, Note: "R1 - R_0603_1608Metric", Delay: 5},
+	}
+
+	if changed := SuggestDelays(xf, DefaultDelayRules); changed != 1 {
+		t.Errorf("changed %d stations, want 1", changed)
+	}
+	if s, c := xf.Stations[0], xf.Components[0]; s.DelayTake != 30 || c.Delay != 30 {
+		t.Errorf("connector DelayTake %d, Delay %d, want 30", s.DelayTake, c.Delay)
+	}
+	if s, c := xf.Stations[1], xf.Components[1]; s.DelayTake != 5 || c.Delay != 5 {
+		t.Errorf("unmatched resistor DelayTake %d, Delay %d, want 5 kept", s.DelayTake, c.Delay)
+	}
+
+	if changed := SuggestDelays(xf, DefaultDelayRules); changed != 0 {
+		t.Errorf("second run changed %d stations, want 0", changed)
+	}
+}
+
+// setStationDefaults replaces the station defaults for the rest of a test
+func setStationDefaults(t *testing.T, d StationDefaults) {
+	t.Helper()
+	prev := CurrentStationDefaults()
+	if err := SetStationDefaults(d); err != nil {
+		t.Fatalf("SetStationDefaults: %v", err)
+	}
+	t.Cleanup(func() { SetStationDefaults(prev) })
+}
+
+func TestStationDefaults(t *testing.T) {
+	custom := StationDefaults{
+		FeedRates:       2,
+		Height:          0.8,
+		Speed:           80,
+		Status:          6,
+		DelayTake:       20,
+		NPullStripSpeed: 70,
+		NThreshold:      120,
+		NVisualRadio:    150,
+		PHead:           2,
+	}
+	setStationDefaults(t, custom)
+
+	// Stations created from a POS file
+	pos := &POSData{Rows: []POSRow{{Ref: "R1", Val: "10k", Package: "R_0603", PosX: 1, PosY: 2, Side: "top"}}}
+	xf := ConvertPOSToXFile(pos, "board.pos")
+	if len(xf.Stations) != 1 {
+		t.Fatalf("got %d stations, want 1", len(xf.Stations))
+	}
+	s := xf.Stations[0]
+	got := StationDefaults{s.FeedRates, s.Height, s.Speed, s.Status, s.DelayTake, s.NPullStripSpeed, s.NThreshold, s.NVisualRadio, s.PHead}
+	if got != custom {
+		t.Errorf("POS station parameters %+v, want %+v", got, custom)
+	}
+
+	// STACK rows that leave columns out
+	s = parseStationRow([]string{"Table", "No.", "ID", "DeltX", "DeltY", "Note"}, []string{"Station", "0", "3", "100", "50", "10k"})
+	got = StationDefaults{s.FeedRates, s.Height, s.Speed, s.Status, s.DelayTake, s.NPullStripSpeed, s.NThreshold, s.NVisualRadio, s.PHead}
+	if got != custom {
+		t.Errorf("STACK station parameters %+v, want %+v", got, custom)
+	}
+
+	// Defaults that would fail validation are refused and change nothing
+	bad := custom
+	bad.FeedRates = 3
+	if err := SetStationDefaults(bad); err == nil {
+		t.Error("SetStationDefaults accepted feedrates 3")
+	}
+	if CurrentStationDefaults() != custom {
+		t.Errorf("rejected defaults replaced the current ones: %+v", CurrentStationDefaults())
+	}
+}
+
+func TestTuneVision(t *testing.T) {
+	xf := NewXFile()
+	xf.Stations = []XStation{
+		{No: 0, ID: 1, Note: "100nF", NThreshold: 110, NVisualRadio: 200},
+		{No: 1, ID: 2, Note: "10k", NThreshold: 110, NVisualRadio: 200},
+		{No: 2, ID: 3, Note: "LM358", NThreshold: 110, NVisualRadio: 200},
+		{No: 3, ID: 4, Note: "USB-C", NThreshold: 110, NVisualRadio: 200},
+	}
+	xf.Components = []XComponent{
+		{STNo: 1, Note: "C1 - C_0201"},
+		{STNo: 2, Note: "R1 - R_0805"},
+		{STNo: 3, Note: "U1 - SOIC-8_3.9x4.9mm_P1.27mm"},
+		{STNo: 4, Note: "J1 - USB_C_Receptacle"},
+	}
+
+	if changed := TuneVision(xf, DefaultVisionRules); changed != 3 {
+		t.Errorf("changed %d stations, want 3", changed)
+	}
+	want := []VisionSettings{
+		DefaultVisionRules["0201"],
+		DefaultVisionRules["0805"],
+		DefaultVisionRules["SOIC"],
+		{NThreshold: 110, NVisualRadio: 200}, // no rule: unchanged
+	}
+	for i, s := range xf.Stations {
+		got := VisionSettings{NThreshold: s.NThreshold, NVisualRadio: s.NVisualRadio, NPixSizeX: s.NPixSizeX, NPixSizeY: s.NPixSizeY}
+		if got != want[i] {
+			t.Errorf("station %s = %+v, want %+v", s.Note, got, want[i])
+		}
+	}
+
+	// Already tuned stations are not counted again
+	if changed := TuneVision(xf, DefaultVisionRules); changed != 0 {
+		t.Errorf("second run changed %d stations, want 0", changed)
+	}
+}
+
+func TestReorderStations(t *testing.T) {
+	xf := testBoard()
+	xf.Stations = append(xf.Stations, XStation{No: 2, ID: 3, DeltX: 140, DeltY: 50, FeedRates: 4, Note: "1uF",
+		Height: 0.5, Speed: 100, Status: 6, PHead: 1})
+	xf.Components = append(xf.Components, XComponent{No: 3, ID: 4, PHead: 1, STNo: 3, DeltX: 30, DeltY: 30,
+		Height: 0.5, Skip: 6, Speed: 100, Explain: "1uF", Note: "C2 - C_0603", Side: "top"})
+
+	if err := ReorderStations(xf, []int{3, 1, 2}); err != nil {
+		t.Fatalf("ReorderStations: %v", err)
+	}
+	for i, want := range []struct {
+		id   int
+		note string
+	}{{3, "1uF"}, {1, "10k"}, {2, "100nF"}} {
+		if s := xf.Stations[i]; s.No != i || s.ID != want.id || s.Note != want.note {
+			t.Errorf("station row %d = No %d ID %d %q, want No %d ID %d %q", i, s.No, s.ID, s.Note, i, want.id, want.note)
+		}
+	}
+
+	// Components still point at the station holding their value
+	byID := make(map[int]XStation)
+	for _, s := range xf.Stations {
+		byID[s.ID] = s
+	}
+	for _, c := range xf.Components {
+		if s, ok := byID[c.STNo]; !ok || s.Note != c.Explain {
+			t.Errorf("%s (%s) resolves to station %+v", componentRef(c), c.Explain, s)
+		}
+	}
+
+	// The list must name every station exactly once
+	for _, ids := range [][]int{{1, 2}, {1, 2, 2}, {1, 2, 4}, {1, 2, 3, 3}} {
+		before := append([]XStation(nil), xf.Stations...)
+		if err := ReorderStations(xf, ids); err == nil {
+			t.Errorf("ReorderStations(%v) accepted", ids)
+		}
+		if !reflect.DeepEqual(xf.Stations, before) {
+			t.Errorf("rejected ReorderStations(%v) changed the stations", ids)
+		}
+	}
+}
+
+func TestDisableVisionForPackagesSurvivesExport(t *testing.T) {
+	xf := testBoard()
+	xf.Stations[1].NThreshold, xf.Stations[1].NVisualRadio = 60, 100
+	xf.Components[2].Note = "J1 - USB_C_Receptacle"
+
+	if c, s := DisableVisionForPackages(xf, []string{"usb"}); c != 1 || s != 1 {
+		t.Errorf("changed %d components and %d stations, want 1 and 1", c, s)
+	}
+	if res := ValidateDPV(xf, "board.dpv"); !res.Valid || findIssue(res.Warnings, "vision_settings_unused") != nil {
+		t.Errorf("after disabling vision: errors %+v, warnings %+v", res.Errors, res.Warnings)
+	}
+
+	dpv, err := GenerateDPV(xf, "board.dpv", false, DefaultPrecision)
+	if err != nil {
+		t.Fatalf("GenerateDPV: %v", err)
+	}
+	got, err := ParseDPV(strings.NewReader(dpv))
+	if err != nil {
+		t.Fatalf("ParseDPV: %v", err)
+	}
+	// The export auto-fix must not put the vision bit back on J1 or its
+	// station; the other parts keep vision
+	if s := got.Stations[1]; s.Status&4 != 0 {
+		t.Errorf("station %d exported with Status %d, vision bit set", s.ID, s.Status)
+	}
+	if c := got.Components[2]; c.Skip&4 != 0 {
+		t.Errorf("%s exported with Skip %d, vision bit set", c.Note, c.Skip)
+	}
+	if got.Stations[0].Status&4 == 0 || got.Components[0].Skip&4 == 0 {
+		t.Errorf("vision cleared on unmatched parts: station Status %d, R1 Skip %d", got.Stations[0].Status, got.Components[0].Skip)
+	}
+}
@@ -1,35 +1,98 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 // XFile is the central data structure that holds all converted data
 type XFile struct {
-	Metadata     XFileMetadata   `json:"metadata"`
-	GlobalOffset GlobalOffset    `json:"globalOffset"`
-	POSRows      []POSRow        `json:"posRows"`      // Original POS file data
-	Components   []XComponent    `json:"components"`
-	Stations     []XStation      `json:"stations"`
-	PanelArray   []PanelArrayRow `json:"panelArray"`
-	PanelCoord   []PanelCoordRow `json:"panelCoord"`
-	OriginalPOS  string          `json:"originalPOS"`  // Original POS filename
-	StackFiles   []string        `json:"stackFiles"`   // Loaded STACK filenames
+	Metadata        XFileMetadata   `json:"metadata"`
+	GlobalOffset    GlobalOffset    `json:"globalOffset"`
+	BoardRotation   int             `json:"boardRotation"` // Board rotation on machine (0, 90, 180, 270)
+	POSRows         []POSRow        `json:"posRows"`       // Original POS file data
+	POSComments     []string        `json:"posComments"`   // Original POS comment header lines
+	POSHeaders      []string        `json:"posHeaders"`    // Original POS column headers, in file order
+	Components      []XComponent    `json:"components"`
+	Stations        []XStation      `json:"stations"`
+	PanelArray      []PanelArrayRow `json:"panelArray"`
+	PanelCoord      []PanelCoordRow `json:"panelCoord"`
+	ICTrays         []ICTrayRow     `json:"icTrays"`
+	AllowedOverlaps [][2]string     `json:"allowedOverlaps"` // Ref pairs stacked by design
+	OriginalPOS     string          `json:"originalPOS"`     // Original POS filename
+	StackFiles      []string        `json:"stackFiles"`      // Loaded STACK filenames
 }
 
 // POSRow represents a single row from the original KiCad POS file
 type POSRow struct {
-	Ref     string  `json:"ref"`
-	Val     string  `json:"val"`
-	Package string  `json:"package"`
-	PosX    float64 `json:"posx"`
-	PosY    float64 `json:"posy"`
-	Rot     float64 `json:"rot"`
-	Side    string  `json:"side"`
+	Ref       string  `json:"ref"`
+	Val       string  `json:"val"`
+	Package   string  `json:"package"`
+	Footprint string  `json:"footprint"` // Library footprint, when given separately
+	PosX      float64 `json:"posx"`
+	PosY      float64 `json:"posy"`
+	Rot       float64 `json:"rot"`
+	Side      string  `json:"side"`
 }
 
 // XFileMetadata contains file metadata
 type XFileMetadata struct {
-	Created  time.Time `json:"created"`
-	Modified time.Time `json:"modified"`
+	Created       time.Time `json:"created"`
+	Modified      time.Time `json:"modified"`
+	SchemaVersion int       `json:"schemaVersion"` // XFile JSON format version (0 = before versioning)
+
+	LastExport *ExportInfo `json:"lastExport,omitempty"` // Most recent ZIP export
+}
+
+// SchemaVersion is the current XFile JSON format version
+const SchemaVersion = 1
+
+// MigrateXFile upgrades an XFile decoded from an older JSON format to the
+// current SchemaVersion. Files written by a newer version are rejected.
+func MigrateXFile(xf *XFile) error {
+	if xf.Metadata.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("XFile schema version %d is newer than supported version %d",
+			xf.Metadata.SchemaVersion, SchemaVersion)
+	}
+
+	// v0 -> v1: fields added over time may be missing (null) in old files
+	if xf.Metadata.SchemaVersion < 1 {
+		defaults := NewXFile()
+		if xf.POSRows == nil {
+			xf.POSRows = defaults.POSRows
+		}
+		if xf.POSComments == nil {
+			xf.POSComments = defaults.POSComments
+		}
+		if xf.POSHeaders == nil {
+			xf.POSHeaders = defaults.POSHeaders
+		}
+		if xf.Components == nil {
+			xf.Components = defaults.Components
+		}
+		if xf.Stations == nil {
+			xf.Stations = defaults.Stations
+		}
+		if len(xf.PanelArray) == 0 {
+			xf.PanelArray = defaults.PanelArray
+		}
+		if len(xf.PanelCoord) == 0 {
+			xf.PanelCoord = defaults.PanelCoord
+		}
+		if xf.ICTrays == nil {
+			xf.ICTrays = defaults.ICTrays
+		}
+		if xf.AllowedOverlaps == nil {
+			xf.AllowedOverlaps = defaults.AllowedOverlaps
+		}
+		if xf.StackFiles == nil {
+			xf.StackFiles = defaults.StackFiles
+		}
+		xf.Metadata.SchemaVersion = 1
+	}
+
+	return nil
 }
 
 // GlobalOffset contains X/Y offset applied to all component positions
@@ -57,8 +120,24 @@ type XComponent struct {
 	Delay   int     `json:"delay"`   // Delay before pickup (cs)
 
 	// Extended fields (not in standard DPV)
-	Select bool `json:"select"` // UI selection state
-	DNP    bool `json:"dnp"`    // Do Not Place flag
+	Select bool   `json:"select"` // UI selection state
+	DNP    bool   `json:"dnp"`    // Do Not Place flag
+	Side   string `json:"side"`   // Board side from POS ("top" or "bottom")
+	Locked bool   `json:"locked"` // STNo. set by hand; kept when stacks are merged
+}
+
+// componentRef returns the reference designator of a component, which is
+// stored as the first part of Note ("Ref - Package")
+func componentRef(c XComponent) string {
+	ref, _, _ := strings.Cut(c.Note, " - ")
+	return strings.TrimSpace(ref)
+}
+
+// componentPackage returns the package of a component, which is stored as
+// the second part of Note ("Ref - Package")
+func componentPackage(c XComponent) string {
+	_, pkg, _ := strings.Cut(c.Note, " - ")
+	return strings.TrimSpace(pkg)
 }
 
 // XStation represents a material stack/feeder (Station table row)
@@ -106,16 +185,32 @@ type PanelCoordRow struct {
 	DeltY float64 `json:"delty"` // Y offset to board 0,0
 }
 
+// ICTrayRow represents an ICTray table row (IC tray part source)
+type ICTrayRow struct {
+	No        int     `json:"no"`
+	ID        int     `json:"id"`        // Station ID of the tray
+	CenterX   float64 `json:"centerx"`   // X of first cell center
+	CenterY   float64 `json:"centery"`   // Y of first cell center
+	IntervalX float64 `json:"intervalx"` // X distance between columns
+	IntervalY float64 `json:"intervaly"` // Y distance between rows
+	NumX      int     `json:"numx"`      // Number of columns
+	NumY      int     `json:"numy"`      // Number of rows
+	Start     int     `json:"start"`     // First part to use (row major)
+}
+
 // NewXFile creates a new empty XFile with defaults
 func NewXFile() *XFile {
 	now := time.Now()
 	return &XFile{
 		Metadata: XFileMetadata{
-			Created:  now,
-			Modified: now,
+			Created:       now,
+			Modified:      now,
+			SchemaVersion: SchemaVersion,
 		},
 		GlobalOffset: GlobalOffset{X: 0, Y: 0},
 		POSRows:      []POSRow{},
+		POSComments:  []string{},
+		POSHeaders:   []string{},
 		Components:   []XComponent{},
 		Stations:     []XStation{},
 		PanelArray: []PanelArrayRow{
@@ -124,7 +219,53 @@ func NewXFile() *XFile {
 		PanelCoord: []PanelCoordRow{
 			{No: 0, ID: 1, DeltX: 0, DeltY: 0},
 		},
-		OriginalPOS: "",
-		StackFiles:  []string{},
+		ICTrays:         []ICTrayRow{},
+		AllowedOverlaps: [][2]string{},
+		OriginalPOS:     "",
+		StackFiles:      []string{},
+	}
+}
+
+// Renumber sets the No. of active stations and components to their index
+// (0 to N-1), as GenerateDPV does on export. DNP rows are numbered after the
+// active ones. Returns the number of rows whose No. changed.
+func Renumber(xf *XFile) int {
+	changed := 0
+	next, nextDNP := 0, 0
+	for _, s := range xf.Stations {
+		if !s.DNP {
+			nextDNP++
+		}
 	}
+	for i := range xf.Stations {
+		no := &next
+		if xf.Stations[i].DNP {
+			no = &nextDNP
+		}
+		if xf.Stations[i].No != *no {
+			xf.Stations[i].No = *no
+			changed++
+		}
+		*no++
+	}
+
+	next, nextDNP = 0, 0
+	for _, c := range xf.Components {
+		if !c.DNP {
+			nextDNP++
+		}
+	}
+	for i := range xf.Components {
+		no := &next
+		if xf.Components[i].DNP {
+			no = &nextDNP
+		}
+		if xf.Components[i].No != *no {
+			xf.Components[i].No = *no
+			changed++
+		}
+		*no++
+	}
+
+	return changed
 }
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrateXFileV0(t *testing.T) {
+	// Written before schemaVersion, panel tables, IC trays and stack files existed
+	v0 := `{
+		"metadata": {"created": "2025-01-02T03:04:05Z", "modified": "2025-01-02T03:04:05Z"},
+		"globalOffset": {"x": 1.5, "y": 2},
+		"posRows": [{"ref": "R1", "val": "10k", "package": "0603", "posx": 10, "posy": 20, "rot": 90, "side": "top"}],
+		"components": [{"no": 0, "id": 1, "phead": 1, "stno": 1, "deltx": 10, "delty": 20, "angle": 90, "height": 0.5, "speed": 100, "explain": "10k", "note": "R1 - 0603"}],
+		"stations": [{"no": 0, "id": 1, "deltx": 100, "delty": 50, "feedrates": 4, "note": "10k", "height": 0.5, "speed": 100, "phead": 1}],
+		"originalPOS": "board.pos"
+	}`
+
+	var xf XFile
+	if err := json.Unmarshal([]byte(v0), &xf); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if err := MigrateXFile(&xf); err != nil {
+		t.Fatalf("MigrateXFile: %v", err)
+	}
+
+	if xf.Metadata.SchemaVersion != SchemaVersion {
+		t.Errorf("schema version %d, want %d", xf.Metadata.SchemaVersion, SchemaVersion)
+	}
+	if len(xf.PanelArray) != 1 || xf.PanelArray[0].NumX != 1 || xf.PanelArray[0].NumY != 1 {
+		t.Errorf("panel array %+v, want the single-board default", xf.PanelArray)
+	}
+	if len(xf.PanelCoord) != 1 || xf.PanelCoord[0].ID != 1 {
+		t.Errorf("panel coord %+v, want the single-board default", xf.PanelCoord)
+	}
+	if xf.POSComments == nil || xf.POSHeaders == nil || xf.ICTrays == nil ||
+		xf.AllowedOverlaps == nil || xf.StackFiles == nil {
+		t.Errorf("missing lists left nil: %+v", xf)
+	}
+
+	// Existing data is kept
+	if xf.GlobalOffset != (GlobalOffset{X: 1.5, Y: 2}) || xf.OriginalPOS != "board.pos" {
+		t.Errorf("offset %+v, original POS %q", xf.GlobalOffset, xf.OriginalPOS)
+	}
+	if len(xf.Components) != 1 || componentRef(xf.Components[0]) != "R1" || xf.Components[0].Angle != 90 {
+		t.Errorf("components %+v", xf.Components)
+	}
+	if len(xf.Stations) != 1 || xf.Stations[0].Note != "10k" {
+		t.Errorf("stations %+v", xf.Stations)
+	}
+
+	// A migrated file passes validation like a new one
+	if res := ValidateDPV(&xf, "board.dpv"); !res.Valid {
+		t.Errorf("migrated file invalid: %+v", res.Errors)
+	}
+}
+
+func TestMigrateXFileRejectsNewerVersion(t *testing.T) {
+	xf := NewXFile()
+	xf.Metadata.SchemaVersion = SchemaVersion + 1
+	if err := MigrateXFile(xf); err == nil {
+		t.Error("expected an error for a newer schema version")
+	}
+}
+
+func TestRenumber(t *testing.T) {
+	xf := testBoard()
+	xf.Stations[0].No, xf.Stations[1].No = 5, 3
+	xf.Components = append(xf.Components, XComponent{No: 0, ID: 4, STNo: 1, Note: "R3 - R_0603", DNP: true})
+	xf.Components[0].No, xf.Components[1].No, xf.Components[2].No = 7, 7, 2
+	// C1 -> R3 (DNP) -> R2 order, so the DNP row is not last in the table
+	xf.Components[1], xf.Components[3] = xf.Components[3], xf.Components[1]
+
+	res := ValidateDPV(xf, "board.dpv")
+	if findIssue(res.Warnings, "station_no_sequence") == nil || findIssue(res.Warnings, "component_no_sequence") == nil {
+		t.Errorf("no sequence warnings before renumbering: %+v", res.Warnings)
+	}
+
+	if changed := Renumber(xf); changed != 6 {
+		t.Errorf("Renumber changed %d rows, want 6", changed)
+	}
+	for i, s := range xf.Stations {
+		if s.No != i {
+			t.Errorf("station %d No. %d, want %d", s.ID, s.No, i)
+		}
+	}
+	// Active components 0..N-1 in table order, DNP rows numbered after them
+	want := map[string]int{"R1": 0, "C1": 1, "R2": 2, "R3": 3}
+	for _, c := range xf.Components {
+		if c.No != want[componentRef(c)] {
+			t.Errorf("%s No. %d, want %d", componentRef(c), c.No, want[componentRef(c)])
+		}
+	}
+
+	res = ValidateDPV(xf, "board.dpv")
+	if issue := findIssue(res.Warnings, "station_no_sequence"); issue != nil {
+		t.Errorf("after renumbering: %+v", issue)
+	}
+	if issue := findIssue(res.Warnings, "component_no_sequence"); issue != nil {
+		t.Errorf("after renumbering: %+v", issue)
+	}
+	if changed := Renumber(xf); changed != 0 {
+		t.Errorf("second Renumber changed %d rows, want 0", changed)
+	}
+}
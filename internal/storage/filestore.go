@@ -2,10 +2,14 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"charmtool/internal/models"
@@ -15,27 +19,66 @@ import (
 
 // FileStore manages session-based file storage
 type FileStore struct {
-	baseDir    string
-	maxAge     time.Duration
-	mu         sync.RWMutex
-	sessions   map[string]*sessionData
-	stats      *Stats
+	baseDir  string
+	maxAge   time.Duration
+	mu       sync.RWMutex
+	sessions map[string]*sessionData
+	stats    *Stats
+	ready    atomic.Bool // Set once existing sessions have been loaded
+
+	maxSessions int // Oldest sessions are evicted beyond this (0 = unlimited)
 }
 
 // Stats tracks usage statistics
 type Stats struct {
-	TotalUsers      int `json:"totalUsers"`
-	TotalPOSUploads int `json:"totalPosUploads"`
+	TotalUsers              int `json:"totalUsers"`
+	TotalPOSUploads         int `json:"totalPosUploads"`
+	TotalExports            int `json:"totalExports"`
+	TotalValidationFailures int `json:"totalValidationFailures"`
 }
 
 type sessionData struct {
 	ID        string
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	XFile     *models.XFile
+	XFile     *models.XFile              // Default (unnamed) project
+	Projects  map[string]*models.XFile   // Named projects
+	History   map[string][]*models.XFile // Undo snapshots per project, oldest first (not persisted)
+	Previous  map[string]*models.XFile   // Project before its last POS upload (not persisted)
 }
 
-// NewFileStore creates a new file store
+// MaxUndo is the number of earlier versions kept per project for Undo
+const MaxUndo = 10
+
+// ErrNothingToUndo is returned by Undo when a project has no earlier version
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ErrNoPreviousUpload is returned by PreviousUpload when a project has not
+// had a POS file uploaded over earlier data
+var ErrNoPreviousUpload = errors.New("no previous upload")
+
+// DefaultProject is the name of the XFile every session starts with
+const DefaultProject = "default"
+
+// ProjectInfo summarizes a project for listing
+type ProjectInfo struct {
+	Name        string    `json:"name"`
+	Modified    time.Time `json:"modified"`
+	OriginalPOS string    `json:"originalPOS"`
+	Components  int       `json:"components"`
+	Stations    int       `json:"stations"`
+}
+
+// projectNamePattern restricts project names to safe file names
+var projectNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidProjectName reports whether name can be used as a project name
+func ValidProjectName(name string) bool {
+	return projectNamePattern.MatchString(name)
+}
+
+// NewFileStore creates a new file store. Sessions stored on disk are not
+// available until Load has run.
 func NewFileStore(baseDir string, maxAge time.Duration) (*FileStore, error) {
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
@@ -53,15 +96,17 @@ func NewFileStore(baseDir string, maxAge time.Duration) (*FileStore, error) {
 		fmt.Printf("Warning: could not load stats: %v\n", err)
 	}
 
-	// Load existing sessions from disk
-	if err := store.loadSessions(); err != nil {
-		// Log but don't fail - start fresh
-		fmt.Printf("Warning: could not load existing sessions: %v\n", err)
-	}
-
 	return store, nil
 }
 
+// Load reads the sessions stored on disk and then marks the store ready.
+// It may run while requests are served; Ready reports false until it
+// returns. Sessions that fail to load are skipped.
+func (fs *FileStore) Load() error {
+	defer fs.ready.Store(true)
+	return fs.loadSessions()
+}
+
 // loadStats loads stats from disk
 func (fs *FileStore) loadStats() error {
 	statsPath := filepath.Join(fs.baseDir, "stats.json")
@@ -82,7 +127,7 @@ func (fs *FileStore) saveStats() error {
 		return err
 	}
 	statsPath := filepath.Join(fs.baseDir, "stats.json")
-	return os.WriteFile(statsPath, data, 0644)
+	return writeFileAtomic(statsPath, data)
 }
 
 // GetStats returns current stats
@@ -100,7 +145,24 @@ func (fs *FileStore) IncrementPOSUploads() {
 	fs.saveStats()
 }
 
-// loadSessions loads all existing session files from disk
+// IncrementExports increments the export counter
+func (fs *FileStore) IncrementExports() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.stats.TotalExports++
+	fs.saveStats()
+}
+
+// IncrementValidationFailures increments the failed validation counter
+func (fs *FileStore) IncrementValidationFailures() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.stats.TotalValidationFailures++
+	fs.saveStats()
+}
+
+// loadSessions loads all existing session files from disk, taking the
+// lock for each session added
 func (fs *FileStore) loadSessions() error {
 	entries, err := os.ReadDir(fs.baseDir)
 	if err != nil {
@@ -122,28 +184,80 @@ func (fs *FileStore) loadSessions() error {
 		if err := json.Unmarshal(data, &xf); err != nil {
 			continue
 		}
+		if err := models.MigrateXFile(&xf); err != nil {
+			fmt.Printf("Warning: skipping %s: %v\n", entry.Name(), err)
+			continue
+		}
 
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 
-		fs.sessions[sessionID] = &sessionData{
+		session := &sessionData{
 			ID:        sessionID,
 			CreatedAt: xf.Metadata.Created,
 			UpdatedAt: info.ModTime(),
 			XFile:     &xf,
+			Projects:  fs.loadProjects(sessionID),
+		}
+		fs.mu.Lock()
+		if _, exists := fs.sessions[sessionID]; !exists {
+			fs.sessions[sessionID] = session
 		}
+		fs.mu.Unlock()
 	}
 
 	return nil
 }
 
+// loadProjects loads the named projects stored in a session's directory
+func (fs *FileStore) loadProjects(sessionID string) map[string]*models.XFile {
+	projects := make(map[string]*models.XFile)
+
+	entries, err := os.ReadDir(filepath.Join(fs.baseDir, sessionID))
+	if err != nil {
+		return projects
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		name := entry.Name()[:len(entry.Name())-5] // Remove .json
+		if !ValidProjectName(name) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(fs.baseDir, sessionID, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var xf models.XFile
+		if err := json.Unmarshal(data, &xf); err != nil {
+			continue
+		}
+		if err := models.MigrateXFile(&xf); err != nil {
+			fmt.Printf("Warning: skipping %s: %v\n", entry.Name(), err)
+			continue
+		}
+		projects[name] = &xf
+	}
+
+	return projects
+}
+
 // CreateSession creates a new session and returns its ID
 func (fs *FileStore) CreateSession() (string, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	// Make room by evicting the least recently used sessions
+	for fs.maxSessions > 0 && len(fs.sessions) >= fs.maxSessions {
+		fs.removeSession(fs.oldestSession())
+	}
+
 	sessionID := uuid.New().String()
 	xf := models.NewXFile()
 
@@ -152,6 +266,7 @@ func (fs *FileStore) CreateSession() (string, error) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		XFile:     xf,
+		Projects:  make(map[string]*models.XFile),
 	}
 
 	fs.sessions[sessionID] = session
@@ -168,6 +283,34 @@ func (fs *FileStore) CreateSession() (string, error) {
 	return sessionID, nil
 }
 
+// SetMaxSessions caps the number of sessions kept; creating a session
+// beyond the cap evicts the least recently used one (0 = unlimited)
+func (fs *FileStore) SetMaxSessions(n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.maxSessions = n
+}
+
+// oldestSession returns the ID of the least recently updated session.
+// Caller must hold fs.mu.
+func (fs *FileStore) oldestSession() string {
+	var oldest *sessionData
+	for _, session := range fs.sessions {
+		if oldest == nil || session.UpdatedAt.Before(oldest.UpdatedAt) {
+			oldest = session
+		}
+	}
+	return oldest.ID
+}
+
+// removeSession drops a session and its files, ignoring file errors.
+// Caller must hold fs.mu.
+func (fs *FileStore) removeSession(sessionID string) {
+	delete(fs.sessions, sessionID)
+	os.Remove(filepath.Join(fs.baseDir, sessionID+".json"))
+	os.RemoveAll(filepath.Join(fs.baseDir, sessionID))
+}
+
 // TouchSession updates the session's UpdatedAt timestamp to restart the 10-day expiry
 func (fs *FileStore) TouchSession(sessionID string) error {
 	fs.mu.Lock()
@@ -182,8 +325,13 @@ func (fs *FileStore) TouchSession(sessionID string) error {
 	return fs.saveSession(sessionID)
 }
 
-// GetSession retrieves a session by ID
+// GetSession retrieves a copy of the default project XFile of a session
 func (fs *FileStore) GetSession(sessionID string) (*models.XFile, error) {
+	return fs.GetProject(sessionID, DefaultProject)
+}
+
+// GetProject retrieves a copy of a named project XFile of a session
+func (fs *FileStore) GetProject(sessionID, project string) (*models.XFile, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
@@ -192,7 +340,101 @@ func (fs *FileStore) GetSession(sessionID string) (*models.XFile, error) {
 		return nil, fmt.Errorf("session not found: %s", sessionID)
 	}
 
-	return session.XFile, nil
+	if project == DefaultProject {
+		return copyXFile(session.XFile)
+	}
+
+	xf, ok := session.Projects[project]
+	if !ok {
+		return nil, fmt.Errorf("project not found: %s", project)
+	}
+
+	return copyXFile(xf)
+}
+
+// copyXFile returns a deep copy of an XFile so callers never share the
+// stored pointer; changes only take effect through UpdateProject
+func copyXFile(xf *models.XFile) (*models.XFile, error) {
+	data, err := json.Marshal(xf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy XFile: %w", err)
+	}
+	var cp models.XFile
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to copy XFile: %w", err)
+	}
+	return &cp, nil
+}
+
+// CreateProject adds a new empty named project to a session
+func (fs *FileStore) CreateProject(sessionID, project string) error {
+	if !ValidProjectName(project) {
+		return fmt.Errorf("invalid project name: %q", project)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	if _, exists := session.Projects[project]; exists || project == DefaultProject {
+		return fmt.Errorf("project already exists: %s", project)
+	}
+
+	session.Projects[project] = models.NewXFile()
+	session.UpdatedAt = time.Now()
+
+	return fs.saveProject(sessionID, project)
+}
+
+// ListProjects returns the projects of a session, default first
+func (fs *FileStore) ListProjects(sessionID string) ([]ProjectInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	projects := []ProjectInfo{projectInfo(DefaultProject, session.XFile)}
+
+	names := make([]string, 0, len(session.Projects))
+	for name := range session.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		projects = append(projects, projectInfo(name, session.Projects[name]))
+	}
+
+	return projects, nil
+}
+
+// projectInfo summarizes an XFile for ListProjects
+func projectInfo(name string, xf *models.XFile) ProjectInfo {
+	return ProjectInfo{
+		Name:        name,
+		Modified:    xf.Metadata.Modified,
+		OriginalPOS: xf.OriginalPOS,
+		Components:  len(xf.Components),
+		Stations:    len(xf.Stations),
+	}
+}
+
+// Ready reports whether existing sessions have finished loading
+func (fs *FileStore) Ready() bool {
+	return fs.ready.Load()
+}
+
+// SessionCount returns the number of active sessions
+func (fs *FileStore) SessionCount() int {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return len(fs.sessions)
 }
 
 // SessionExists checks if a session exists
@@ -204,8 +446,17 @@ func (fs *FileStore) SessionExists(sessionID string) bool {
 	return ok
 }
 
-// UpdateSession updates the XFile for a session
+// UpdateSession updates the default project XFile for a session
 func (fs *FileStore) UpdateSession(sessionID string, xf *models.XFile) error {
+	return fs.UpdateProject(sessionID, DefaultProject, xf)
+}
+
+// UpdateProject updates a named project XFile, creating the project if needed
+func (fs *FileStore) UpdateProject(sessionID, project string, xf *models.XFile) error {
+	if !ValidProjectName(project) {
+		return fmt.Errorf("invalid project name: %q", project)
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -214,11 +465,145 @@ func (fs *FileStore) UpdateSession(sessionID string, xf *models.XFile) error {
 		return fmt.Errorf("session not found: %s", sessionID)
 	}
 
-	xf.Metadata.Modified = time.Now()
-	session.XFile = xf
+	stored, err := copyXFile(xf)
+	if err != nil {
+		return err
+	}
+	if err := models.MigrateXFile(stored); err != nil {
+		return err
+	}
+	stored.Metadata.Modified = time.Now()
+	xf.Metadata.Modified = stored.Metadata.Modified
 	session.UpdatedAt = time.Now()
 
-	return fs.saveSession(sessionID)
+	// Keep the version being replaced so it can be restored by Undo
+	previous := session.XFile
+	if project != DefaultProject {
+		previous = session.Projects[project]
+	}
+	if previous != nil {
+		pushHistory(session, project, previous)
+	}
+
+	return fs.storeProject(session, project, stored)
+}
+
+// Undo restores the version of a project saved before its last update and
+// returns a copy of it. Returns ErrNothingToUndo if there is none.
+func (fs *FileStore) Undo(sessionID, project string) (*models.XFile, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	history := session.History[project]
+	if len(history) == 0 {
+		return nil, ErrNothingToUndo
+	}
+	restored := history[len(history)-1]
+	session.History[project] = history[:len(history)-1]
+
+	restored.Metadata.Modified = time.Now()
+	session.UpdatedAt = time.Now()
+	if err := fs.storeProject(session, project, restored); err != nil {
+		return nil, err
+	}
+
+	return copyXFile(restored)
+}
+
+// SetLastExport records job as the last export of a project. Only the
+// metadata of the current stored version changes, so edits saved since the
+// export was generated are kept and no undo step is added.
+func (fs *FileStore) SetLastExport(sessionID, project string, job *models.ExportInfo) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	xf := session.XFile
+	if project != DefaultProject {
+		xf = session.Projects[project]
+	}
+	if xf == nil {
+		return fmt.Errorf("project not found: %s", project)
+	}
+
+	info := *job
+	xf.Metadata.LastExport = &info
+	session.UpdatedAt = time.Now()
+	return fs.storeProject(session, project, xf)
+}
+
+// SetPreviousUpload records the version of a project a POS upload replaced,
+// for comparing uploads with PreviousUpload
+func (fs *FileStore) SetPreviousUpload(sessionID, project string, xf *models.XFile) error {
+	stored, err := copyXFile(xf)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	if session.Previous == nil {
+		session.Previous = make(map[string]*models.XFile)
+	}
+	session.Previous[project] = stored
+	return nil
+}
+
+// PreviousUpload returns a copy of the project as it was before its last
+// POS upload. Returns ErrNoPreviousUpload if there is none.
+func (fs *FileStore) PreviousUpload(sessionID, project string) (*models.XFile, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	xf, ok := session.Previous[project]
+	if !ok {
+		return nil, ErrNoPreviousUpload
+	}
+	return copyXFile(xf)
+}
+
+// pushHistory records an undo snapshot of a project, dropping the oldest
+// beyond MaxUndo (caller must hold lock)
+func pushHistory(session *sessionData, project string, xf *models.XFile) {
+	if session.History == nil {
+		session.History = make(map[string][]*models.XFile)
+	}
+	history := append(session.History[project], xf)
+	if len(history) > MaxUndo {
+		history = history[len(history)-MaxUndo:]
+	}
+	session.History[project] = history
+}
+
+// storeProject sets a project XFile and writes it to disk (caller must hold lock)
+func (fs *FileStore) storeProject(session *sessionData, project string, xf *models.XFile) error {
+	if project == DefaultProject {
+		session.XFile = xf
+		return fs.saveSession(session.ID)
+	}
+
+	session.Projects[project] = xf
+	return fs.saveProject(session.ID, project)
 }
 
 // saveSession saves a session to disk (caller must hold lock)
@@ -234,13 +619,98 @@ func (fs *FileStore) saveSession(sessionID string) error {
 	}
 
 	filePath := filepath.Join(fs.baseDir, sessionID+".json")
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := writeFileAtomic(filePath, data); err != nil {
 		return fmt.Errorf("failed to write session file: %w", err)
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it into place, so a crash mid-write never leaves a truncated file
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// saveProject saves a named project to the session directory (caller must hold lock)
+func (fs *FileStore) saveProject(sessionID, project string) error {
+	session, ok := fs.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	data, err := json.MarshalIndent(session.Projects[project], "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal XFile: %w", err)
+	}
+
+	dir := filepath.Join(fs.baseDir, sessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create project directory: %w", err)
+	}
+
+	filePath := filepath.Join(dir, project+".json")
+	if err := writeFileAtomic(filePath, data); err != nil {
+		return fmt.Errorf("failed to write project file: %w", err)
+	}
+
+	return nil
+}
+
+// Flush writes every session, project and the stats to disk. Changes are
+// saved as they are made, so this is a final pass on shutdown; holding the
+// lock also waits for any write in progress.
+func (fs *FileStore) Flush() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for id, session := range fs.sessions {
+		keep(fs.saveSession(id))
+		for project := range session.Projects {
+			keep(fs.saveProject(id, project))
+		}
+	}
+	keep(fs.saveStats())
+
+	return firstErr
+}
+
 // DeleteSession removes a session
 func (fs *FileStore) DeleteSession(sessionID string) error {
 	fs.mu.Lock()
@@ -256,12 +726,15 @@ func (fs *FileStore) DeleteSession(sessionID string) error {
 	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove session file: %w", err)
 	}
+	if err := os.RemoveAll(filepath.Join(fs.baseDir, sessionID)); err != nil {
+		return fmt.Errorf("failed to remove project directory: %w", err)
+	}
 
 	return nil
 }
 
-// Cleanup removes sessions older than maxAge
-func (fs *FileStore) Cleanup() error {
+// Cleanup removes sessions older than maxAge and returns how many were removed
+func (fs *FileStore) Cleanup() (int, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -275,14 +748,8 @@ func (fs *FileStore) Cleanup() error {
 	}
 
 	for _, id := range toDelete {
-		delete(fs.sessions, id)
-		filePath := filepath.Join(fs.baseDir, id+".json")
-		os.Remove(filePath) // Ignore errors during cleanup
+		fs.removeSession(id)
 	}
 
-	if len(toDelete) > 0 {
-		fmt.Printf("Cleaned up %d expired sessions\n", len(toDelete))
-	}
-
-	return nil
+	return len(toDelete), nil
 }
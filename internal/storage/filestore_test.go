@@ -0,0 +1,454 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"charmtool/internal/models"
+)
+
+// newTestStore returns a loaded FileStore in a temp directory
+func newTestStore(t *testing.T) *FileStore {
+	t.Helper()
+	return loadStore(t, t.TempDir(), time.Hour)
+}
+
+// loadStore creates a FileStore in dir and loads its stored sessions
+func loadStore(t *testing.T, dir string, maxAge time.Duration) *FileStore {
+	t.Helper()
+	fs, err := NewFileStore(dir, maxAge)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := fs.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return fs
+}
+
+// newSession creates a session, failing the test on error
+func newSession(t *testing.T, fs *FileStore) string {
+	t.Helper()
+	id, err := fs.CreateSession()
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	return id
+}
+
+func TestProjects(t *testing.T) {
+	fs := newTestStore(t)
+	id := newSession(t, fs)
+
+	if err := fs.CreateProject(id, "panel-a"); err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	for _, bad := range []string{"panel-a", DefaultProject, "../escape", ""} {
+		if err := fs.CreateProject(id, bad); err == nil {
+			t.Errorf("CreateProject(%q) succeeded", bad)
+		}
+	}
+
+	// Switching projects: each keeps its own XFile
+	xf := models.NewXFile()
+	xf.OriginalPOS = "panel-a.pos"
+	xf.Components = []models.XComponent{{ID: 1}, {ID: 2}}
+	if err := fs.UpdateProject(id, "panel-a", xf); err != nil {
+		t.Fatalf("UpdateProject: %v", err)
+	}
+	got, err := fs.GetProject(id, "panel-a")
+	if err != nil || got.OriginalPOS != "panel-a.pos" {
+		t.Fatalf("GetProject(panel-a) = %v, %v", got, err)
+	}
+	def, err := fs.GetSession(id)
+	if err != nil || def.OriginalPOS != "" {
+		t.Errorf("default project changed: %v, %v", def, err)
+	}
+	if _, err := fs.GetProject(id, "missing"); err == nil {
+		t.Error("GetProject of a missing project succeeded")
+	}
+
+	list, err := fs.ListProjects(id)
+	if err != nil {
+		t.Fatalf("ListProjects: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != DefaultProject || list[1].Name != "panel-a" || list[1].Components != 2 {
+		t.Errorf("ListProjects = %+v", list)
+	}
+
+	// Projects are reloaded from disk
+	reloaded := loadStore(t, fs.baseDir, time.Hour)
+	if got, err := reloaded.GetProject(id, "panel-a"); err != nil || len(got.Components) != 2 {
+		t.Errorf("reloaded project = %v, %v", got, err)
+	}
+}
+
+func TestSaveSessionIsAtomic(t *testing.T) {
+	fs := newTestStore(t)
+	id := newSession(t, fs)
+	path := filepath.Join(fs.baseDir, id+".json")
+
+	xf := models.NewXFile()
+	xf.OriginalPOS = "old.pos"
+	if err := fs.UpdateSession(id, xf); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+
+	// A crash mid-write leaves a truncated temp file behind; the session
+	// file must still hold the old complete JSON
+	partial := filepath.Join(fs.baseDir, id+".json.12345.tmp")
+	if err := os.WriteFile(partial, []byte(`{"originalPOS": "new.p`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readXFile(t, path); got.OriginalPOS != "old.pos" {
+		t.Errorf("session file holds %q, want old.pos", got.OriginalPOS)
+	}
+
+	// The next save replaces the file whole, whatever temp files exist
+	xf.OriginalPOS = "new.pos"
+	if err := fs.UpdateSession(id, xf); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if got := readXFile(t, path); got.OriginalPOS != "new.pos" {
+		t.Errorf("session file holds %q, want new.pos", got.OriginalPOS)
+	}
+
+	// Writes leave no temp files of their own
+	matches, _ := filepath.Glob(filepath.Join(fs.baseDir, "*.tmp"))
+	if len(matches) != 1 || matches[0] != partial {
+		t.Errorf("temp files after save: %v", matches)
+	}
+}
+
+// readXFile decodes an XFile JSON file, failing on truncated content
+func readXFile(t *testing.T, path string) *models.XFile {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	var xf models.XFile
+	if err := json.Unmarshal(data, &xf); err != nil {
+		t.Fatalf("%s is not complete JSON: %v", path, err)
+	}
+	return &xf
+}
+
+// TestConcurrentSessionAccess hammers GetSession/UpdateSession from several
+// goroutines; run with -race to check callers never share stored XFiles
+func TestConcurrentSessionAccess(t *testing.T) {
+	fs := newTestStore(t)
+	id := newSession(t, fs)
+
+	const writers, readers, rounds = 4, 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				xf, err := fs.GetSession(id)
+				if err != nil {
+					t.Errorf("GetSession: %v", err)
+					return
+				}
+				xf.Components = append(xf.Components, models.XComponent{ID: w*rounds + i + 1})
+				if err := fs.UpdateSession(id, xf); err != nil {
+					t.Errorf("UpdateSession: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				xf, err := fs.GetSession(id)
+				if err != nil {
+					t.Errorf("GetSession: %v", err)
+					return
+				}
+				// Changing a copy without saving must not reach the store
+				for j := range xf.Components {
+					xf.Components[j].DeltX = -1
+				}
+				xf.OriginalPOS = "scratch.pos"
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := fs.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.OriginalPOS != "" {
+		t.Errorf("unsaved change to a copy reached the store: OriginalPOS %q", got.OriginalPOS)
+	}
+	if n := len(got.Components); n == 0 || n > writers*rounds {
+		t.Errorf("got %d components after %d updates", n, writers*rounds)
+	}
+	for _, c := range got.Components {
+		if c.DeltX != 0 {
+			t.Fatalf("unsaved change to a copy reached the store: component %d at x %v", c.ID, c.DeltX)
+		}
+	}
+}
+
+func TestCleanupRemovesExpiredSessions(t *testing.T) {
+	fs := loadStore(t, t.TempDir(), 10*time.Millisecond)
+	id := newSession(t, fs)
+	if err := fs.CreateProject(id, "panel-a"); err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := fs.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if fs.SessionExists(id) {
+		t.Error("expired session still exists")
+	}
+	for _, path := range []string{filepath.Join(fs.baseDir, id+".json"), filepath.Join(fs.baseDir, id)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", path, err)
+		}
+	}
+}
+
+func TestCleanupReturnsCount(t *testing.T) {
+	fs := newTestStore(t) // maxAge one hour
+	var expired []string
+	for i := 0; i < 3; i++ {
+		expired = append(expired, newSession(t, fs))
+	}
+	fresh := newSession(t, fs)
+
+	fs.mu.Lock()
+	for _, id := range expired {
+		fs.sessions[id].UpdatedAt = time.Now().Add(-2 * time.Hour)
+	}
+	fs.mu.Unlock()
+
+	removed, err := fs.Cleanup()
+	if err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if removed != len(expired) {
+		t.Errorf("Cleanup removed %d sessions, want %d", removed, len(expired))
+	}
+	if !fs.SessionExists(fresh) || fs.SessionCount() != 1 {
+		t.Errorf("%d sessions left, want only the fresh one", fs.SessionCount())
+	}
+
+	if removed, _ := fs.Cleanup(); removed != 0 {
+		t.Errorf("second Cleanup removed %d sessions, want 0", removed)
+	}
+}
+
+func TestLoadMarksReady(t *testing.T) {
+	fs := newTestStore(t)
+	id := newSession(t, fs)
+
+	reloaded, err := NewFileStore(fs.baseDir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if reloaded.Ready() || reloaded.SessionExists(id) {
+		t.Fatal("store is ready before Load")
+	}
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reloaded.Ready() || !reloaded.SessionExists(id) {
+		t.Errorf("after Load: ready %v, session loaded %v", reloaded.Ready(), reloaded.SessionExists(id))
+	}
+}
+
+func TestUndo(t *testing.T) {
+	fs := newTestStore(t)
+	id := newSession(t, fs)
+
+	if _, err := fs.Undo(id, DefaultProject); err != ErrNothingToUndo {
+		t.Errorf("Undo of a new session: %v, want ErrNothingToUndo", err)
+	}
+
+	// Make MaxUndo+2 edits, each moving the offset one step
+	for i := 1; i <= MaxUndo+2; i++ {
+		xf, err := fs.GetSession(id)
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		xf.GlobalOffset.X = float64(i)
+		if err := fs.UpdateSession(id, xf); err != nil {
+			t.Fatalf("UpdateSession: %v", err)
+		}
+	}
+
+	// Each undo reverts one edit, back to the oldest kept snapshot
+	for want := MaxUndo + 1; want > 1; want-- {
+		xf, err := fs.Undo(id, DefaultProject)
+		if err != nil {
+			t.Fatalf("Undo to offset %d: %v", want, err)
+		}
+		if xf.GlobalOffset.X != float64(want) {
+			t.Errorf("Undo returned offset %v, want %d", xf.GlobalOffset.X, want)
+		}
+		if stored, _ := fs.GetSession(id); stored.GlobalOffset.X != float64(want) {
+			t.Errorf("stored offset %v after undo, want %d", stored.GlobalOffset.X, want)
+		}
+	}
+	if _, err := fs.Undo(id, DefaultProject); err != ErrNothingToUndo {
+		t.Errorf("Undo past %d steps: %v, want ErrNothingToUndo", MaxUndo, err)
+	}
+}
+
+func TestMaxSessionsEvictsOldest(t *testing.T) {
+	fs := newTestStore(t)
+	fs.SetMaxSessions(3)
+
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i] = newSession(t, fs)
+	}
+	// Make the first session the most recently used
+	fs.sessions[ids[1]].UpdatedAt = time.Now().Add(-2 * time.Hour)
+	fs.sessions[ids[2]].UpdatedAt = time.Now().Add(-time.Hour)
+	fs.sessions[ids[0]].UpdatedAt = time.Now()
+
+	newID := newSession(t, fs)
+	if n := fs.SessionCount(); n != 3 {
+		t.Errorf("%d sessions, want the cap of 3", n)
+	}
+	if fs.SessionExists(ids[1]) {
+		t.Error("least recently used session was kept")
+	}
+	for _, id := range []string{ids[0], ids[2], newID} {
+		if !fs.SessionExists(id) {
+			t.Errorf("session %s was evicted", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(fs.baseDir, ids[1]+".json")); !os.IsNotExist(err) {
+		t.Errorf("evicted session file still exists: %v", err)
+	}
+}
+
+func TestSetLastExport(t *testing.T) {
+	fs := newTestStore(t)
+	id := newSession(t, fs)
+
+	// An edit saved while the export was being generated
+	xf, err := fs.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	xf.OriginalPOS = "edited.pos"
+	if err := fs.UpdateSession(id, xf); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+
+	job := &models.ExportInfo{JobID: "a1b2c3d4", Time: time.Now().UTC().Truncate(time.Second), Filename: "board_a1b2c3d4.zip"}
+	if err := fs.SetLastExport(id, DefaultProject, job); err != nil {
+		t.Fatalf("SetLastExport: %v", err)
+	}
+
+	got, err := fs.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.OriginalPOS != "edited.pos" {
+		t.Errorf("OriginalPOS %q: the concurrent edit was overwritten", got.OriginalPOS)
+	}
+	if last := got.Metadata.LastExport; last == nil || *last != *job {
+		t.Errorf("LastExport %+v, want %+v", last, job)
+	}
+	if len(fs.sessions[id].History[DefaultProject]) != 1 {
+		t.Errorf("%d undo steps, want only the edit's", len(fs.sessions[id].History[DefaultProject]))
+	}
+
+	// Persisted with the session
+	reloaded := loadStore(t, fs.baseDir, time.Hour)
+	xf, err = reloaded.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession after reload: %v", err)
+	}
+	if last := xf.Metadata.LastExport; last == nil || last.JobID != job.JobID {
+		t.Errorf("reloaded LastExport %+v, want job %s", last, job.JobID)
+	}
+
+	if err := fs.SetLastExport(id, "missing", job); err == nil {
+		t.Error("SetLastExport on a missing project succeeded")
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadStore(t, dir, time.Hour)
+	newSession(t, fs)
+	fs.IncrementPOSUploads()
+	for i := 0; i < 3; i++ {
+		fs.IncrementExports()
+	}
+	fs.IncrementValidationFailures()
+	fs.IncrementValidationFailures()
+
+	want := Stats{TotalUsers: 1, TotalPOSUploads: 1, TotalExports: 3, TotalValidationFailures: 2}
+	if got := fs.GetStats(); got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+
+	// Counters are saved as they change and survive a restart
+	if got := loadStore(t, dir, time.Hour).GetStats(); got != want {
+		t.Errorf("reloaded stats %+v, want %+v", got, want)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadStore(t, dir, time.Hour)
+	id := newSession(t, fs)
+	if err := fs.CreateProject(id, "panel-a"); err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	xf := models.NewXFile()
+	xf.OriginalPOS = "panel-a.pos"
+	if err := fs.UpdateProject(id, "panel-a", xf); err != nil {
+		t.Fatalf("UpdateProject: %v", err)
+	}
+	fs.IncrementExports()
+
+	// Lose the files written so far, so only Flush can bring them back
+	files := []string{
+		filepath.Join(dir, id+".json"),
+		filepath.Join(dir, id, "panel-a.json"),
+		filepath.Join(dir, "stats.json"),
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			t.Fatalf("remove %s: %v", f, err)
+		}
+	}
+
+	if err := fs.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("Flush did not write %s: %v", f, err)
+		}
+	}
+
+	reloaded := loadStore(t, dir, time.Hour)
+	if got, err := reloaded.GetProject(id, "panel-a"); err != nil || got.OriginalPOS != "panel-a.pos" {
+		t.Errorf("reloaded project = %v, %v", got, err)
+	}
+	if got := reloaded.GetStats(); got.TotalUsers != 1 || got.TotalExports != 1 {
+		t.Errorf("reloaded stats %+v", got)
+	}
+}